@@ -25,6 +25,78 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoRollbackPolicy) DeepCopyInto(out *AutoRollbackPolicy) {
+	*out = *in
+	if in.HealthCheckGracePeriod != nil {
+		in, out := &in.HealthCheckGracePeriod, &out.HealthCheckGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoRollbackPolicy.
+func (in *AutoRollbackPolicy) DeepCopy() *AutoRollbackPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoRollbackPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoRollbackStatus) DeepCopyInto(out *AutoRollbackStatus) {
+	*out = *in
+	in.TriggeredAt.DeepCopyInto(&out.TriggeredAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoRollbackStatus.
+func (in *AutoRollbackStatus) DeepCopy() *AutoRollbackStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoRollbackStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CompatibilityFinding) DeepCopyInto(out *CompatibilityFinding) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompatibilityFinding.
+func (in *CompatibilityFinding) DeepCopy() *CompatibilityFinding {
+	if in == nil {
+		return nil
+	}
+	out := new(CompatibilityFinding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CompatibilityReport) DeepCopyInto(out *CompatibilityReport) {
+	*out = *in
+	in.CheckedAt.DeepCopyInto(&out.CheckedAt)
+	if in.Findings != nil {
+		in, out := &in.Findings, &out.Findings
+		*out = make([]CompatibilityFinding, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompatibilityReport.
+func (in *CompatibilityReport) DeepCopy() *CompatibilityReport {
+	if in == nil {
+		return nil
+	}
+	out := new(CompatibilityReport)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapRef) DeepCopyInto(out *ConfigMapRef) {
 	*out = *in
@@ -40,6 +112,71 @@ func (in *ConfigMapRef) DeepCopy() *ConfigMapRef {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPProbe) DeepCopyInto(out *HTTPProbe) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPProbe.
+func (in *HTTPProbe) DeepCopy() *HTTPProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheckResult) DeepCopyInto(out *HealthCheckResult) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheckResult.
+func (in *HealthCheckResult) DeepCopy() *HealthCheckResult {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheckResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthGate) DeepCopyInto(out *HealthGate) {
+	*out = *in
+	if in.SkippedChecks != nil {
+		in, out := &in.SkippedChecks, &out.SkippedChecks
+		*out = make([]HealthCheckName, len(*in))
+		copy(*out, *in)
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Workloads != nil {
+		in, out := &in.Workloads, &out.Workloads
+		*out = make([]WorkloadSelector, len(*in))
+		copy(*out, *in)
+	}
+	if in.HTTPProbes != nil {
+		in, out := &in.HTTPProbes, &out.HTTPProbes
+		*out = make([]HTTPProbe, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthGate.
+func (in *HealthGate) DeepCopy() *HealthGate {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthGate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageBasedUpgrade) DeepCopyInto(out *ImageBasedUpgrade) {
 	*out = *in
@@ -102,14 +239,19 @@ func (in *ImageBasedUpgradeList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageBasedUpgradeSpec) DeepCopyInto(out *ImageBasedUpgradeSpec) {
 	*out = *in
-	out.SeedImageRef = in.SeedImageRef
+	in.SeedImageRef.DeepCopyInto(&out.SeedImageRef)
 	out.AdditionalImages = in.AdditionalImages
+	in.Precache.DeepCopyInto(&out.Precache)
 	out.OADPContent = in.OADPContent
 	if in.ExtraManifests != nil {
 		in, out := &in.ExtraManifests, &out.ExtraManifests
 		*out = make([]ConfigMapRef, len(*in))
 		copy(*out, *in)
 	}
+	in.Timeouts.DeepCopyInto(&out.Timeouts)
+	in.AutoRollback.DeepCopyInto(&out.AutoRollback)
+	in.PrePivotHealthGate.DeepCopyInto(&out.PrePivotHealthGate)
+	in.PostPivotHealthGate.DeepCopyInto(&out.PostPivotHealthGate)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageBasedUpgradeSpec.
@@ -125,11 +267,79 @@ func (in *ImageBasedUpgradeSpec) DeepCopy() *ImageBasedUpgradeSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageBasedUpgradeStatus) DeepCopyInto(out *ImageBasedUpgradeStatus) {
 	*out = *in
+	if in.ValidNextStages != nil {
+		in, out := &in.ValidNextStages, &out.ValidNextStages
+		*out = make([]ImageBasedUpgradeStage, len(*in))
+		copy(*out, *in)
+	}
 	in.StartedAt.DeepCopyInto(&out.StartedAt)
 	in.CompletedAt.DeepCopyInto(&out.CompletedAt)
 	if in.StateRoots != nil {
 		in, out := &in.StateRoots, &out.StateRoots
 		*out = make([]StateRoot, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Progress != nil {
+		in, out := &in.Progress, &out.Progress
+		*out = new(StageRun)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]StageRun, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AutoRollback != nil {
+		in, out := &in.AutoRollback, &out.AutoRollback
+		*out = new(AutoRollbackStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SeedImage != nil {
+		in, out := &in.SeedImage, &out.SeedImage
+		*out = new(SeedImageStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Compatibility != nil {
+		in, out := &in.Compatibility, &out.Compatibility
+		*out = new(CompatibilityReport)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Precache != nil {
+		in, out := &in.Precache, &out.Precache
+		*out = new(PrecacheStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Backups != nil {
+		in, out := &in.Backups, &out.Backups
+		*out = make([]OADPOperation, len(*in))
+		copy(*out, *in)
+	}
+	if in.Restores != nil {
+		in, out := &in.Restores, &out.Restores
+		*out = make([]OADPOperation, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExtraManifests != nil {
+		in, out := &in.ExtraManifests, &out.ExtraManifests
+		*out = make([]ManifestResult, len(*in))
+		copy(*out, *in)
+	}
+	if in.PivotedAt != nil {
+		in, out := &in.PivotedAt, &out.PivotedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.PrePivotHealth != nil {
+		in, out := &in.PrePivotHealth, &out.PrePivotHealth
+		*out = make([]HealthCheckResult, len(*in))
+		copy(*out, *in)
+	}
+	if in.PostPivotHealth != nil {
+		in, out := &in.PostPivotHealth, &out.PostPivotHealth
+		*out = make([]HealthCheckResult, len(*in))
 		copy(*out, *in)
 	}
 	if in.Conditions != nil {
@@ -151,9 +361,154 @@ func (in *ImageBasedUpgradeStatus) DeepCopy() *ImageBasedUpgradeStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestResult) DeepCopyInto(out *ManifestResult) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestResult.
+func (in *ManifestResult) DeepCopy() *ManifestResult {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MirroredImage) DeepCopyInto(out *MirroredImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MirroredImage.
+func (in *MirroredImage) DeepCopy() *MirroredImage {
+	if in == nil {
+		return nil
+	}
+	out := new(MirroredImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OADPOperation) DeepCopyInto(out *OADPOperation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OADPOperation.
+func (in *OADPOperation) DeepCopy() *OADPOperation {
+	if in == nil {
+		return nil
+	}
+	out := new(OADPOperation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrecacheConfig) DeepCopyInto(out *PrecacheConfig) {
+	*out = *in
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrecacheConfig.
+func (in *PrecacheConfig) DeepCopy() *PrecacheConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PrecacheConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrecacheFailure) DeepCopyInto(out *PrecacheFailure) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrecacheFailure.
+func (in *PrecacheFailure) DeepCopy() *PrecacheFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(PrecacheFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrecacheStatus) DeepCopyInto(out *PrecacheStatus) {
+	*out = *in
+	if in.FailedImages != nil {
+		in, out := &in.FailedImages, &out.FailedImages
+		*out = make([]PrecacheFailure, len(*in))
+		copy(*out, *in)
+	}
+	if in.MirroredImages != nil {
+		in, out := &in.MirroredImages, &out.MirroredImages
+		*out = make([]MirroredImage, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrecacheStatus.
+func (in *PrecacheStatus) DeepCopy() *PrecacheStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PrecacheStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullSecretRef) DeepCopyInto(out *PullSecretRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullSecretRef.
+func (in *PullSecretRef) DeepCopy() *PullSecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(PullSecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SeedClusterInfo) DeepCopyInto(out *SeedClusterInfo) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SeedClusterInfo.
+func (in *SeedClusterInfo) DeepCopy() *SeedClusterInfo {
+	if in == nil {
+		return nil
+	}
+	out := new(SeedClusterInfo)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SeedImageRef) DeepCopyInto(out *SeedImageRef) {
 	*out = *in
+	if in.PullSecretRef != nil {
+		in, out := &in.PullSecretRef, &out.PullSecretRef
+		*out = new(PullSecretRef)
+		**out = **in
+	}
+	if in.SignatureVerification != nil {
+		in, out := &in.SignatureVerification, &out.SignatureVerification
+		*out = new(SignatureVerification)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SeedImageRef.
@@ -166,9 +521,122 @@ func (in *SeedImageRef) DeepCopy() *SeedImageRef {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SeedImageStatus) DeepCopyInto(out *SeedImageStatus) {
+	*out = *in
+	if in.BuildTime != nil {
+		in, out := &in.BuildTime, &out.BuildTime
+		*out = (*in).DeepCopy()
+	}
+	out.SeedCluster = in.SeedCluster
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SeedImageStatus.
+func (in *SeedImageStatus) DeepCopy() *SeedImageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SeedImageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SignatureVerification) DeepCopyInto(out *SignatureVerification) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SignatureVerification.
+func (in *SignatureVerification) DeepCopy() *SignatureVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(SignatureVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StageRun) DeepCopyInto(out *StageRun) {
+	*out = *in
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	if in.CompletedAt != nil {
+		in, out := &in.CompletedAt, &out.CompletedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]Step, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StageRun.
+func (in *StageRun) DeepCopy() *StageRun {
+	if in == nil {
+		return nil
+	}
+	out := new(StageRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StageTimeouts) DeepCopyInto(out *StageTimeouts) {
+	*out = *in
+	if in.Prep != nil {
+		in, out := &in.Prep, &out.Prep
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Upgrade != nil {
+		in, out := &in.Upgrade, &out.Upgrade
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Rollback != nil {
+		in, out := &in.Rollback, &out.Rollback
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Abort != nil {
+		in, out := &in.Abort, &out.Abort
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Finalize != nil {
+		in, out := &in.Finalize, &out.Finalize
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StageTimeouts.
+func (in *StageTimeouts) DeepCopy() *StageTimeouts {
+	if in == nil {
+		return nil
+	}
+	out := new(StageTimeouts)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StateRoot) DeepCopyInto(out *StateRoot) {
 	*out = *in
+	in.CreatedAt.DeepCopyInto(&out.CreatedAt)
+	if in.SavedStates != nil {
+		in, out := &in.SavedStates, &out.SavedStates
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StateRoot.
@@ -180,3 +648,46 @@ func (in *StateRoot) DeepCopy() *StateRoot {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Step) DeepCopyInto(out *Step) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletedAt != nil {
+		in, out := &in.CompletedAt, &out.CompletedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Step.
+func (in *Step) DeepCopy() *Step {
+	if in == nil {
+		return nil
+	}
+	out := new(Step)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadSelector) DeepCopyInto(out *WorkloadSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadSelector.
+func (in *WorkloadSelector) DeepCopy() *WorkloadSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadSelector)
+	in.DeepCopyInto(out)
+	return out
+}
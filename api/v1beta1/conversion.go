@@ -0,0 +1,506 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+)
+
+// ConversionDataAnnotation keeps the v1beta1 fields that v1alpha1 cannot represent, so that
+// a v1beta1 object survives a round trip through the v1alpha1 storage version
+const ConversionDataAnnotation = "ran.openshift.io/v1beta1-conversion-data"
+
+type conversionData struct {
+	RollbackTargetVersion string `json:"rollbackTargetVersion,omitempty"`
+}
+
+var _ conversion.Convertible = &ImageBasedUpgrade{}
+
+// ConvertTo converts this ImageBasedUpgrade to the hub version (v1alpha1)
+func (src *ImageBasedUpgrade) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1alpha1.ImageBasedUpgrade)
+	if !ok {
+		return fmt.Errorf("unsupported hub type %T", dstRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	data := conversionData{RollbackTargetVersion: src.Spec.Rollback.Target.Version}
+	if data != (conversionData{}) {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal conversion data: %w", err)
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[ConversionDataAnnotation] = string(raw)
+	} else {
+		delete(dst.Annotations, ConversionDataAnnotation)
+	}
+
+	dst.Spec = v1alpha1.ImageBasedUpgradeSpec{
+		Stage:            v1alpha1.ImageBasedUpgradeStage(src.Spec.Stage),
+		SeedImageRef:     seedImageRefToHub(src.Spec.SeedImageRef),
+		AdditionalImages: v1alpha1.ConfigMapRef(src.Spec.Prep.AdditionalImages),
+		Precache: v1alpha1.PrecacheConfig{
+			Parallelism: src.Spec.Prep.Precache.Parallelism,
+			Retries:     copyInt32(src.Spec.Prep.Precache.Retries),
+		},
+		OADPContent:                  v1alpha1.ConfigMapRef(src.Spec.Upgrade.OADPContent),
+		ExtraManifestsServiceAccount: src.Spec.Upgrade.ExtraManifestsServiceAccount,
+		RollbackTarget:               src.Spec.Rollback.Target.Stateroot,
+		Timeouts: v1alpha1.StageTimeouts{
+			Prep:      copyDuration(src.Spec.Timeouts.Prep),
+			Upgrade:   copyDuration(src.Spec.Timeouts.Upgrade),
+			Rollback:  copyDuration(src.Spec.Timeouts.Rollback),
+			Abort:     copyDuration(src.Spec.Timeouts.Abort),
+			Finalize:  copyDuration(src.Spec.Timeouts.Finalize),
+			OnTimeout: v1alpha1.TimeoutAction(src.Spec.Timeouts.OnTimeout),
+		},
+		AutoRollback: v1alpha1.AutoRollbackPolicy{
+			Enabled:                src.Spec.AutoRollback.Enabled,
+			HealthCheckGracePeriod: copyDuration(src.Spec.AutoRollback.HealthCheckGracePeriod),
+		},
+	}
+	for _, ref := range src.Spec.Upgrade.ExtraManifests {
+		dst.Spec.ExtraManifests = append(dst.Spec.ExtraManifests, v1alpha1.ConfigMapRef(ref))
+	}
+	dst.Spec.PrePivotHealthGate = healthGateToHub(src.Spec.Upgrade.PrePivotHealthGate)
+	dst.Spec.PostPivotHealthGate = healthGateToHub(src.Spec.Upgrade.PostPivotHealthGate)
+
+	dst.Status = v1alpha1.ImageBasedUpgradeStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		State:              src.Status.State,
+		StartedAt:          fromTimePtr(src.Status.StartedAt),
+		CompletedAt:        fromTimePtr(src.Status.CompletedAt),
+		Conditions:         copyConditions(src.Status.Conditions),
+	}
+	for _, stage := range src.Status.ValidNextStages {
+		dst.Status.ValidNextStages = append(dst.Status.ValidNextStages, v1alpha1.ImageBasedUpgradeStage(stage))
+	}
+	for _, stateRoot := range src.Status.StateRoots {
+		dst.Status.StateRoots = append(dst.Status.StateRoots, v1alpha1.StateRoot(*stateRoot.DeepCopy()))
+	}
+	if src.Status.Progress != nil {
+		progress := stageRunToHub(*src.Status.Progress)
+		dst.Status.Progress = &progress
+	}
+	for _, run := range src.Status.History {
+		dst.Status.History = append(dst.Status.History, stageRunToHub(run))
+	}
+	if src.Status.AutoRollback != nil {
+		dst.Status.AutoRollback = &v1alpha1.AutoRollbackStatus{
+			Trigger:     v1alpha1.AutoRollbackTrigger(src.Status.AutoRollback.Trigger),
+			Reason:      src.Status.AutoRollback.Reason,
+			TriggeredAt: *src.Status.AutoRollback.TriggeredAt.DeepCopy(),
+		}
+	}
+	if src.Status.SeedImage != nil {
+		seedImage := src.Status.SeedImage
+		dst.Status.SeedImage = &v1alpha1.SeedImageStatus{
+			Image:               seedImage.Image,
+			Digest:              seedImage.Digest,
+			Version:             seedImage.Version,
+			ReleaseImage:        seedImage.ReleaseImage,
+			Architecture:        seedImage.Architecture,
+			BuildTime:           seedImage.BuildTime.DeepCopy(),
+			SeedCluster:         v1alpha1.SeedClusterInfo(seedImage.SeedCluster),
+			PulledFrom:          seedImage.PulledFrom,
+			SignatureVerifiedBy: seedImage.SignatureVerifiedBy,
+		}
+	}
+	if src.Status.Compatibility != nil {
+		dst.Status.Compatibility = compatibilityToHub(src.Status.Compatibility)
+	}
+	if src.Status.Precache != nil {
+		precache := v1alpha1.PrecacheStatus{
+			Total:    src.Status.Precache.Total,
+			Pulled:   src.Status.Precache.Pulled,
+			Skipped:  src.Status.Precache.Skipped,
+			Failed:   src.Status.Precache.Failed,
+			Mirrored: src.Status.Precache.Mirrored,
+		}
+		for _, failure := range src.Status.Precache.FailedImages {
+			precache.FailedImages = append(precache.FailedImages, v1alpha1.PrecacheFailure(failure))
+		}
+		for _, mirrored := range src.Status.Precache.MirroredImages {
+			precache.MirroredImages = append(precache.MirroredImages, v1alpha1.MirroredImage(mirrored))
+		}
+		dst.Status.Precache = &precache
+	}
+	for _, backup := range src.Status.Backups {
+		dst.Status.Backups = append(dst.Status.Backups, v1alpha1.OADPOperation(backup))
+	}
+	for _, restore := range src.Status.Restores {
+		dst.Status.Restores = append(dst.Status.Restores, v1alpha1.OADPOperation(restore))
+	}
+	for _, result := range src.Status.ExtraManifests {
+		dst.Status.ExtraManifests = append(dst.Status.ExtraManifests, manifestResultToHub(result))
+	}
+	dst.Status.PivotedAt = src.Status.PivotedAt.DeepCopy()
+	for _, result := range src.Status.PrePivotHealth {
+		dst.Status.PrePivotHealth = append(dst.Status.PrePivotHealth, v1alpha1.HealthCheckResult(result))
+	}
+	for _, result := range src.Status.PostPivotHealth {
+		dst.Status.PostPivotHealth = append(dst.Status.PostPivotHealth, v1alpha1.HealthCheckResult(result))
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v1alpha1) to this version
+func (dst *ImageBasedUpgrade) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1alpha1.ImageBasedUpgrade)
+	if !ok {
+		return fmt.Errorf("unsupported hub type %T", srcRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	data := conversionData{}
+	if raw, found := dst.Annotations[ConversionDataAnnotation]; found {
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			return fmt.Errorf("failed to unmarshal conversion data: %w", err)
+		}
+		delete(dst.Annotations, ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	dst.Spec = ImageBasedUpgradeSpec{
+		Stage:        ImageBasedUpgradeStage(src.Spec.Stage),
+		SeedImageRef: seedImageRefFromHub(src.Spec.SeedImageRef),
+		Prep: PrepSpec{
+			AdditionalImages: ConfigMapRef(src.Spec.AdditionalImages),
+			Precache: PrecacheConfig{
+				Parallelism: src.Spec.Precache.Parallelism,
+				Retries:     copyInt32(src.Spec.Precache.Retries),
+			},
+		},
+		Upgrade: UpgradeSpec{
+			OADPContent: ConfigMapRef(src.Spec.OADPContent),
+		},
+		Rollback: RollbackSpec{
+			Target: RollbackTarget{
+				Stateroot: src.Spec.RollbackTarget,
+				Version:   data.RollbackTargetVersion,
+			},
+		},
+		Timeouts: StageTimeouts{
+			Prep:      copyDuration(src.Spec.Timeouts.Prep),
+			Upgrade:   copyDuration(src.Spec.Timeouts.Upgrade),
+			Rollback:  copyDuration(src.Spec.Timeouts.Rollback),
+			Abort:     copyDuration(src.Spec.Timeouts.Abort),
+			Finalize:  copyDuration(src.Spec.Timeouts.Finalize),
+			OnTimeout: TimeoutAction(src.Spec.Timeouts.OnTimeout),
+		},
+		AutoRollback: AutoRollbackPolicy{
+			Enabled:                src.Spec.AutoRollback.Enabled,
+			HealthCheckGracePeriod: copyDuration(src.Spec.AutoRollback.HealthCheckGracePeriod),
+		},
+	}
+	for _, ref := range src.Spec.ExtraManifests {
+		dst.Spec.Upgrade.ExtraManifests = append(dst.Spec.Upgrade.ExtraManifests, ConfigMapRef(ref))
+	}
+	dst.Spec.Upgrade.ExtraManifestsServiceAccount = src.Spec.ExtraManifestsServiceAccount
+	dst.Spec.Upgrade.PrePivotHealthGate = healthGateFromHub(src.Spec.PrePivotHealthGate)
+	dst.Spec.Upgrade.PostPivotHealthGate = healthGateFromHub(src.Spec.PostPivotHealthGate)
+
+	dst.Status = ImageBasedUpgradeStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		State:              src.Status.State,
+		StartedAt:          toTimePtr(src.Status.StartedAt),
+		CompletedAt:        toTimePtr(src.Status.CompletedAt),
+		Conditions:         copyConditions(src.Status.Conditions),
+	}
+	for _, stage := range src.Status.ValidNextStages {
+		dst.Status.ValidNextStages = append(dst.Status.ValidNextStages, ImageBasedUpgradeStage(stage))
+	}
+	for _, stateRoot := range src.Status.StateRoots {
+		dst.Status.StateRoots = append(dst.Status.StateRoots, StateRoot(*stateRoot.DeepCopy()))
+	}
+	if src.Status.Progress != nil {
+		progress := stageRunFromHub(*src.Status.Progress)
+		dst.Status.Progress = &progress
+	}
+	for _, run := range src.Status.History {
+		dst.Status.History = append(dst.Status.History, stageRunFromHub(run))
+	}
+	if src.Status.AutoRollback != nil {
+		dst.Status.AutoRollback = &AutoRollbackStatus{
+			Trigger:     AutoRollbackTrigger(src.Status.AutoRollback.Trigger),
+			Reason:      src.Status.AutoRollback.Reason,
+			TriggeredAt: *src.Status.AutoRollback.TriggeredAt.DeepCopy(),
+		}
+	}
+	if src.Status.SeedImage != nil {
+		seedImage := src.Status.SeedImage
+		dst.Status.SeedImage = &SeedImageStatus{
+			Image:               seedImage.Image,
+			Digest:              seedImage.Digest,
+			Version:             seedImage.Version,
+			ReleaseImage:        seedImage.ReleaseImage,
+			Architecture:        seedImage.Architecture,
+			BuildTime:           seedImage.BuildTime.DeepCopy(),
+			SeedCluster:         SeedClusterInfo(seedImage.SeedCluster),
+			PulledFrom:          seedImage.PulledFrom,
+			SignatureVerifiedBy: seedImage.SignatureVerifiedBy,
+		}
+	}
+	if src.Status.Compatibility != nil {
+		dst.Status.Compatibility = compatibilityFromHub(src.Status.Compatibility)
+	}
+	if src.Status.Precache != nil {
+		precache := PrecacheStatus{
+			Total:    src.Status.Precache.Total,
+			Pulled:   src.Status.Precache.Pulled,
+			Skipped:  src.Status.Precache.Skipped,
+			Failed:   src.Status.Precache.Failed,
+			Mirrored: src.Status.Precache.Mirrored,
+		}
+		for _, failure := range src.Status.Precache.FailedImages {
+			precache.FailedImages = append(precache.FailedImages, PrecacheFailure(failure))
+		}
+		for _, mirrored := range src.Status.Precache.MirroredImages {
+			precache.MirroredImages = append(precache.MirroredImages, MirroredImage(mirrored))
+		}
+		dst.Status.Precache = &precache
+	}
+	for _, backup := range src.Status.Backups {
+		dst.Status.Backups = append(dst.Status.Backups, OADPOperation(backup))
+	}
+	for _, restore := range src.Status.Restores {
+		dst.Status.Restores = append(dst.Status.Restores, OADPOperation(restore))
+	}
+	for _, result := range src.Status.ExtraManifests {
+		dst.Status.ExtraManifests = append(dst.Status.ExtraManifests, manifestResultFromHub(result))
+	}
+	dst.Status.PivotedAt = src.Status.PivotedAt.DeepCopy()
+	for _, result := range src.Status.PrePivotHealth {
+		dst.Status.PrePivotHealth = append(dst.Status.PrePivotHealth, HealthCheckResult(result))
+	}
+	for _, result := range src.Status.PostPivotHealth {
+		dst.Status.PostPivotHealth = append(dst.Status.PostPivotHealth, HealthCheckResult(result))
+	}
+	return nil
+}
+
+func stageRunToHub(run StageRun) v1alpha1.StageRun {
+	out := v1alpha1.StageRun{
+		Name:        run.Name,
+		Outcome:     v1alpha1.StageRunOutcome(run.Outcome),
+		StartedAt:   *run.StartedAt.DeepCopy(),
+		CompletedAt: run.CompletedAt.DeepCopy(),
+		Duration:    copyDuration(run.Duration),
+		Message:     run.Message,
+	}
+	for _, step := range run.Steps {
+		out.Steps = append(out.Steps, v1alpha1.Step{
+			Name:        step.Name,
+			State:       v1alpha1.StepState(step.State),
+			StartedAt:   step.StartedAt.DeepCopy(),
+			CompletedAt: step.CompletedAt.DeepCopy(),
+			Duration:    copyDuration(step.Duration),
+			Message:     step.Message,
+		})
+	}
+	return out
+}
+
+func stageRunFromHub(run v1alpha1.StageRun) StageRun {
+	out := StageRun{
+		Name:        run.Name,
+		Outcome:     StageRunOutcome(run.Outcome),
+		StartedAt:   *run.StartedAt.DeepCopy(),
+		CompletedAt: run.CompletedAt.DeepCopy(),
+		Duration:    copyDuration(run.Duration),
+		Message:     run.Message,
+	}
+	for _, step := range run.Steps {
+		out.Steps = append(out.Steps, Step{
+			Name:        step.Name,
+			State:       StepState(step.State),
+			StartedAt:   step.StartedAt.DeepCopy(),
+			CompletedAt: step.CompletedAt.DeepCopy(),
+			Duration:    copyDuration(step.Duration),
+			Message:     step.Message,
+		})
+	}
+	return out
+}
+
+func compatibilityToHub(report *CompatibilityReport) *v1alpha1.CompatibilityReport {
+	out := &v1alpha1.CompatibilityReport{
+		Compatible:       report.Compatible,
+		CheckedAt:        *report.CheckedAt.DeepCopy(),
+		BlockingFindings: report.BlockingFindings,
+		WarningFindings:  report.WarningFindings,
+		ConfigMap:        report.ConfigMap,
+	}
+	for _, finding := range report.Findings {
+		out.Findings = append(out.Findings, v1alpha1.CompatibilityFinding{
+			Property: finding.Property,
+			Severity: v1alpha1.FindingSeverity(finding.Severity),
+			Seed:     finding.Seed,
+			Cluster:  finding.Cluster,
+			Message:  finding.Message,
+		})
+	}
+	return out
+}
+
+func compatibilityFromHub(report *v1alpha1.CompatibilityReport) *CompatibilityReport {
+	out := &CompatibilityReport{
+		Compatible:       report.Compatible,
+		CheckedAt:        *report.CheckedAt.DeepCopy(),
+		BlockingFindings: report.BlockingFindings,
+		WarningFindings:  report.WarningFindings,
+		ConfigMap:        report.ConfigMap,
+	}
+	for _, finding := range report.Findings {
+		out.Findings = append(out.Findings, CompatibilityFinding{
+			Property: finding.Property,
+			Severity: FindingSeverity(finding.Severity),
+			Seed:     finding.Seed,
+			Cluster:  finding.Cluster,
+			Message:  finding.Message,
+		})
+	}
+	return out
+}
+
+func copyDuration(d *metav1.Duration) *metav1.Duration {
+	if d == nil {
+		return nil
+	}
+	out := *d
+	return &out
+}
+
+func seedImageRefToHub(src SeedImageRef) v1alpha1.SeedImageRef {
+	dst := v1alpha1.SeedImageRef{Version: src.Version, Image: src.Image}
+	if src.PullSecretRef != nil {
+		dst.PullSecretRef = &v1alpha1.PullSecretRef{Name: src.PullSecretRef.Name}
+	}
+	if src.SignatureVerification != nil {
+		verification := v1alpha1.SignatureVerification(*src.SignatureVerification)
+		dst.SignatureVerification = &verification
+	}
+	return dst
+}
+
+func seedImageRefFromHub(src v1alpha1.SeedImageRef) SeedImageRef {
+	dst := SeedImageRef{Version: src.Version, Image: src.Image}
+	if src.PullSecretRef != nil {
+		dst.PullSecretRef = &PullSecretRef{Name: src.PullSecretRef.Name}
+	}
+	if src.SignatureVerification != nil {
+		verification := SignatureVerification(*src.SignatureVerification)
+		dst.SignatureVerification = &verification
+	}
+	return dst
+}
+
+func manifestResultToHub(src ManifestResult) v1alpha1.ManifestResult {
+	return v1alpha1.ManifestResult{
+		ConfigMap:  src.ConfigMap,
+		APIVersion: src.APIVersion,
+		Kind:       src.Kind,
+		Namespace:  src.Namespace,
+		Name:       src.Name,
+		Outcome:    v1alpha1.ManifestOutcome(src.Outcome),
+		Message:    src.Message,
+	}
+}
+
+func manifestResultFromHub(src v1alpha1.ManifestResult) ManifestResult {
+	return ManifestResult{
+		ConfigMap:  src.ConfigMap,
+		APIVersion: src.APIVersion,
+		Kind:       src.Kind,
+		Namespace:  src.Namespace,
+		Name:       src.Name,
+		Outcome:    ManifestOutcome(src.Outcome),
+		Message:    src.Message,
+	}
+}
+
+func healthGateToHub(src HealthGate) v1alpha1.HealthGate {
+	dst := v1alpha1.HealthGate{Timeout: copyDuration(src.Timeout)}
+	for _, check := range src.SkippedChecks {
+		dst.SkippedChecks = append(dst.SkippedChecks, v1alpha1.HealthCheckName(check))
+	}
+	for _, workload := range src.Workloads {
+		dst.Workloads = append(dst.Workloads, v1alpha1.WorkloadSelector(workload))
+	}
+	for _, probe := range src.HTTPProbes {
+		dst.HTTPProbes = append(dst.HTTPProbes, v1alpha1.HTTPProbe(probe))
+	}
+	return dst
+}
+
+func healthGateFromHub(src v1alpha1.HealthGate) HealthGate {
+	dst := HealthGate{Timeout: copyDuration(src.Timeout)}
+	for _, check := range src.SkippedChecks {
+		dst.SkippedChecks = append(dst.SkippedChecks, HealthCheckName(check))
+	}
+	for _, workload := range src.Workloads {
+		dst.Workloads = append(dst.Workloads, WorkloadSelector(workload))
+	}
+	for _, probe := range src.HTTPProbes {
+		dst.HTTPProbes = append(dst.HTTPProbes, HTTPProbe(probe))
+	}
+	return dst
+}
+
+func copyInt32(i *int32) *int32 {
+	if i == nil {
+		return nil
+	}
+	out := *i
+	return &out
+}
+
+func toTimePtr(t metav1.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return t.DeepCopy()
+}
+
+func fromTimePtr(t *metav1.Time) metav1.Time {
+	if t == nil {
+		return metav1.Time{}
+	}
+	return *t.DeepCopy()
+}
+
+func copyConditions(conditions []metav1.Condition) []metav1.Condition {
+	if conditions == nil {
+		return nil
+	}
+	out := make([]metav1.Condition, len(conditions))
+	for i := range conditions {
+		conditions[i].DeepCopyInto(&out[i])
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/assert"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+)
+
+const fuzzIterations = 200
+
+func newFuzzer(seed int64) *fuzz.Fuzzer {
+	// Empty slices and maps are not distinguishable from nil once converted, so only generate nil or populated ones
+	return fuzz.NewWithSeed(seed).NilChance(0.2).NumElements(1, 3)
+}
+
+func TestRoundTripFromHub(t *testing.T) {
+	for i := int64(0); i < fuzzIterations; i++ {
+		original := &v1alpha1.ImageBasedUpgrade{}
+		newFuzzer(i).Fuzz(original)
+		// TypeMeta is set by the conversion machinery, not by the conversion functions
+		original.TypeMeta = metav1.TypeMeta{}
+		delete(original.Annotations, ConversionDataAnnotation)
+
+		spoke := &ImageBasedUpgrade{}
+		assert.NoError(t, spoke.ConvertFrom(original.DeepCopy()))
+		hub := &v1alpha1.ImageBasedUpgrade{}
+		assert.NoError(t, spoke.ConvertTo(hub))
+
+		if !apiequality.Semantic.DeepEqual(original, hub) {
+			t.Fatalf("v1alpha1 -> v1beta1 -> v1alpha1 round trip changed the object (seed %d): %s", i, diff.ObjectReflectDiff(original, hub))
+		}
+	}
+}
+
+func TestRoundTripFromSpoke(t *testing.T) {
+	for i := int64(0); i < fuzzIterations; i++ {
+		original := &ImageBasedUpgrade{}
+		newFuzzer(i).Fuzz(original)
+		// TypeMeta is set by the conversion machinery, not by the conversion functions
+		original.TypeMeta = metav1.TypeMeta{}
+		delete(original.Annotations, ConversionDataAnnotation)
+
+		hub := &v1alpha1.ImageBasedUpgrade{}
+		assert.NoError(t, original.DeepCopy().ConvertTo(hub))
+		spoke := &ImageBasedUpgrade{}
+		assert.NoError(t, spoke.ConvertFrom(hub))
+
+		if !apiequality.Semantic.DeepEqual(original, spoke) {
+			t.Fatalf("v1beta1 -> v1alpha1 -> v1beta1 round trip changed the object (seed %d): %s", i, diff.ObjectReflectDiff(original, spoke))
+		}
+	}
+}
+
+func TestConvertToHub(t *testing.T) {
+	spoke := &ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: "upgrade", Namespace: "openshift-lifecycle-agent"},
+		Spec: ImageBasedUpgradeSpec{
+			Stage:        Stages.Prep,
+			SeedImageRef: SeedImageRef{Version: "4.14.1", Image: "quay.io/seed:4.14.1"},
+			Prep:         PrepSpec{AdditionalImages: ConfigMapRef{Name: "images", Namespace: "ns"}},
+			Upgrade: UpgradeSpec{
+				OADPContent:                  ConfigMapRef{Name: "oadp", Namespace: "ns"},
+				ExtraManifests:               []ConfigMapRef{{Name: "extra", Namespace: "ns"}},
+				ExtraManifestsServiceAccount: "manifests-applier",
+			},
+			Rollback: RollbackSpec{Target: RollbackTarget{Stateroot: "rhcos", Version: "4.14.0"}},
+		},
+	}
+
+	hub := &v1alpha1.ImageBasedUpgrade{}
+	assert.NoError(t, spoke.ConvertTo(hub))
+	assert.Equal(t, v1alpha1.Stages.Prep, hub.Spec.Stage)
+	assert.Equal(t, "images", hub.Spec.AdditionalImages.Name)
+	assert.Equal(t, "oadp", hub.Spec.OADPContent.Name)
+	assert.Equal(t, []v1alpha1.ConfigMapRef{{Name: "extra", Namespace: "ns"}}, hub.Spec.ExtraManifests)
+	assert.Equal(t, "manifests-applier", hub.Spec.ExtraManifestsServiceAccount)
+	assert.Equal(t, "rhcos", hub.Spec.RollbackTarget)
+	assert.JSONEq(t, `{"rollbackTargetVersion":"4.14.0"}`, hub.Annotations[ConversionDataAnnotation])
+	assert.Nil(t, spoke.Annotations, "the source object must not be modified")
+
+	// Clearing the version drops the annotation
+	spoke.Spec.Rollback.Target.Version = ""
+	assert.NoError(t, spoke.ConvertTo(hub))
+	assert.NotContains(t, hub.Annotations, ConversionDataAnnotation)
+}
+
+func TestConvertFromKeepsState(t *testing.T) {
+	hub := &v1alpha1.ImageBasedUpgrade{
+		Status: v1alpha1.ImageBasedUpgradeStatus{
+			State:           "PrepCompleted",
+			ValidNextStages: []v1alpha1.ImageBasedUpgradeStage{v1alpha1.Stages.Idle, v1alpha1.Stages.Upgrade},
+		},
+	}
+
+	spoke := &ImageBasedUpgrade{}
+	assert.NoError(t, spoke.ConvertFrom(hub))
+	assert.Equal(t, "PrepCompleted", spoke.Status.State)
+	assert.Equal(t, []ImageBasedUpgradeStage{Stages.Idle, Stages.Upgrade}, spoke.Status.ValidNextStages)
+	assert.Nil(t, spoke.Status.StartedAt)
+}
@@ -0,0 +1,524 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:path=imagebasedupgrades,shortName=ibu
+//+kubebuilder:printcolumn:name="Stage",type="string",JSONPath=".spec.stage"
+//+kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.state"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ImageBasedUpgrade is the Schema for the ImageBasedUpgrades API
+// +operator-sdk:csv:customresourcedefinitions:displayName="Image-based Cluster Upgrade",resources={{Namespace, v1},{Deployment,apps/v1}}
+type ImageBasedUpgrade struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageBasedUpgradeSpec   `json:"spec,omitempty"`
+	Status ImageBasedUpgradeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Idle;Prep;Upgrade;Rollback
+type ImageBasedUpgradeStage string
+
+var Stages = struct {
+	Idle     ImageBasedUpgradeStage
+	Prep     ImageBasedUpgradeStage
+	Upgrade  ImageBasedUpgradeStage
+	Rollback ImageBasedUpgradeStage
+}{
+	Idle:     "Idle",
+	Prep:     "Prep",
+	Upgrade:  "Upgrade",
+	Rollback: "Rollback",
+}
+
+// ImageBasedUpgradeSpec defines the desired state of ImageBasedUpgrade
+type ImageBasedUpgradeSpec struct {
+	Stage        ImageBasedUpgradeStage `json:"stage,omitempty"`
+	SeedImageRef SeedImageRef           `json:"seedImageRef,omitempty"`
+	Prep         PrepSpec               `json:"prep,omitempty"`
+	Upgrade      UpgradeSpec            `json:"upgrade,omitempty"`
+	Rollback     RollbackSpec           `json:"rollback,omitempty"`
+	// Timeouts overrides the operator default stage timeouts
+	Timeouts StageTimeouts `json:"timeouts,omitempty"`
+	// AutoRollback rolls back without user action when the Upgrade stage does not succeed
+	AutoRollback AutoRollbackPolicy `json:"autoRollback,omitempty"`
+}
+
+// AutoRollbackPolicy defines when the operator starts the Rollback stage by itself
+type AutoRollbackPolicy struct {
+	// Enabled turns on the rollback when the Upgrade stage fails, times out or the
+	// cluster is not healthy within HealthCheckGracePeriod
+	Enabled bool `json:"enabled,omitempty"`
+	// HealthCheckGracePeriod is how long the cluster may stay unhealthy after the upgrade
+	// before rolling back, unless the post-pivot health gate sets a timeout. Defaults to 10 minutes.
+	HealthCheckGracePeriod *metav1.Duration `json:"healthCheckGracePeriod,omitempty"`
+}
+
+// AutoRollbackTrigger is the event that started an automatic rollback
+// +kubebuilder:validation:Enum=UpgradeFailed;UpgradeTimedOut;HealthCheckFailed
+type AutoRollbackTrigger string
+
+// AutoRollbackStatus records why the operator started the Rollback stage
+type AutoRollbackStatus struct {
+	// Trigger is the event that started the rollback
+	Trigger AutoRollbackTrigger `json:"trigger"`
+	// Reason is the failure that caused the trigger
+	Reason string `json:"reason,omitempty"`
+	// TriggeredAt is when the rollback was requested
+	TriggeredAt metav1.Time `json:"triggeredAt"`
+}
+
+// TimeoutAction is the follow-up action taken when a stage times out
+// +kubebuilder:validation:Enum=None;Abort;Rollback
+type TimeoutAction string
+
+// StageTimeouts defines how long each stage may run and what happens when one runs longer.
+// Unset values fall back to the operator defaults.
+type StageTimeouts struct {
+	// Prep is the maximum duration of the Prep stage
+	Prep *metav1.Duration `json:"prep,omitempty"`
+	// Upgrade is the maximum duration of the Upgrade stage
+	Upgrade *metav1.Duration `json:"upgrade,omitempty"`
+	// Rollback is the maximum duration of the Rollback stage
+	Rollback *metav1.Duration `json:"rollback,omitempty"`
+	// Abort is the maximum duration of an abort
+	Abort *metav1.Duration `json:"abort,omitempty"`
+	// Finalize is the maximum duration of a finalize
+	Finalize *metav1.Duration `json:"finalize,omitempty"`
+	// OnTimeout is the stage requested when a stage times out: None leaves the stage failed,
+	// Abort goes back to Idle and Rollback rolls back, when the state allows it
+	OnTimeout TimeoutAction `json:"onTimeout,omitempty"`
+}
+
+// SeedImageRef defines the seed image and OCP version for the upgrade
+type SeedImageRef struct {
+	Version string `json:"version,omitempty"`
+	Image   string `json:"image,omitempty"`
+	// PullSecretRef is a Secret of type kubernetes.io/dockerconfigjson in the namespace of the
+	// ImageBasedUpgrade. Its credentials are merged over the cluster pull secret to pull the seed
+	// image and the AdditionalImages.
+	PullSecretRef *PullSecretRef `json:"pullSecretRef,omitempty"`
+	// SignatureVerification, if set, has Prep verify the signature of the seed image before using it
+	SignatureVerification *SignatureVerification `json:"signatureVerification,omitempty"`
+}
+
+// SignatureVerification references the ConfigMap or Secret holding what the seed image signature is
+// verified against: either a containers-policy.json(5) document under the policy.json key, or cosign
+// public keys in PEM under keys ending in .pub, one of which must have signed the image. The keys are
+// written in /var/lib/lca/signature-policy on the node, where the policy.json can reference them.
+type SignatureVerification struct {
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the ImageBasedUpgrade
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// PullSecretRef defines a reference to a pull secret
+type PullSecretRef struct {
+	Name string `json:"name"`
+}
+
+// ConfigMapRef defines a reference to a config map
+type ConfigMapRef struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// PrepSpec defines the configuration of the Prep stage
+type PrepSpec struct {
+	AdditionalImages ConfigMapRef `json:"additionalImages,omitempty"`
+	// Precache tunes the pulling of the AdditionalImages
+	Precache PrecacheConfig `json:"precache,omitempty"`
+}
+
+// PrecacheConfig tunes the pulling of the additional images during Prep
+type PrecacheConfig struct {
+	// Parallelism is the number of images pulled at the same time, 4 if not set
+	// +kubebuilder:validation:Minimum=1
+	Parallelism int32 `json:"parallelism,omitempty"`
+	// Retries is the number of times a failed image pull is retried, 3 if not set
+	// +kubebuilder:validation:Minimum=0
+	Retries *int32 `json:"retries,omitempty"`
+}
+
+// UpgradeSpec defines the configuration of the Upgrade stage
+type UpgradeSpec struct {
+	OADPContent    ConfigMapRef   `json:"oadpContent,omitempty"`
+	ExtraManifests []ConfigMapRef `json:"extraManifests,omitempty"`
+	// ExtraManifestsServiceAccount is the ServiceAccount, in the namespace of the ImageBasedUpgrade, the
+	// ExtraManifests are validated and applied as. The operator has no permissions of its own on their objects,
+	// the ServiceAccount must be allowed to get, create and patch them.
+	ExtraManifestsServiceAccount string `json:"extraManifestsServiceAccount,omitempty"`
+	// PrePivotHealthGate configures the health checks the cluster must pass before the node reboots into the
+	// new stateroot. Its timeout defaults to 5 minutes.
+	PrePivotHealthGate HealthGate `json:"prePivotHealthGate,omitempty"`
+	// PostPivotHealthGate configures the health checks the cluster must pass once the node booted the new
+	// stateroot for the upgrade to complete. Its timeout defaults to the auto rollback health check grace period.
+	PostPivotHealthGate HealthGate `json:"postPivotHealthGate,omitempty"`
+}
+
+// RollbackSpec defines the configuration of the Rollback stage
+type RollbackSpec struct {
+	// Target is the stateroot to roll back to, defaulted to the booted one while idle. Its stateroot cannot be
+	// changed once the IBU has left idle, an update leaving it empty keeps the stored one.
+	Target RollbackTarget `json:"target,omitempty"`
+}
+
+// RollbackTarget identifies the stateroot to roll back to
+type RollbackTarget struct {
+	Stateroot string `json:"stateroot,omitempty"`
+	// Version is the OCP version expected in the target stateroot
+	Version string `json:"version,omitempty"`
+}
+
+// ImageBasedUpgradeStatus defines the observed state of ImageBasedUpgrade
+type ImageBasedUpgradeStatus struct {
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Status"
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// State is the lifecycle state derived from the conditions
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="State"
+	State string `json:"state,omitempty"`
+	// ValidNextStages lists the stages that can be requested from the current state
+	ValidNextStages []ImageBasedUpgradeStage `json:"validNextStages,omitempty"`
+	StartedAt       *metav1.Time             `json:"startedAt,omitempty"`
+	CompletedAt     *metav1.Time             `json:"completedAt,omitempty"`
+	StateRoots      []StateRoot              `json:"stateRoots,omitempty"`
+	// Progress reports the steps of the stage run in progress, or of the last one
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Progress"
+	Progress *StageRun `json:"progress,omitempty"`
+	// History lists the previous stage runs, oldest first
+	History []StageRun `json:"history,omitempty"`
+	// AutoRollback is set when the operator started the Rollback stage by itself
+	AutoRollback *AutoRollbackStatus `json:"autoRollback,omitempty"`
+	// SeedImage describes the seed image pulled by the Prep stage
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Seed Image"
+	SeedImage *SeedImageStatus `json:"seedImage,omitempty"`
+	// Compatibility is the result of the comparison of the seed with the cluster
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Compatibility"
+	Compatibility *CompatibilityReport `json:"compatibility,omitempty"`
+	// Precache reports the pulling of the additional images by the Prep stage
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Precache"
+	Precache *PrecacheStatus `json:"precache,omitempty"`
+	// Backups reports the OADP Backups applied by the Upgrade stage before the pivot
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Backups"
+	Backups []OADPOperation `json:"backups,omitempty"`
+	// Restores reports the OADP Restores applied by the Upgrade stage after the pivot
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Restores"
+	Restores []OADPOperation `json:"restores,omitempty"`
+	// ExtraManifests reports the objects of the ExtraManifests, validated by Prep and applied after the pivot
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Extra Manifests"
+	ExtraManifests []ManifestResult `json:"extraManifests,omitempty"`
+	// PivotedAt is when the operator first saw the node running the new stateroot during the Upgrade stage
+	PivotedAt *metav1.Time `json:"pivotedAt,omitempty"`
+	// PrePivotHealth reports the last run of the health checks before the pivot
+	PrePivotHealth []HealthCheckResult `json:"prePivotHealth,omitempty"`
+	// PostPivotHealth reports the last run of the health checks after the pivot
+	PostPivotHealth []HealthCheckResult `json:"postPivotHealth,omitempty"`
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Conditions"
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// StageRunOutcome is the result of a stage run
+// +kubebuilder:validation:Enum=InProgress;Succeeded;Failed;TimedOut;Interrupted
+type StageRunOutcome string
+
+// StageRun records one run of a stage handler and its steps
+type StageRun struct {
+	// Name is the operation run: Prep, Upgrade, Rollback, Abort or Finalize
+	Name string `json:"name"`
+	// Outcome is the result of the run
+	Outcome StageRunOutcome `json:"outcome"`
+	// StartedAt is when the run started
+	StartedAt metav1.Time `json:"startedAt"`
+	// CompletedAt is when the run ended
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// Duration is how long the run took, or has been running for
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Message describes the outcome
+	Message string `json:"message,omitempty"`
+	// Steps are the steps of the run in execution order
+	Steps []Step `json:"steps,omitempty"`
+}
+
+// StepState is the state of a stage step
+// +kubebuilder:validation:Enum=Pending;Running;Succeeded;Failed
+type StepState string
+
+// Step reports the progress of a single step of a stage run
+type Step struct {
+	// Name identifies the step
+	Name string `json:"name"`
+	// State is the state of the step
+	State StepState `json:"state"`
+	// StartedAt is when the step started
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is when the step succeeded or failed
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// Duration is how long the step took, or has been running for
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Message gives details on the step progress or failure
+	Message string `json:"message,omitempty"`
+}
+
+// SeedImageStatus describes a pulled seed image and the cluster it was built from
+type SeedImageStatus struct {
+	// Image is the seed image reference from the spec
+	Image string `json:"image,omitempty"`
+	// Digest is the digest of the pulled image manifest
+	Digest string `json:"digest,omitempty"`
+	// Version is the OCP version declared by the seed
+	Version string `json:"version,omitempty"`
+	// ReleaseImage is the OCP release image declared by the seed
+	ReleaseImage string `json:"releaseImage,omitempty"`
+	// Architecture is the CPU architecture of the seed
+	Architecture string `json:"architecture,omitempty"`
+	// BuildTime is when the seed image was built
+	BuildTime *metav1.Time `json:"buildTime,omitempty"`
+	// SeedCluster identifies the cluster the seed image was built from
+	SeedCluster SeedClusterInfo `json:"seedCluster,omitempty"`
+	// PulledFrom is the mirror the seed image was pulled from, or the image itself
+	PulledFrom string `json:"pulledFrom,omitempty"`
+	// SignatureVerifiedBy is the policy or public key the signature of the seed image was verified with
+	SignatureVerifiedBy string `json:"signatureVerifiedBy,omitempty"`
+}
+
+// PrecacheStatus reports the pulling of the additional images
+type PrecacheStatus struct {
+	// Total is the number of images to precache
+	Total int `json:"total"`
+	// Pulled is the number of images pulled
+	Pulled int `json:"pulled,omitempty"`
+	// Skipped is the number of images that were already on the node
+	Skipped int `json:"skipped,omitempty"`
+	// Failed is the number of images that could not be pulled
+	Failed int `json:"failed,omitempty"`
+	// FailedImages lists the images that could not be pulled and why, truncated to the first 10
+	FailedImages []PrecacheFailure `json:"failedImages,omitempty"`
+	// Mirrored is the number of images pulled from a mirror
+	Mirrored int `json:"mirrored,omitempty"`
+	// MirroredImages lists the images pulled from a mirror, truncated to the first 10
+	MirroredImages []MirroredImage `json:"mirroredImages,omitempty"`
+}
+
+// MirroredImage is an image pulled from a mirror
+type MirroredImage struct {
+	Image  string `json:"image"`
+	Mirror string `json:"mirror"`
+}
+
+// OADPOperation is the progress of a Backup or a Restore of the OADPContent
+type OADPOperation struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Wave is the apply wave of the CR, -1 for the CRs without the annotation, applied last
+	Wave int `json:"wave"`
+	// Phase is the phase reported by Velero, empty until the CR is applied
+	Phase    string `json:"phase,omitempty"`
+	Warnings int64  `json:"warnings,omitempty"`
+	Errors   int64  `json:"errors,omitempty"`
+	// Message explains why the CR failed
+	Message string `json:"message,omitempty"`
+}
+
+// HealthCheckName is a cluster health check
+// +kubebuilder:validation:Enum=ClusterOperators;NodeReady;MachineConfigPools;CertificateSigningRequests;Etcd;Workloads;HTTPProbes
+type HealthCheckName string
+
+var HealthCheckNames = struct {
+	ClusterOperators           HealthCheckName
+	NodeReady                  HealthCheckName
+	MachineConfigPools         HealthCheckName
+	CertificateSigningRequests HealthCheckName
+	Etcd                       HealthCheckName
+	Workloads                  HealthCheckName
+	HTTPProbes                 HealthCheckName
+}{
+	ClusterOperators:           "ClusterOperators",
+	NodeReady:                  "NodeReady",
+	MachineConfigPools:         "MachineConfigPools",
+	CertificateSigningRequests: "CertificateSigningRequests",
+	Etcd:                       "Etcd",
+	Workloads:                  "Workloads",
+	HTTPProbes:                 "HTTPProbes",
+}
+
+// HealthGate configures the cluster health checks that must pass before the Upgrade stage proceeds
+type HealthGate struct {
+	// SkippedChecks lists the checks not to run
+	SkippedChecks []HealthCheckName `json:"skippedChecks,omitempty"`
+	// Timeout is how long the checks are retried before the stage fails
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Workloads selects the pods that must be running, checked by the Workloads check
+	Workloads []WorkloadSelector `json:"workloads,omitempty"`
+	// HTTPProbes are the endpoints that must answer, checked by the HTTPProbes check
+	HTTPProbes []HTTPProbe `json:"httpProbes,omitempty"`
+}
+
+// WorkloadSelector selects pods by namespace and labels, at least one pod must match
+type WorkloadSelector struct {
+	Namespace string `json:"namespace"`
+	// LabelSelector is a label selector such as app=web,tier!=cache, all the pods of the namespace when empty
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
+
+// HTTPProbe is an HTTP GET the cluster must answer
+type HTTPProbe struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// ExpectedStatus is the status code of a passing probe, any 2xx code when not set
+	ExpectedStatus int32 `json:"expectedStatus,omitempty"`
+	// InsecureSkipTLSVerify skips the verification of the server certificate
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+// HealthCheckResult is the outcome of one health check
+type HealthCheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Message explains why the check failed
+	Message string `json:"message,omitempty"`
+}
+
+// ManifestOutcome is the result of the dry-run or the apply of a manifest
+// +kubebuilder:validation:Enum=Validated;Applied;Failed
+type ManifestOutcome string
+
+var ManifestOutcomes = struct {
+	Validated ManifestOutcome
+	Applied   ManifestOutcome
+	Failed    ManifestOutcome
+}{
+	Validated: "Validated",
+	Applied:   "Applied",
+	Failed:    "Failed",
+}
+
+// ManifestResult is the outcome of one object of the ExtraManifests
+type ManifestResult struct {
+	// ConfigMap is the ConfigMap holding the object, as namespace/name
+	ConfigMap  string `json:"configMap"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Outcome is Validated by the dry-run of Prep, then Applied after the pivot
+	Outcome ManifestOutcome `json:"outcome"`
+	// Message explains why the object failed
+	Message string `json:"message,omitempty"`
+}
+
+// PrecacheFailure is an image that could not be pulled
+type PrecacheFailure struct {
+	Image   string `json:"image"`
+	Message string `json:"message,omitempty"`
+}
+
+// FindingSeverity tells whether a compatibility finding prevents the upgrade
+// +kubebuilder:validation:Enum=Blocking;Warning
+type FindingSeverity string
+
+var FindingSeverities = struct {
+	Blocking FindingSeverity
+	Warning  FindingSeverity
+}{
+	Blocking: "Blocking",
+	Warning:  "Warning",
+}
+
+// CompatibilityReport lists the differences found between the seed and the cluster
+type CompatibilityReport struct {
+	// Compatible is false when a blocking finding was found, Prep does not proceed then
+	Compatible bool `json:"compatible"`
+	// CheckedAt is when the comparison ran
+	CheckedAt metav1.Time `json:"checkedAt,omitempty"`
+	// BlockingFindings is the number of blocking findings
+	BlockingFindings int `json:"blockingFindings,omitempty"`
+	// WarningFindings is the number of warning findings
+	WarningFindings int `json:"warningFindings,omitempty"`
+	// Findings lists the findings, blocking ones first. It is truncated, the full list is in ConfigMap.
+	Findings []CompatibilityFinding `json:"findings,omitempty"`
+	// ConfigMap is the name of the ConfigMap holding the full report, in the namespace of the ImageBasedUpgrade
+	ConfigMap string `json:"configMap,omitempty"`
+}
+
+// CompatibilityFinding is a difference between the seed and the cluster
+type CompatibilityFinding struct {
+	// Property is the compared property, e.g. NetworkType or Operator/sriov-network-operator
+	Property string `json:"property"`
+	// Severity tells whether the finding prevents the upgrade
+	Severity FindingSeverity `json:"severity"`
+	// Seed is the value of the property in the seed
+	Seed string `json:"seed,omitempty"`
+	// Cluster is the value of the property in the cluster
+	Cluster string `json:"cluster,omitempty"`
+	// Message explains the finding
+	Message string `json:"message,omitempty"`
+}
+
+// SeedClusterInfo identifies the cluster a seed image was built from
+type SeedClusterInfo struct {
+	ClusterID   string `json:"clusterID,omitempty"`
+	ClusterName string `json:"clusterName,omitempty"`
+	BaseDomain  string `json:"baseDomain,omitempty"`
+	NodeName    string `json:"nodeName,omitempty"`
+}
+
+// StateRoot describes an ostree stateroot found on the node and the states saved for it
+type StateRoot struct {
+	// Name is the ostree stateroot (osname)
+	Name string `json:"name,omitempty"`
+	// Version is the OCP version installed in the stateroot
+	Version string `json:"version,omitempty"`
+	// ReleaseImage is the OCP release image installed in the stateroot
+	ReleaseImage string `json:"releaseImage,omitempty"`
+	// DeploymentChecksum is the ostree commit of the stateroot deployment
+	DeploymentChecksum string `json:"deploymentChecksum,omitempty"`
+	// Booted is true if the node is running this stateroot
+	Booted bool `json:"booted,omitempty"`
+	// Default is true if the node boots into this stateroot on the next reboot
+	Default bool `json:"default,omitempty"`
+	// Pinned is true if the deployment is protected from ostree garbage collection
+	Pinned bool `json:"pinned,omitempty"`
+	// CreatedAt is when the deployment commit was created
+	CreatedAt metav1.Time `json:"createdAt,omitempty"`
+	// DiskUsageBytes is the disk space used by the stateroot directory
+	DiskUsageBytes int64 `json:"diskUsageBytes,omitempty"`
+	// SavedStates lists the pod and backup states saved for this stateroot
+	SavedStates []string `json:"savedStates,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ImageBasedUpgradeList contains a list of ImageBasedUpgrade
+type ImageBasedUpgradeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImageBasedUpgrade `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImageBasedUpgrade{}, &ImageBasedUpgradeList{})
+}
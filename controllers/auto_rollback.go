@@ -0,0 +1,108 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const (
+	// defaultHealthCheckGracePeriod applies when the auto rollback policy does not set one
+	defaultHealthCheckGracePeriod = 10 * time.Minute
+
+	healthCheckStep = "HealthCheck"
+)
+
+// healthCheckError fails the Upgrade stage when the cluster did not become healthy in time
+type healthCheckError struct {
+	err error
+}
+
+func (e *healthCheckError) Error() string {
+	return e.err.Error()
+}
+
+func (e *healthCheckError) Unwrap() error {
+	return e.err
+}
+
+func healthCheckGracePeriod(ibu *ranv1alpha1.ImageBasedUpgrade) time.Duration {
+	if gracePeriod := ibu.Spec.AutoRollback.HealthCheckGracePeriod; gracePeriod != nil {
+		return gracePeriod.Duration
+	}
+	return defaultHealthCheckGracePeriod
+}
+
+// upgradeHealthCheck runs the checks of the post-pivot health gate, once the node booted the new stateroot,
+// until they all pass. The step, and so the upgrade, fails once a check still fails past the gate timeout
+// after the pivot, rolling back if auto rollback is enabled.
+func (r *ImageBasedUpgradeReconciler) upgradeHealthCheck(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	if pivoted, waiting, err := r.pivoted(ctx, ibu); err != nil || !pivoted {
+		return false, waiting, err
+	}
+	gate := ibu.Spec.PostPivotHealthGate
+	results, failures := runHealthChecks(ctx, r.postPivotHealthChecks(gate), gate)
+	ibu.Status.PostPivotHealth = results
+	if len(failures) == 0 {
+		return true, fmt.Sprintf("Cluster is healthy, passed %d health checks", len(results)), nil
+	}
+
+	timeout := healthCheckGracePeriod(ibu)
+	if gate.Timeout != nil {
+		timeout = gate.Timeout.Duration
+	}
+	// Counted from the pivot, the reboot does not count against the gate
+	if time.Since(ibu.Status.PivotedAt.Time) >= timeout {
+		return false, "", &healthCheckError{err: &stageError{
+			reason: utils.ConditionReasons.HealthCheckFailed,
+			err:    fmt.Errorf("cluster not healthy after %s: %s", timeout, strings.Join(failures, "; ")),
+		}}
+	}
+	return false, fmt.Sprintf("Waiting for the cluster to be healthy: %s", strings.Join(failures, "; ")), nil
+}
+
+// autoRollback requests the Rollback stage if the auto rollback policy is enabled, and records why
+func (r *ImageBasedUpgradeReconciler) autoRollback(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, trigger ranv1alpha1.AutoRollbackTrigger, reason string) error {
+	if !ibu.Spec.AutoRollback.Enabled {
+		return nil
+	}
+	requested, err := r.requestStage(ctx, ibu, ranv1alpha1.Stages.Rollback)
+	if err != nil || !requested {
+		return err
+	}
+	r.Log.Info("Rolling back automatically", "trigger", trigger, "reason", reason)
+	eventReason := utils.ConditionReasons.Failed
+	if trigger == ranv1alpha1.AutoRollbackTriggers.UpgradeTimedOut {
+		eventReason = utils.ConditionReasons.TimedOut
+	}
+	r.recordEvent(ibu, corev1.EventTypeWarning, eventReason, fmt.Sprintf("Rolling back automatically after %s: %s", trigger, reason))
+	ibu.Status.AutoRollback = &ranv1alpha1.AutoRollbackStatus{
+		Trigger:     trigger,
+		Reason:      reason,
+		TriggeredAt: metav1.Now(),
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+func clusterOperator(name, available, degraded string) *unstructured.Unstructured {
+	operator := &unstructured.Unstructured{}
+	operator.SetGroupVersionKind(schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "ClusterOperator"})
+	operator.SetName(name)
+	_ = unstructured.SetNestedSlice(operator.Object, []interface{}{
+		map[string]interface{}{"type": "Available", "status": available},
+		map[string]interface{}{"type": "Degraded", "status": degraded},
+	}, "status", "conditions")
+	return operator
+}
+
+// upgradingIBU returns an IBU pivoted from the rhcos stateroot to 4.14.1 whose upgrade health check has been failing for the given time
+func upgradingIBU(unhealthyFor time.Duration, policy ranv1alpha1.AutoRollbackPolicy) *ranv1alpha1.ImageBasedUpgrade {
+	since := metav1.NewTime(time.Now().Add(-unhealthyFor))
+	return &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:          ranv1alpha1.Stages.Upgrade,
+			AutoRollback:   policy,
+			RollbackTarget: "rhcos",
+		},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{
+			Conditions: []metav1.Condition{
+				{Type: string(utils.ConditionTypes.Idle), Reason: string(utils.ConditionReasons.InProgress), Status: metav1.ConditionFalse},
+				{Type: string(utils.ConditionTypes.PrepCompleted), Reason: string(utils.ConditionReasons.Completed), Status: metav1.ConditionTrue},
+				{Type: string(utils.ConditionTypes.UpgradeInProgress), Reason: string(utils.ConditionReasons.InProgress), Status: metav1.ConditionTrue},
+			},
+			Progress: &ranv1alpha1.StageRun{
+				Name:      string(ranv1alpha1.Stages.Upgrade),
+				Outcome:   ranv1alpha1.StageRunOutcomes.InProgress,
+				StartedAt: since,
+				Steps: []ranv1alpha1.Step{
+					{Name: prePivotHealthCheckStep, State: ranv1alpha1.StepStates.Succeeded},
+					{Name: backupApplicationsStep, State: ranv1alpha1.StepStates.Succeeded},
+					{Name: applyExtraManifestsStep, State: ranv1alpha1.StepStates.Succeeded},
+					{Name: restoreApplicationsStep, State: ranv1alpha1.StepStates.Succeeded},
+					{Name: healthCheckStep, State: ranv1alpha1.StepStates.Running, StartedAt: &since},
+				},
+			},
+			SeedImage: &ranv1alpha1.SeedImageStatus{Version: "4.14.1"},
+			PivotedAt: &since,
+		},
+	}
+}
+
+func TestAutoRollback(t *testing.T) {
+	enabled := ranv1alpha1.AutoRollbackPolicy{Enabled: true, HealthCheckGracePeriod: &metav1.Duration{Duration: 10 * time.Minute}}
+
+	testcases := []struct {
+		name         string
+		ibu          *ranv1alpha1.ImageBasedUpgrade
+		operators    []client.Object
+		booted       string
+		defaults     ranv1alpha1.StageTimeouts
+		validateFunc func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade)
+	}{
+		{
+			name:      "healthy cluster completes the upgrade",
+			ibu:       upgradingIBU(time.Minute, enabled),
+			operators: []client.Object{clusterOperator("etcd", "True", "False"), testNode("sno", corev1.ConditionTrue)},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.UpgradeCompleted, statemachine.GetState(ibu.Status.Conditions))
+				assert.Nil(t, ibu.Status.AutoRollback)
+			},
+		},
+		{
+			name:      "healthy cluster still on the old stateroot does not complete the upgrade",
+			ibu:       upgradingIBU(time.Minute, enabled),
+			operators: []client.Object{clusterOperator("etcd", "True", "False"), testNode("sno", corev1.ConditionTrue)},
+			booted:    "rhcos",
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.UpgradeInProgress, statemachine.GetState(ibu.Status.Conditions))
+				assert.Equal(t, "Waiting for the node to boot the new stateroot of release 4.14.1, it still runs stateroot rhcos",
+					findStep(ibu.Status.Progress, healthCheckStep).Message)
+				assert.Empty(t, ibu.Status.PostPivotHealth)
+			},
+		},
+		{
+			name: "gate timeout counts from the pivot",
+			ibu: func() *ranv1alpha1.ImageBasedUpgrade {
+				ibu := upgradingIBU(20*time.Minute, ranv1alpha1.AutoRollbackPolicy{})
+				pivotedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+				ibu.Status.PivotedAt = &pivotedAt
+				return ibu
+			}(),
+			operators: []client.Object{clusterOperator("etcd", "False", "False"), testNode("sno", corev1.ConditionTrue)},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.UpgradeInProgress, statemachine.GetState(ibu.Status.Conditions))
+			},
+		},
+		{
+			name:      "unhealthy cluster within the grace period",
+			ibu:       upgradingIBU(time.Minute, enabled),
+			operators: []client.Object{clusterOperator("etcd", "True", "True"), testNode("sno", corev1.ConditionTrue)},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.UpgradeInProgress, statemachine.GetState(ibu.Status.Conditions))
+				assert.Contains(t, findStep(ibu.Status.Progress, healthCheckStep).Message, "ClusterOperators not available or degraded: etcd")
+			},
+		},
+		{
+			name:      "unhealthy cluster past the grace period rolls back",
+			ibu:       upgradingIBU(20*time.Minute, enabled),
+			operators: []client.Object{clusterOperator("etcd", "False", "False"), testNode("sno", corev1.ConditionTrue)},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, ranv1alpha1.Stages.Rollback, ibu.Spec.Stage)
+				assert.Equal(t, statemachine.States.RollbackCompleted, statemachine.GetState(ibu.Status.Conditions))
+				assert.Equal(t, ranv1alpha1.AutoRollbackTriggers.HealthCheckFailed, ibu.Status.AutoRollback.Trigger)
+				assert.Contains(t, ibu.Status.AutoRollback.Reason, "cluster not healthy after 10m0s")
+			},
+		},
+		{
+			name:      "unhealthy cluster without the policy fails the upgrade",
+			ibu:       upgradingIBU(20*time.Minute, ranv1alpha1.AutoRollbackPolicy{}),
+			operators: []client.Object{clusterOperator("etcd", "False", "False"), testNode("sno", corev1.ConditionTrue)},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, ranv1alpha1.Stages.Upgrade, ibu.Spec.Stage)
+				assert.Equal(t, statemachine.States.UpgradeFailed, statemachine.GetState(ibu.Status.Conditions))
+				condition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeCompleted))
+				assert.Equal(t, string(utils.ConditionReasons.HealthCheckFailed), condition.Reason)
+				assert.Contains(t, condition.Message, "cluster not healthy after 10m0s: ClusterOperators: ClusterOperators not available or degraded: etcd")
+				assert.Nil(t, ibu.Status.AutoRollback)
+			},
+		},
+		{
+			name: "post-pivot health gate timeout",
+			ibu: func() *ranv1alpha1.ImageBasedUpgrade {
+				ibu := upgradingIBU(2*time.Minute, ranv1alpha1.AutoRollbackPolicy{})
+				ibu.Spec.PostPivotHealthGate.Timeout = &metav1.Duration{Duration: time.Minute}
+				return ibu
+			}(),
+			operators: []client.Object{clusterOperator("etcd", "True", "False"), testNode("sno", corev1.ConditionFalse)},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.UpgradeFailed, statemachine.GetState(ibu.Status.Conditions))
+				assert.Contains(t, ibu.Status.PostPivotHealth, ranv1alpha1.HealthCheckResult{Name: "NodeReady", Message: "nodes not ready: sno"})
+				assert.Equal(t, "Upgrade failed: cluster not healthy after 1m0s: NodeReady: nodes not ready: sno",
+					meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeCompleted)).Message)
+			},
+		},
+		{
+			name:     "upgrade timeout rolls back",
+			ibu:      upgradingIBU(2*time.Hour, enabled),
+			defaults: ranv1alpha1.StageTimeouts{Upgrade: &metav1.Duration{Duration: time.Hour}},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, ranv1alpha1.Stages.Rollback, ibu.Spec.Stage)
+				assert.Equal(t, ranv1alpha1.AutoRollbackTriggers.UpgradeTimedOut, ibu.Status.AutoRollback.Trigger)
+				assert.Equal(t, "Upgrade timed out after 1h0m0s", ibu.Status.AutoRollback.Reason)
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			booted := tc.booted
+			if booted == "" {
+				booted = "rhcos_4.14.1"
+			}
+			fakeClient, _ := getFakeClientFromObjects(append(tc.operators, testClusterVersion("4.14.1"), tc.ibu)...)
+			r := &ImageBasedUpgradeReconciler{
+				Client:          fakeClient,
+				Recorder:        record.NewFakeRecorder(100),
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Executor:        &ops.MockExecutor{},
+				OstreeClient:    ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: booted, Booted: true}),
+				SeedImageClient: &fakeSeedImageClient{},
+				DefaultTimeouts: tc.defaults,
+			}
+			key := types.NamespacedName{Name: utils.IBUName, Namespace: lcaNs}
+			_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+			assert.NoError(t, err)
+
+			ibu := &ranv1alpha1.ImageBasedUpgrade{}
+			assert.NoError(t, fakeClient.Get(context.TODO(), key, ibu))
+			tc.validateFunc(t, ibu)
+		})
+	}
+}
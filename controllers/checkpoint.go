@@ -0,0 +1,149 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// checkpoint records on the host the steps of a run that succeeded, so the run resumes
+// even when its progress did not make it into the status
+type checkpoint struct {
+	Run string `json:"run"`
+	// Spec is the fingerprint of the spec the steps succeeded for, a changed spec starts over
+	Spec string `json:"spec"`
+	// StartedAt is when the run started, the resumed run keeps it so it is still the same run
+	StartedAt metav1.Time `json:"startedAt,omitempty"`
+	Steps     []string    `json:"steps"`
+}
+
+func checkpointPath(run string) string {
+	return filepath.Join(utils.HostPath, utils.CheckpointsDir, strings.ToLower(run)+".json")
+}
+
+func specFingerprint(ibu *ranv1alpha1.ImageBasedUpgrade) string {
+	data, _ := json.Marshal(ibu.Spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// restoreCheckpoint marks the steps recorded on the host as succeeded in a new run
+func (r *ImageBasedUpgradeReconciler) restoreCheckpoint(ibu *ranv1alpha1.ImageBasedUpgrade, run *ranv1alpha1.StageRun) {
+	data, err := os.ReadFile(checkpointPath(run.Name))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			r.Log.Error(err, "Failed to read checkpoint", "run", run.Name)
+		}
+		return
+	}
+	saved := checkpoint{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		r.Log.Error(err, "Ignoring corrupted checkpoint", "run", run.Name)
+		return
+	}
+	if saved.Run != run.Name || saved.Spec != specFingerprint(ibu) {
+		return
+	}
+	if !saved.StartedAt.IsZero() {
+		run.StartedAt = saved.StartedAt
+		ibu.Status.StartedAt = saved.StartedAt
+	}
+	now := metav1.Now()
+	for _, name := range saved.Steps {
+		if step := findStep(run, name); step != nil {
+			step.State = ranv1alpha1.StepStates.Succeeded
+			step.StartedAt = &now
+			step.CompletedAt = &now
+			step.Message = "Restored from the checkpoint on the host"
+		}
+	}
+	r.Log.Info("Resuming from the checkpoint on the host", "run", run.Name, "steps", saved.Steps)
+}
+
+// saveCheckpoint records the steps of the run that succeeded. Failing to save it is logged,
+// the progress is still in the status.
+func (r *ImageBasedUpgradeReconciler) saveCheckpoint(ibu *ranv1alpha1.ImageBasedUpgrade, run *ranv1alpha1.StageRun) {
+	saved := checkpoint{Run: run.Name, Spec: specFingerprint(ibu), StartedAt: run.StartedAt}
+	for _, step := range run.Steps {
+		if step.State == ranv1alpha1.StepStates.Succeeded {
+			saved.Steps = append(saved.Steps, step.Name)
+		}
+	}
+	if err := writeFileAtomic(checkpointPath(run.Name), saved); err != nil {
+		r.Log.Error(err, "Failed to save checkpoint", "run", run.Name)
+	}
+}
+
+// removeCheckpoint forgets the checkpoint of a finished run
+func (r *ImageBasedUpgradeReconciler) removeCheckpoint(name string) {
+	if err := os.Remove(checkpointPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		r.Log.Error(err, "Failed to remove checkpoint", "run", name)
+	}
+}
+
+// writeFileAtomic writes value as JSON so that a crash leaves either the old or the new content
+func writeFileAtomic(path string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// verifyStep checks once per operator process that a step that succeeded before, possibly in a previous
+// operator pod or before a reboot, still holds. It returns false when the step has to run again.
+func (r *ImageBasedUpgradeReconciler) verifyStep(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, run *ranv1alpha1.StageRun, step stageStep) bool {
+	key := fmt.Sprintf("%s/%d/%s", run.Name, run.StartedAt.Unix(), step.name)
+	if r.verifiedSteps[key] {
+		return true
+	}
+	if step.verify != nil {
+		if err := step.verify(ctx, ibu); err != nil {
+			r.Log.Info("Step to run again, its results did not survive", "step", step.name, "reason", err.Error())
+			return false
+		}
+	}
+	r.markVerified(run, step.name)
+	return true
+}
+
+func (r *ImageBasedUpgradeReconciler) markVerified(run *ranv1alpha1.StageRun, step string) {
+	if r.verifiedSteps == nil {
+		r.verifiedSteps = map[string]bool{}
+	}
+	r.verifiedSteps[fmt.Sprintf("%s/%d/%s", run.Name, run.StartedAt.Unix(), step)] = true
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+func TestResumeAfterRestart(t *testing.T) {
+	defer func(old string) { utils.HostPath = old }(utils.HostPath)
+	utils.HostPath = t.TempDir()
+
+	calls := map[string]int{}
+	var verifyErr error
+	ready := false
+	steps := []stageStep{
+		{
+			name: "pull",
+			run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+				calls["pull"]++
+				return true, "pulled", nil
+			},
+			verify: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+				calls["verify"]++
+				return verifyErr
+			},
+		},
+		{
+			name: "precache",
+			run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+				calls["precache"]++
+				return ready, "precaching", nil
+			},
+		},
+	}
+	newReconciler := func() *ImageBasedUpgradeReconciler {
+		return &ImageBasedUpgradeReconciler{Log: logr.Discard(), Recorder: record.NewFakeRecorder(100)}
+	}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{Spec: ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: testSeedImageRef}}
+
+	r := newReconciler()
+	_, err := r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	_, err = r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"pull": 1, "precache": 2}, calls, "the same operator trusts the steps it ran")
+	assert.FileExists(t, checkpointPath("Prep"))
+
+	// A restarted operator verifies the succeeded steps once instead of running them again
+	r = newReconciler()
+	_, err = r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	_, err = r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"pull": 1, "verify": 1, "precache": 4}, calls)
+
+	// The progress in the status is lost, the checkpoint on the host has it
+	lost := ibu.DeepCopy()
+	lost.Status = ranv1alpha1.ImageBasedUpgradeStatus{}
+	r = newReconciler()
+	_, err = r.runSteps(context.TODO(), lost, "Prep", steps)
+	assert.NoError(t, err)
+	assert.Equal(t, ranv1alpha1.StepStates.Succeeded, lost.Status.Progress.Steps[0].State)
+	assert.Equal(t, "Restored from the checkpoint on the host", lost.Status.Progress.Steps[0].Message)
+	assert.Equal(t, ibu.Status.Progress.StartedAt.Unix(), lost.Status.Progress.StartedAt.Unix(), "the resumed run is the same run")
+	assert.Equal(t, map[string]int{"pull": 1, "verify": 2, "precache": 5}, calls)
+
+	// Results that did not survive the restart are redone
+	verifyErr = fmt.Errorf("seed image gone")
+	r = newReconciler()
+	_, err = r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"pull": 2, "verify": 3, "precache": 6}, calls)
+
+	// A finished run forgets its checkpoint
+	ready = true
+	done, err := r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.NoFileExists(t, checkpointPath("Prep"))
+}
+
+func TestCheckpointNotRestored(t *testing.T) {
+	defer func(old string) { utils.HostPath = old }(utils.HostPath)
+	utils.HostPath = t.TempDir()
+
+	pulls := 0
+	steps := []stageStep{
+		{name: "pull", run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+			pulls++
+			return true, "", nil
+		}},
+		{name: "wait", run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+			return false, "", nil
+		}},
+	}
+	r := &ImageBasedUpgradeReconciler{Log: logr.Discard(), Recorder: record.NewFakeRecorder(100)}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{Spec: ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: testSeedImageRef}}
+	_, err := r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+
+	// Another seed image does not resume the progress made for the previous one
+	changed := ibu.DeepCopy()
+	changed.Status = ranv1alpha1.ImageBasedUpgradeStatus{}
+	changed.Spec.SeedImageRef.Image = "quay.io/openshift-kni/seed:4.14.2"
+	_, err = r.runSteps(context.TODO(), changed, "Prep", steps)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pulls)
+
+	// An interrupted run starts over
+	_, err = r.runSteps(context.TODO(), ibu, abortRun, nil)
+	assert.NoError(t, err)
+	_, err = os.Stat(checkpointPath("Prep"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// A corrupted checkpoint is ignored
+	assert.NoError(t, os.WriteFile(checkpointPath("Prep"), []byte("{"), 0o600))
+	ibu.Status = ranv1alpha1.ImageBasedUpgradeStatus{}
+	_, err = r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, pulls)
+}
+
+func TestVerifyPrepSteps(t *testing.T) {
+	images := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ran-images", Namespace: lcaNs},
+		Data:       map[string]string{"images": "quay.io/ran/du:1.0\nquay.io/ran/cu:1.0"},
+	}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			SeedImageRef:     testSeedImageRef,
+			AdditionalImages: ranv1alpha1.ConfigMapRef{Name: "ran-images"},
+		},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu, images)
+	seed := &fakeSeedImageClient{}
+	puller := &fakeImagePuller{present: map[string]bool{"quay.io/ran/du:1.0": true, "quay.io/ran/cu:1.0": true}}
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), SeedImageClient: seed, ImagePuller: puller}
+
+	assert.ErrorContains(t, r.verifySeedImage(context.TODO(), ibu), "not reported in the status")
+	ibu.Status.SeedImage = &ranv1alpha1.SeedImageStatus{Image: testSeedImage}
+	assert.NoError(t, r.verifySeedImage(context.TODO(), ibu))
+	seed.missing = true
+	assert.ErrorContains(t, r.verifySeedImage(context.TODO(), ibu), "no longer on the node")
+	assert.Empty(t, seed.pulled, "verifying does not pull")
+
+	assert.NoError(t, r.verifyPrecachedImages(context.TODO(), ibu))
+	delete(puller.present, "quay.io/ran/cu:1.0")
+	assert.ErrorContains(t, r.verifyPrecachedImages(context.TODO(), ibu), "image quay.io/ran/cu:1.0 is no longer on the node")
+	assert.Empty(t, puller.pulled)
+}
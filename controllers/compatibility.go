@@ -0,0 +1,364 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
+)
+
+const (
+	checkSeedCompatibilityStep = "CheckSeedCompatibility"
+
+	// maxStatusFindings caps the findings listed in the status, the report ConfigMap has all of them
+	maxStatusFindings = 10
+
+	// compatibilityReportKey is the ConfigMap key of the full compatibility report
+	compatibilityReportKey = "report.json"
+)
+
+var (
+	networkGVK                   = schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "Network"}
+	clusterServiceVersionListGVK = schema.GroupVersionKind{Group: "operators.coreos.com", Version: "v1alpha1", Kind: "ClusterServiceVersionList"}
+	performanceProfileListGVK    = schema.GroupVersionKind{Group: "performance.openshift.io", Version: "v2", Kind: "PerformanceProfileList"}
+)
+
+// procPath is where the kernel command line is read from, the operator runs on the node kernel
+var procPath = "/proc"
+
+// separateFilesystemCandidates are the directories checked for a partition of their own
+var separateFilesystemCandidates = []string{"/var/lib/containers", "/var/lib/etcd", "/var/log"}
+
+// blockingFilesystems must be laid out the same in the seed and the cluster, the stateroots share them
+var blockingFilesystems = map[string]bool{"/var/lib/containers": true}
+
+// deploymentKernelArgs are set per ostree deployment or per node, they are not compared
+var deploymentKernelArgs = map[string]bool{
+	"BOOT_IMAGE": true, "root": true, "rootflags": true, "ostree": true, "rw": true, "ro": true,
+	"boot": true, "ignition.platform.id": true, "$ignition_firstboot": true,
+}
+
+// checkSeedCompatibility compares the properties recorded in the seed image with the ones of the cluster,
+// reports the findings in the status and in a ConfigMap, and fails on blocking findings
+func (r *ImageBasedUpgradeReconciler) checkSeedCompatibility(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	// Pulled and verified by the previous steps, this only reads the metadata again
+	metadata, err := r.SeedImageClient.Metadata(ctx, seedImageReference(ibu))
+	if err != nil {
+		return false, "", err
+	}
+	var cluster *seedimage.ClusterProperties
+	if metadata.Properties != nil {
+		if cluster, err = r.clusterProperties(ctx); err != nil {
+			return false, "", err
+		}
+	}
+
+	findings := compareWithSeed(metadata, runtime.GOARCH, cluster)
+	report := newCompatibilityReport(findings)
+	if report.ConfigMap, err = r.saveCompatibilityReport(ctx, ibu, findings); err != nil {
+		return false, "", err
+	}
+	ibu.Status.Compatibility = report
+
+	if !report.Compatible {
+		var blocking []string
+		for _, finding := range findings {
+			if finding.Severity == ranv1alpha1.FindingSeverities.Blocking {
+				blocking = append(blocking, finding.Message)
+			}
+		}
+		return false, "", &stageError{
+			reason: utils.ConditionReasons.SeedMismatch,
+			err:    fmt.Errorf("seed image %s is not compatible with the cluster: %s", ibu.Spec.SeedImageRef.Image, strings.Join(blocking, "; ")),
+		}
+	}
+	return true, fmt.Sprintf("Seed image compatible with the cluster, %d warnings", report.WarningFindings), nil
+}
+
+func newCompatibilityReport(findings []ranv1alpha1.CompatibilityFinding) *ranv1alpha1.CompatibilityReport {
+	report := &ranv1alpha1.CompatibilityReport{CheckedAt: metav1.Now()}
+	for _, finding := range findings {
+		if finding.Severity == ranv1alpha1.FindingSeverities.Blocking {
+			report.BlockingFindings++
+		} else {
+			report.WarningFindings++
+		}
+	}
+	report.Compatible = report.BlockingFindings == 0
+	if len(findings) > maxStatusFindings {
+		findings = findings[:maxStatusFindings]
+	}
+	report.Findings = append(report.Findings, findings...)
+	return report
+}
+
+// saveCompatibilityReport writes all the findings to a ConfigMap owned by the IBU and returns its name
+func (r *ImageBasedUpgradeReconciler) saveCompatibilityReport(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, findings []ranv1alpha1.CompatibilityFinding) (string, error) {
+	data, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal compatibility report: %w", err)
+	}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: ibu.Name + "-compatibility", Namespace: ibu.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = map[string]string{compatibilityReportKey: string(data)}
+		return controllerutil.SetControllerReference(ibu, cm, r.Scheme)
+	}); err != nil {
+		return "", fmt.Errorf("failed to save compatibility report: %w", err)
+	}
+	return cm.Name, nil
+}
+
+// compareWithSeed returns the differences between the seed and the cluster, blocking ones first.
+// The cluster properties are nil when the seed does not record its own.
+func compareWithSeed(seed *seedimage.Metadata, arch string, cluster *seedimage.ClusterProperties) []ranv1alpha1.CompatibilityFinding {
+	var findings []ranv1alpha1.CompatibilityFinding
+	add := func(property string, severity ranv1alpha1.FindingSeverity, seedValue, clusterValue, message string) {
+		findings = append(findings, ranv1alpha1.CompatibilityFinding{
+			Property: property, Severity: severity, Seed: seedValue, Cluster: clusterValue, Message: message,
+		})
+	}
+	blocking, warning := ranv1alpha1.FindingSeverities.Blocking, ranv1alpha1.FindingSeverities.Warning
+
+	if seed.Architecture != "" && seed.Architecture != arch {
+		add("Architecture", blocking, seed.Architecture, arch,
+			fmt.Sprintf("seed architecture %s differs from the cluster architecture %s", seed.Architecture, arch))
+	}
+	if seed.Properties == nil || cluster == nil {
+		add("Properties", warning, "", "", "the seed image does not record its cluster properties, only the architecture was compared")
+		return findings
+	}
+	s := seed.Properties
+
+	if s.NetworkType != cluster.NetworkType {
+		add("NetworkType", blocking, s.NetworkType, cluster.NetworkType,
+			fmt.Sprintf("seed network type %s differs from the cluster network type %s", s.NetworkType, cluster.NetworkType))
+	}
+	if seedFamilies, clusterFamilies := sortedJoin(s.IPFamilies), sortedJoin(cluster.IPFamilies); seedFamilies != clusterFamilies {
+		add("IPFamilies", blocking, seedFamilies, clusterFamilies,
+			fmt.Sprintf("seed IP stack %s differs from the cluster IP stack %s", seedFamilies, clusterFamilies))
+	}
+	if s.FIPS != cluster.FIPS {
+		add("FIPS", blocking, strconv.FormatBool(s.FIPS), strconv.FormatBool(cluster.FIPS),
+			fmt.Sprintf("seed FIPS mode %t differs from the cluster FIPS mode %t", s.FIPS, cluster.FIPS))
+	}
+
+	for _, dir := range separateFilesystemCandidates {
+		inSeed, inCluster := contains(s.SeparateFilesystems, dir), contains(cluster.SeparateFilesystems, dir)
+		if inSeed == inCluster {
+			continue
+		}
+		severity := warning
+		if blockingFilesystems[dir] {
+			severity = blocking
+		}
+		add("Filesystem"+dir, severity, strconv.FormatBool(inSeed), strconv.FormatBool(inCluster),
+			fmt.Sprintf("%s is a separate filesystem in the seed: %t, in the cluster: %t", dir, inSeed, inCluster))
+	}
+
+	seedOperators := map[string]string{}
+	for _, operator := range s.Operators {
+		seedOperators[operator.Name] = operator.Version
+	}
+	clusterOperators := map[string]string{}
+	for _, operator := range cluster.Operators {
+		clusterOperators[operator.Name] = operator.Version
+		seedVersion, found := seedOperators[operator.Name]
+		switch {
+		case !found:
+			add("Operator/"+operator.Name, blocking, "", operator.Version,
+				fmt.Sprintf("operator %s is installed in the cluster but not in the seed, the upgrade would remove it", operator.Name))
+		case seedVersion != operator.Version:
+			add("Operator/"+operator.Name, warning, seedVersion, operator.Version,
+				fmt.Sprintf("operator %s is version %s in the seed and %s in the cluster", operator.Name, seedVersion, operator.Version))
+		}
+	}
+	for _, operator := range s.Operators {
+		if _, found := clusterOperators[operator.Name]; !found {
+			add("Operator/"+operator.Name, warning, operator.Version, "",
+				fmt.Sprintf("operator %s is installed in the seed but not in the cluster, the upgrade would add it", operator.Name))
+		}
+	}
+
+	seedArgs, clusterArgs := toSet(s.KernelArgs), toSet(cluster.KernelArgs)
+	for _, arg := range s.KernelArgs {
+		if !clusterArgs[arg] {
+			add("KernelArgs", warning, arg, "", fmt.Sprintf("kernel argument %s is set in the seed but not in the cluster", arg))
+		}
+	}
+	for _, arg := range cluster.KernelArgs {
+		if !seedArgs[arg] {
+			add("KernelArgs", warning, "", arg, fmt.Sprintf("kernel argument %s is set in the cluster but not in the seed", arg))
+		}
+	}
+
+	if seedProfiles, clusterProfiles := sortedJoin(s.PerformanceProfiles), sortedJoin(cluster.PerformanceProfiles); seedProfiles != clusterProfiles {
+		add("PerformanceProfiles", warning, seedProfiles, clusterProfiles,
+			fmt.Sprintf("seed PerformanceProfiles [%s] differ from the cluster PerformanceProfiles [%s]", seedProfiles, clusterProfiles))
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity == blocking && findings[j].Severity != blocking
+	})
+	return findings
+}
+
+// clusterProperties collects the properties of the running cluster that are compared with the seed
+func (r *ImageBasedUpgradeReconciler) clusterProperties(ctx context.Context) (*seedimage.ClusterProperties, error) {
+	properties := &seedimage.ClusterProperties{}
+
+	network := &unstructured.Unstructured{}
+	network.SetGroupVersionKind(networkGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: "cluster"}, network); err != nil && !isAbsent(err) {
+		return nil, fmt.Errorf("failed to get the cluster network config: %w", err)
+	}
+	properties.NetworkType, _, _ = unstructured.NestedString(network.Object, "status", "networkType")
+	clusterNetworks, _, _ := unstructured.NestedSlice(network.Object, "status", "clusterNetwork")
+	families := map[string]bool{}
+	for _, n := range clusterNetworks {
+		if entry, ok := n.(map[string]interface{}); ok {
+			cidr, _ := entry["cidr"].(string)
+			families[ipFamily(cidr)] = true
+		}
+	}
+	for family := range families {
+		properties.IPFamilies = append(properties.IPFamilies, family)
+	}
+	sort.Strings(properties.IPFamilies)
+
+	cmdline, err := os.ReadFile(filepath.Join(procPath, "cmdline"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the kernel command line: %w", err)
+	}
+	for _, arg := range strings.Fields(string(cmdline)) {
+		if arg == "fips=1" {
+			properties.FIPS = true
+		}
+		key, _, _ := strings.Cut(arg, "=")
+		if !deploymentKernelArgs[key] {
+			properties.KernelArgs = append(properties.KernelArgs, arg)
+		}
+	}
+
+	csvs := &unstructured.UnstructuredList{}
+	csvs.SetGroupVersionKind(clusterServiceVersionListGVK)
+	if err := r.List(ctx, csvs); err != nil && !isAbsent(err) {
+		return nil, fmt.Errorf("failed to list ClusterServiceVersions: %w", err)
+	}
+	for _, csv := range csvs.Items {
+		// OLM copies the CSVs of all-namespaces operators in every namespace
+		if _, copied := csv.GetLabels()["olm.copiedFrom"]; copied {
+			continue
+		}
+		name, _, _ := strings.Cut(csv.GetName(), ".v")
+		version, _, _ := unstructured.NestedString(csv.Object, "spec", "version")
+		properties.Operators = append(properties.Operators, seedimage.Operator{Name: name, Version: version})
+	}
+	sort.Slice(properties.Operators, func(i, j int) bool { return properties.Operators[i].Name < properties.Operators[j].Name })
+
+	for _, dir := range separateFilesystemCandidates {
+		separate, err := isSeparateFilesystem(filepath.Join(utils.HostPath, dir))
+		if err != nil {
+			return nil, err
+		}
+		if separate {
+			properties.SeparateFilesystems = append(properties.SeparateFilesystems, dir)
+		}
+	}
+
+	profiles := &unstructured.UnstructuredList{}
+	profiles.SetGroupVersionKind(performanceProfileListGVK)
+	if err := r.List(ctx, profiles); err != nil && !isAbsent(err) {
+		return nil, fmt.Errorf("failed to list PerformanceProfiles: %w", err)
+	}
+	for _, profile := range profiles.Items {
+		properties.PerformanceProfiles = append(properties.PerformanceProfiles, profile.GetName())
+	}
+	sort.Strings(properties.PerformanceProfiles)
+	return properties, nil
+}
+
+// isAbsent is true for errors meaning the resource or its CRD does not exist
+func isAbsent(err error) bool {
+	return apierrors.IsNotFound(err) || meta.IsNoMatchError(err)
+}
+
+func ipFamily(cidr string) string {
+	if strings.Contains(cidr, ":") {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// isSeparateFilesystem is true when dir exists and is on another device than its parent
+func isSeparateFilesystem(dir string) (bool, error) {
+	var dirStat, parentStat syscall.Stat_t
+	if err := syscall.Stat(dir, &dirStat); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat %s: %w", dir, err)
+	}
+	if err := syscall.Stat(filepath.Dir(dir), &parentStat); err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", filepath.Dir(dir), err)
+	}
+	return dirStat.Dev != parentStat.Dev, nil
+}
+
+func sortedJoin(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
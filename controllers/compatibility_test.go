@@ -0,0 +1,249 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
+)
+
+func testClusterProperties() *seedimage.ClusterProperties {
+	return &seedimage.ClusterProperties{
+		NetworkType:         "OVNKubernetes",
+		IPFamilies:          []string{"IPv4"},
+		KernelArgs:          []string{"intel_iommu=on"},
+		Operators:           []seedimage.Operator{{Name: "sriov-network-operator", Version: "4.14.0"}},
+		SeparateFilesystems: []string{"/var/lib/containers"},
+		PerformanceProfiles: []string{"openshift-node-performance-profile"},
+	}
+}
+
+func findingProperties(findings []ranv1alpha1.CompatibilityFinding) []string {
+	var properties []string
+	for _, finding := range findings {
+		properties = append(properties, string(finding.Severity)+" "+finding.Property)
+	}
+	return properties
+}
+
+func TestCompareWithSeed(t *testing.T) {
+	testcases := []struct {
+		name     string
+		arch     string
+		seed     func(*seedimage.ClusterProperties)
+		cluster  func(*seedimage.ClusterProperties)
+		noSeed   bool
+		expected []string
+	}{
+		{
+			name: "identical",
+			arch: "amd64",
+		},
+		{
+			name:     "architecture",
+			arch:     "arm64",
+			expected: []string{"Blocking Architecture"},
+		},
+		{
+			name:     "seed without properties",
+			arch:     "amd64",
+			noSeed:   true,
+			expected: []string{"Warning Properties"},
+		},
+		{
+			name: "blocking differences first",
+			arch: "amd64",
+			seed: func(p *seedimage.ClusterProperties) {
+				p.KernelArgs = nil
+				p.Operators = []seedimage.Operator{{Name: "sriov-network-operator", Version: "4.14.1"}, {Name: "ptp-operator", Version: "4.14.0"}}
+			},
+			cluster: func(p *seedimage.ClusterProperties) {
+				p.NetworkType = "OpenShiftSDN"
+				p.IPFamilies = []string{"IPv6", "IPv4"}
+				p.FIPS = true
+				p.SeparateFilesystems = []string{"/var/log"}
+				p.Operators = append(p.Operators, seedimage.Operator{Name: "local-storage-operator", Version: "4.14.0"})
+				p.PerformanceProfiles = nil
+			},
+			expected: []string{
+				"Blocking NetworkType",
+				"Blocking IPFamilies",
+				"Blocking FIPS",
+				"Blocking Filesystem/var/lib/containers",
+				"Blocking Operator/local-storage-operator",
+				"Warning Filesystem/var/log",
+				"Warning Operator/sriov-network-operator",
+				"Warning Operator/ptp-operator",
+				"Warning KernelArgs",
+				"Warning PerformanceProfiles",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			seed := &seedimage.Metadata{Version: "4.14.1", Architecture: "amd64", Properties: testClusterProperties()}
+			cluster := testClusterProperties()
+			if tc.seed != nil {
+				tc.seed(seed.Properties)
+			}
+			if tc.cluster != nil {
+				tc.cluster(cluster)
+			}
+			if tc.noSeed {
+				seed.Properties, cluster = nil, nil
+			}
+			assert.Equal(t, tc.expected, findingProperties(compareWithSeed(seed, tc.arch, cluster)))
+		})
+	}
+}
+
+func TestNewCompatibilityReport(t *testing.T) {
+	var findings []ranv1alpha1.CompatibilityFinding
+	for i := 0; i < maxStatusFindings+5; i++ {
+		findings = append(findings, ranv1alpha1.CompatibilityFinding{Property: "KernelArgs", Severity: ranv1alpha1.FindingSeverities.Warning})
+	}
+	report := newCompatibilityReport(findings)
+	assert.True(t, report.Compatible)
+	assert.Equal(t, maxStatusFindings+5, report.WarningFindings)
+	assert.Len(t, report.Findings, maxStatusFindings)
+
+	report = newCompatibilityReport(append(findings, ranv1alpha1.CompatibilityFinding{Severity: ranv1alpha1.FindingSeverities.Blocking}))
+	assert.False(t, report.Compatible)
+	assert.Equal(t, 1, report.BlockingFindings)
+}
+
+func unstructuredObject(gvk schema.GroupVersionKind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: fields}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func TestCheckSeedCompatibility(t *testing.T) {
+	roomyFilesystems(t)
+	procDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(procDir, "cmdline"),
+		[]byte("BOOT_IMAGE=(hd0,gpt3)/ostree/rhcos-1/vmlinuz ostree=/ostree/boot.1/rhcos/1/0 rw intel_iommu=on\n"), 0o644))
+	defer func(old string) { procPath = old }(procPath)
+	procPath = procDir
+	defer func(old string) { utils.HostPath = old }(utils.HostPath)
+	utils.HostPath = t.TempDir()
+
+	clusterObjects := []client.Object{
+		unstructuredObject(networkGVK, "", "cluster", map[string]interface{}{
+			"status": map[string]interface{}{
+				"networkType":    "OVNKubernetes",
+				"clusterNetwork": []interface{}{map[string]interface{}{"cidr": "10.128.0.0/14", "hostPrefix": int64(23)}},
+			},
+		}),
+		unstructuredObject(schema.GroupVersionKind{Group: "operators.coreos.com", Version: "v1alpha1", Kind: "ClusterServiceVersion"},
+			"openshift-sriov-network-operator", "sriov-network-operator.v4.14.0-202311021650",
+			map[string]interface{}{"spec": map[string]interface{}{"version": "4.14.0"}}),
+		unstructuredObject(schema.GroupVersionKind{Group: "performance.openshift.io", Version: "v2", Kind: "PerformanceProfile"},
+			"", "openshift-node-performance-profile", map[string]interface{}{}),
+	}
+	copied := unstructuredObject(schema.GroupVersionKind{Group: "operators.coreos.com", Version: "v1alpha1", Kind: "ClusterServiceVersion"},
+		"default", "sriov-network-operator.v4.14.0-202311021650", map[string]interface{}{"spec": map[string]interface{}{"version": "4.14.0"}})
+	copied.SetLabels(map[string]string{"olm.copiedFrom": "openshift-sriov-network-operator"})
+	clusterObjects = append(clusterObjects, copied)
+
+	compatible := testClusterProperties()
+	compatible.SeparateFilesystems = nil
+	incompatible := testClusterProperties()
+	incompatible.SeparateFilesystems = nil
+	incompatible.NetworkType = "OpenShiftSDN"
+
+	testcases := []struct {
+		name       string
+		properties *seedimage.ClusterProperties
+		validate   func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition)
+	}{
+		{
+			name:       "compatible",
+			properties: compatible,
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionTrue, completed.Status)
+				assert.True(t, ibu.Status.Compatibility.Compatible)
+				assert.Empty(t, ibu.Status.Compatibility.Findings)
+			},
+		},
+		{
+			name:       "blocking finding",
+			properties: incompatible,
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionFalse, completed.Status)
+				assert.Equal(t, string(utils.ConditionReasons.SeedMismatch), completed.Reason)
+				assert.Contains(t, completed.Message, "seed network type OpenShiftSDN differs from the cluster network type OVNKubernetes")
+				assert.False(t, ibu.Status.Compatibility.Compatible)
+				assert.Equal(t, 1, ibu.Status.Compatibility.BlockingFindings)
+			},
+		},
+		{
+			name: "seed without properties",
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionTrue, completed.Status)
+				assert.Equal(t, 1, ibu.Status.Compatibility.WarningFindings)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+				Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: testSeedImageRef},
+			}
+			fakeClient, _ := getFakeClientFromObjects(append([]client.Object{ibu}, clusterObjects...)...)
+			r := &ImageBasedUpgradeReconciler{
+				Client:          fakeClient,
+				APIReader:       fakeClient,
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Recorder:        record.NewFakeRecorder(100),
+				SeedImageClient: &fakeSeedImageClient{properties: tc.properties},
+			}
+			_, err := r.handlePrep(context.TODO(), ibu)
+			assert.NoError(t, err)
+			tc.validate(t, ibu, meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted)))
+
+			cm := &corev1.ConfigMap{}
+			assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: ibu.Status.Compatibility.ConfigMap, Namespace: lcaNs}, cm))
+			assert.Equal(t, ibu.Name, cm.OwnerReferences[0].Name)
+			var findings []ranv1alpha1.CompatibilityFinding
+			assert.NoError(t, json.Unmarshal([]byte(cm.Data[compatibilityReportKey]), &findings))
+			assert.Len(t, findings, ibu.Status.Compatibility.BlockingFindings+ibu.Status.Compatibility.WarningFindings)
+		})
+	}
+}
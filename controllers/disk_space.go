@@ -0,0 +1,240 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/mirrors"
+)
+
+const (
+	checkDiskSpaceStep = "CheckDiskSpace"
+
+	// maxReportedConsumers caps the consumers listed when there is not enough space
+	maxReportedConsumers = 5
+
+	// reservedPercent of every filesystem is kept free, the kubelet evicts pods under nodefs.available<10%
+	reservedPercent = 10
+)
+
+const (
+	stateRootFilesystem = "/sysroot"
+	imagesFilesystem    = "/var"
+)
+
+// filesystemInfo describes the filesystem holding a path
+type filesystemInfo struct {
+	device    uint64
+	size      int64
+	available int64
+}
+
+// statFilesystem returns the filesystem holding path, a variable so tests can fake the host filesystems
+var statFilesystem = func(path string) (filesystemInfo, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return filesystemInfo{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return filesystemInfo{}, fmt.Errorf("failed to statfs %s: %w", path, err)
+	}
+	return filesystemInfo{
+		device:    uint64(st.Dev), //nolint:unconvert // Dev is not uint64 on every architecture
+		size:      int64(fs.Blocks) * int64(fs.Bsize),
+		available: int64(fs.Bavail) * int64(fs.Bsize),
+	}, nil
+}
+
+// diskConsumer is something Prep writes to a host filesystem
+type diskConsumer struct {
+	name       string
+	filesystem string
+	size       int64
+}
+
+// filesystemUsage is the space needed on one filesystem, /var and /sysroot are usually the same one
+type filesystemUsage struct {
+	paths     []string
+	info      filesystemInfo
+	consumers []diskConsumer
+	required  int64
+}
+
+func (u *filesystemUsage) reserved() int64 {
+	return u.info.size * reservedPercent / 100
+}
+
+func (u *filesystemUsage) fits() bool {
+	return u.required+u.reserved() <= u.info.available
+}
+
+// checkDiskSpace fails Prep early, before anything is pulled, when the seed image, the new stateroot and the
+// precached images do not fit on the node
+func (r *ImageBasedUpgradeReconciler) checkDiskSpace(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	consumers, err := r.diskConsumers(ctx, ibu)
+	if err != nil {
+		return false, "", err
+	}
+	usages, err := diskUsages(consumers)
+	if err != nil {
+		return false, "", err
+	}
+
+	var short, summary []string
+	for _, usage := range usages {
+		summary = append(summary, fmt.Sprintf("%s needs %s of %s available", strings.Join(usage.paths, ","),
+			formatBytes(usage.required), formatBytes(usage.info.available)))
+		if !usage.fits() {
+			short = append(short, describeShortage(usage))
+		}
+	}
+	if len(short) > 0 {
+		return false, "", &stageError{
+			reason: utils.ConditionReasons.InsufficientDiskSpace,
+			err:    fmt.Errorf("not enough disk space: %s", strings.Join(short, "; ")),
+		}
+	}
+	return true, "Enough disk space: " + strings.Join(summary, "; "), nil
+}
+
+// diskConsumers returns the new stateroot, and the seed image and the additional images that are not on the
+// node yet. Nothing is pulled, the sizes come from the registries.
+func (r *ImageBasedUpgradeReconciler) diskConsumers(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) ([]diskConsumer, error) {
+	seed := ibu.Spec.SeedImageRef.Image
+	if seed == "" {
+		return nil, fmt.Errorf("spec.seedImageRef.image is not set")
+	}
+	mirrorSet, err := r.mirrorSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seedSize, err := r.seedImageSize(ctx, mirrorSet, seed)
+	if err != nil {
+		return nil, err
+	}
+	consumers := []diskConsumer{{name: "stateroot from " + seed, filesystem: stateRootFilesystem, size: seedSize}}
+	if exists, err := r.SeedImageClient.Exists(ctx, seed); err != nil || !exists {
+		consumers = append(consumers, diskConsumer{name: "seed image " + seed, filesystem: imagesFilesystem, size: seedSize})
+	}
+
+	images, err := r.additionalImages(ctx, ibu)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		if exists, err := r.ImagePuller.Exists(ctx, image); err == nil && exists {
+			continue
+		}
+		size, err := r.imageSize(ctx, mirrorSet, image)
+		if err != nil {
+			// Precaching reports the images that cannot be pulled, they are not worth failing here
+			r.Log.Info("Failed to get the size of an image, not counting it", "image", image, "error", err.Error())
+			continue
+		}
+		consumers = append(consumers, diskConsumer{name: image, filesystem: imagesFilesystem, size: size})
+	}
+	return consumers, nil
+}
+
+// seedImageSize returns the size of the seed image from the first of its mirrors that has it
+func (r *ImageBasedUpgradeReconciler) seedImageSize(ctx context.Context, mirrorSet *mirrors.Set, image string) (int64, error) {
+	candidates := mirrorSet.Resolve(image)
+	var errs []string
+	var err error
+	for _, candidate := range candidates {
+		var size int64
+		if size, err = r.SeedImageClient.Size(ctx, candidate); err == nil {
+			return size, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", candidate, err))
+	}
+	if len(candidates) == 1 {
+		return 0, err
+	}
+	return 0, fmt.Errorf("failed to get the size of seed image %s from any of its mirrors: %s", image, strings.Join(errs, "; "))
+}
+
+// imageSize returns the size of the image from the first of its mirrors that has it
+func (r *ImageBasedUpgradeReconciler) imageSize(ctx context.Context, mirrorSet *mirrors.Set, image string) (int64, error) {
+	var err error
+	for _, candidate := range mirrorSet.Resolve(image) {
+		var size int64
+		if size, err = r.ImagePuller.Size(ctx, candidate); err == nil {
+			return size, nil
+		}
+	}
+	return 0, err
+}
+
+// diskUsages sums the consumers per host filesystem, in the order the filesystems are first used
+func diskUsages(consumers []diskConsumer) ([]*filesystemUsage, error) {
+	var usages []*filesystemUsage
+	byPath := map[string]*filesystemUsage{}
+	for _, consumer := range consumers {
+		usage, ok := byPath[consumer.filesystem]
+		if !ok {
+			info, err := statFilesystem(filepath.Join(utils.HostPath, consumer.filesystem))
+			if err != nil {
+				return nil, err
+			}
+			for _, u := range usages {
+				if u.info.device == info.device {
+					usage = u
+					break
+				}
+			}
+			if usage == nil {
+				usage = &filesystemUsage{info: info}
+				usages = append(usages, usage)
+			}
+			usage.paths = append(usage.paths, consumer.filesystem)
+			byPath[consumer.filesystem] = usage
+		}
+		usage.consumers = append(usage.consumers, consumer)
+		usage.required += consumer.size
+	}
+	return usages, nil
+}
+
+func describeShortage(usage *filesystemUsage) string {
+	consumers := append([]diskConsumer(nil), usage.consumers...)
+	sort.SliceStable(consumers, func(i, j int) bool { return consumers[i].size > consumers[j].size })
+	if len(consumers) > maxReportedConsumers {
+		consumers = consumers[:maxReportedConsumers]
+	}
+	var biggest []string
+	for _, consumer := range consumers {
+		biggest = append(biggest, fmt.Sprintf("%s (%s)", consumer.name, formatBytes(consumer.size)))
+	}
+	return fmt.Sprintf("%s requires %s plus %s kept free, %s available, biggest consumers: %s",
+		strings.Join(usage.paths, ","), formatBytes(usage.required), formatBytes(usage.reserved()),
+		formatBytes(usage.info.available), strings.Join(biggest, ", "))
+}
+
+// formatBytes shows the exact bytes along with a readable size
+func formatBytes(size int64) string {
+	return fmt.Sprintf("%d bytes (%.1f GiB)", size, float64(size)/(1<<30))
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const gib = int64(1) << 30
+
+// fakeFilesystems makes statFilesystem return the given filesystems, keyed by their path on the host
+func fakeFilesystems(t *testing.T, filesystems map[string]filesystemInfo) {
+	old := statFilesystem
+	t.Cleanup(func() { statFilesystem = old })
+	statFilesystem = func(path string) (filesystemInfo, error) {
+		info, ok := filesystems[strings.TrimPrefix(path, utils.HostPath)]
+		if !ok {
+			return filesystemInfo{}, fmt.Errorf("failed to stat %s: no such file or directory", path)
+		}
+		return info, nil
+	}
+}
+
+// roomyFilesystems fakes a node with /var and /sysroot on a large, empty filesystem
+func roomyFilesystems(t *testing.T) {
+	root := filesystemInfo{device: 1, size: 1000 * gib, available: 1000 * gib}
+	fakeFilesystems(t, map[string]filesystemInfo{stateRootFilesystem: root, imagesFilesystem: root})
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	images := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ran-images", Namespace: lcaNs},
+		Data:       map[string]string{"images": "quay.io/ran/du:1.0\nquay.io/ran/cu:1.0\nquay.io/ran/ru:1.0\nquay.io/ran/broken:1.0"},
+	}
+	puller := &fakeImagePuller{
+		present: map[string]bool{"quay.io/ran/ru:1.0": true},
+		broken:  map[string]bool{"quay.io/ran/broken:1.0": true},
+		sizes:   map[string]int64{"quay.io/ran/du:1.0": 4 * gib, "quay.io/ran/cu:1.0": 2 * gib, "quay.io/ran/ru:1.0": 50 * gib},
+	}
+	seed := &fakeSeedImageClient{size: 20 * gib}
+
+	testcases := []struct {
+		name        string
+		filesystems map[string]filesystemInfo
+		seed        *fakeSeedImageClient
+		fail        []string
+		message     string
+	}{
+		{
+			name: "shared filesystem with enough space",
+			filesystems: map[string]filesystemInfo{
+				stateRootFilesystem: {device: 1, size: 100 * gib, available: 40 * gib},
+				imagesFilesystem:    {device: 1, size: 100 * gib, available: 40 * gib},
+			},
+			message: "Enough disk space: /sysroot,/var needs 27917287424 bytes (26.0 GiB) of 42949672960 bytes (40.0 GiB) available",
+		},
+		{
+			name: "shared filesystem without room for the reserve",
+			filesystems: map[string]filesystemInfo{
+				stateRootFilesystem: {device: 1, size: 100 * gib, available: 30 * gib},
+				imagesFilesystem:    {device: 1, size: 100 * gib, available: 30 * gib},
+			},
+			fail: []string{
+				"not enough disk space: /sysroot,/var requires 27917287424 bytes (26.0 GiB) plus 10737418240 bytes (10.0 GiB) kept free, 32212254720 bytes (30.0 GiB) available",
+				"biggest consumers: stateroot from quay.io/openshift-kni/seed:4.14.1 (21474836480 bytes (20.0 GiB)), quay.io/ran/du:1.0 (4294967296 bytes (4.0 GiB)), quay.io/ran/cu:1.0",
+			},
+		},
+		{
+			name: "seed image to pull",
+			filesystems: map[string]filesystemInfo{
+				stateRootFilesystem: {device: 1, size: 100 * gib, available: 40 * gib},
+				imagesFilesystem:    {device: 1, size: 100 * gib, available: 40 * gib},
+			},
+			seed: &fakeSeedImageClient{size: 20 * gib, missing: true},
+			fail: []string{
+				"/sysroot,/var requires 49392123904 bytes (46.0 GiB)",
+				"biggest consumers: stateroot from quay.io/openshift-kni/seed:4.14.1 (21474836480 bytes (20.0 GiB)), " +
+					"seed image quay.io/openshift-kni/seed:4.14.1 (21474836480 bytes (20.0 GiB))",
+			},
+		},
+		{
+			name: "separate /var filesystem",
+			filesystems: map[string]filesystemInfo{
+				stateRootFilesystem: {device: 1, size: 100 * gib, available: 80 * gib},
+				imagesFilesystem:    {device: 2, size: 10 * gib, available: 5 * gib},
+			},
+			fail: []string{"/var requires 6442450944 bytes (6.0 GiB) plus 1073741824 bytes (1.0 GiB) kept free, 5368709120 bytes (5.0 GiB) available"},
+		},
+		{
+			name:        "missing filesystem",
+			filesystems: map[string]filesystemInfo{},
+			fail:        []string{"failed to stat", "/sysroot: no such file or directory"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeFilesystems(t, tc.filesystems)
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+				Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+					Stage:            ranv1alpha1.Stages.Prep,
+					SeedImageRef:     testSeedImageRef,
+					AdditionalImages: ranv1alpha1.ConfigMapRef{Name: "ran-images"},
+				},
+			}
+			fakeClient, _ := getFakeClientFromObjects(ibu, images)
+			seedImageClient := tc.seed
+			if seedImageClient == nil {
+				seedImageClient = seed
+			}
+			r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), SeedImageClient: seedImageClient, ImagePuller: puller}
+
+			done, message, err := r.checkDiskSpace(context.TODO(), ibu)
+			if len(tc.fail) > 0 {
+				assert.False(t, done)
+				for _, fail := range tc.fail {
+					assert.ErrorContains(t, err, fail)
+				}
+				if strings.HasPrefix(tc.fail[0], "not enough") {
+					assert.Equal(t, utils.ConditionReasons.InsufficientDiskSpace, failureReason(err))
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, done)
+			assert.Equal(t, tc.message, message)
+		})
+	}
+}
+
+func TestCheckDiskSpaceBeforePull(t *testing.T) {
+	fakeFilesystems(t, map[string]filesystemInfo{
+		stateRootFilesystem: {device: 1, size: 100 * gib, available: 30 * gib},
+		imagesFilesystem:    {device: 1, size: 100 * gib, available: 30 * gib},
+	})
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: testSeedImageRef},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu)
+	seed := &fakeSeedImageClient{size: 20 * gib, missing: true}
+	r := &ImageBasedUpgradeReconciler{
+		Client:          fakeClient,
+		APIReader:       fakeClient,
+		Log:             logr.Discard(),
+		Scheme:          fakeClient.Scheme(),
+		Recorder:        record.NewFakeRecorder(100),
+		SeedImageClient: seed,
+	}
+	_, err := r.handlePrep(context.TODO(), ibu)
+	assert.NoError(t, err)
+
+	completed := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted))
+	assert.Equal(t, metav1.ConditionFalse, completed.Status)
+	assert.Equal(t, string(utils.ConditionReasons.InsufficientDiskSpace), completed.Reason)
+	assert.Empty(t, seed.pulled, "nothing is pulled once the seed is known not to fit")
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// recordEvent emits an event on the IBU. Reasons are the condition reasons so events and
+// conditions can be correlated.
+func (r *ImageBasedUpgradeReconciler) recordEvent(ibu *ranv1alpha1.ImageBasedUpgrade, eventType string, reason utils.ConditionReason, message string) {
+	r.Recorder.Event(ibu, eventType, string(reason), message)
+}
+
+// recordTransitionEvent emits the event of a stage transition, before it is applied.
+// A rejection is only reported the first time it is seen.
+func (r *ImageBasedUpgradeReconciler) recordTransitionEvent(ibu *ranv1alpha1.ImageBasedUpgrade, transition statemachine.Transition) {
+	switch transition.Action {
+	case statemachine.Actions.StartPrep, statemachine.Actions.StartUpgrade, statemachine.Actions.StartRollback:
+		r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.InProgress,
+			fmt.Sprintf("%s stage started", transition.Desired))
+	case statemachine.Actions.Abort:
+		r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.Aborting,
+			fmt.Sprintf("Aborting from state %s", transition.From))
+	case statemachine.Actions.Finalize:
+		r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.Finalizing,
+			fmt.Sprintf("Finalizing from state %s", transition.From))
+	case statemachine.Actions.Reset:
+		if transition.ChangesState() {
+			r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.Idle,
+				fmt.Sprintf("Back to Idle from state %s", transition.From))
+		}
+	case statemachine.Actions.Reject:
+		rejected := transition.Conditions[0]
+		existing := meta.FindStatusCondition(ibu.Status.Conditions, string(rejected.Type))
+		if existing != nil && existing.Reason == string(rejected.Reason) && existing.Message == rejected.Message {
+			return
+		}
+		r.recordEvent(ibu, corev1.EventTypeWarning, utils.ConditionReasons.InvalidTransition,
+			fmt.Sprintf("Transition from %s to %s is not allowed: %s", transition.From, transition.Desired, transition.Message()))
+	}
+}
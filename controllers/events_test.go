@@ -0,0 +1,113 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	roomyFilesystems(t)
+	key := types.NamespacedName{Name: utils.IBUName, Namespace: lcaNs}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Upgrade, SeedImageRef: testSeedImageRef},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu)
+	recorder := record.NewFakeRecorder(100)
+	r := &ImageBasedUpgradeReconciler{
+		Client:          fakeClient,
+		APIReader:       fakeClient,
+		Recorder:        recorder,
+		Log:             logr.Discard(),
+		Scheme:          fakeClient.Scheme(),
+		Executor:        &ops.MockExecutor{},
+		OstreeClient:    ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: "rhcos", Booted: true}),
+		SeedImageClient: &fakeSeedImageClient{},
+	}
+	reconcileStage := func(stage ranv1alpha1.ImageBasedUpgradeStage) []string {
+		current := &ranv1alpha1.ImageBasedUpgrade{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), key, current))
+		current.Spec.Stage = stage
+		assert.NoError(t, fakeClient.Update(context.TODO(), current))
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+		assert.NoError(t, err)
+		return drainEvents(recorder)
+	}
+
+	// A rejected transition is reported once
+	assert.Equal(t, []string{
+		"Warning InvalidTransition Transition from Idle to Upgrade is not allowed: Previous stage not succeeded yet",
+	}, reconcileStage(ranv1alpha1.Stages.Upgrade))
+	assert.Empty(t, reconcileStage(ranv1alpha1.Stages.Upgrade))
+
+	// The step events are between the stage ones
+	events := reconcileStage(ranv1alpha1.Stages.Prep)
+	assert.Equal(t, "Normal InProgress Prep stage started", events[0])
+	assert.Contains(t, events, "Normal Completed Prep step PullSeedImage completed")
+	assert.Equal(t, "Normal Completed Prep completed", events[len(events)-1])
+
+	assert.Equal(t, []string{
+		"Normal Aborting Aborting from state PrepCompleted",
+		"Normal Completed Abort step RemovePullSecret completed",
+		"Normal Completed Abort step DeleteOADPObjects completed",
+		"Normal AbortCompleted Abort completed",
+	}, reconcileStage(ranv1alpha1.Stages.Idle))
+}
+
+func TestFailedStageEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	r := &ImageBasedUpgradeReconciler{Log: logr.Discard(), Recorder: recorder}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	steps := []stageStep{{
+		name: checkDiskSpaceStep,
+		run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+			return false, "", &stageError{reason: utils.ConditionReasons.InsufficientDiskSpace, err: fmt.Errorf("/var is full")}
+		},
+	}}
+
+	_, err := r.runStage(context.TODO(), ibu, ranv1alpha1.Stages.Prep, steps)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Warning InsufficientDiskSpace Prep failed: /var is full"}, drainEvents(recorder),
+		"a failed step is reported once, with the reason of the failure")
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/internal/manifests"
+)
+
+const (
+	validateExtraManifestsStep = "ValidateExtraManifests"
+	applyExtraManifestsStep    = "ApplyExtraManifests"
+
+	// extraManifestsFieldManager owns the fields of the ExtraManifests, so applying them again is a no-op
+	extraManifestsFieldManager = "lifecycle-agent"
+)
+
+// extraManifest is an object of the ExtraManifests and the ConfigMap holding it
+type extraManifest struct {
+	configMap string
+	obj       *unstructured.Unstructured
+}
+
+// extraManifests reads the objects of the ExtraManifests ConfigMaps, in list order
+func (r *ImageBasedUpgradeReconciler) extraManifests(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) ([]extraManifest, error) {
+	var result []extraManifest
+	for _, ref := range ibu.Spec.ExtraManifests {
+		key := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+		if key.Namespace == "" {
+			key.Namespace = ibu.Namespace
+		}
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, key, cm); err != nil {
+			return nil, fmt.Errorf("failed to get the ExtraManifests ConfigMap %s: %w", key, err)
+		}
+		objs, err := manifests.Parse(cm.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ExtraManifests ConfigMap %s: %w", key, err)
+		}
+		for _, manifest := range objs {
+			result = append(result, extraManifest{configMap: key.String(), obj: manifest.Object})
+		}
+	}
+	return result, nil
+}
+
+// manifestsClient returns the client acting as the ExtraManifestsServiceAccount
+func (r *ImageBasedUpgradeReconciler) manifestsClient(ibu *ranv1alpha1.ImageBasedUpgrade) (client.Client, error) {
+	account := ibu.Spec.ExtraManifestsServiceAccount
+	if account == "" {
+		return nil, fmt.Errorf("extraManifestsServiceAccount is not set, the ExtraManifests are applied as that ServiceAccount")
+	}
+	c, err := r.ClientAs(fmt.Sprintf("system:serviceaccount:%s:%s", ibu.Namespace, account))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client of ServiceAccount %s/%s: %w", ibu.Namespace, account, err)
+	}
+	return c, nil
+}
+
+// validateExtraManifests applies the ExtraManifests with a server-side dry-run, so the broken ones fail Prep
+// rather than the upgrade after the reboot
+func (r *ImageBasedUpgradeReconciler) validateExtraManifests(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	extra, err := r.extraManifests(ctx, ibu)
+	if err != nil {
+		return false, "", err
+	}
+	if len(extra) == 0 {
+		ibu.Status.ExtraManifests = nil
+		return true, "No extra manifests", nil
+	}
+	c, err := r.manifestsClient(ibu)
+	if err != nil {
+		return false, "", err
+	}
+	results, err := r.applyExtraManifests(ctx, c, extra, true)
+	ibu.Status.ExtraManifests = results
+	if err != nil {
+		return false, "", fmt.Errorf("extra manifests failed validation: %w", err)
+	}
+	return true, fmt.Sprintf("Validated %d extra manifests", len(results)), nil
+}
+
+// applyExtraManifestsAfterPivot applies the ExtraManifests in order once the node booted the new stateroot
+func (r *ImageBasedUpgradeReconciler) applyExtraManifestsAfterPivot(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	if pivoted, waiting, err := r.pivoted(ctx, ibu); err != nil || !pivoted {
+		return false, waiting, err
+	}
+	extra, err := r.extraManifests(ctx, ibu)
+	if err != nil {
+		return false, "", err
+	}
+	if len(extra) == 0 {
+		ibu.Status.ExtraManifests = nil
+		return true, "No extra manifests", nil
+	}
+	c, err := r.manifestsClient(ibu)
+	if err != nil {
+		return false, "", err
+	}
+	results, err := r.applyExtraManifests(ctx, c, extra, false)
+	ibu.Status.ExtraManifests = results
+	if err != nil {
+		return false, "", fmt.Errorf("failed to apply extra manifests: %w", err)
+	}
+	return true, fmt.Sprintf("Applied %d extra manifests", len(results)), nil
+}
+
+// applyExtraManifests server-side applies every manifest with c, or only dry-runs them, and returns the result of each
+// along with an error listing the failed ones
+func (r *ImageBasedUpgradeReconciler) applyExtraManifests(ctx context.Context, c client.Client, extra []extraManifest, dryRun bool) ([]ranv1alpha1.ManifestResult, error) {
+	// A dry-run cannot see the namespaces and CRDs the manifests before create
+	namespaces, kinds := map[string]bool{}, map[string]bool{}
+	outcome := ranv1alpha1.ManifestOutcomes.Applied
+	opts := []client.PatchOption{client.FieldOwner(extraManifestsFieldManager), client.ForceOwnership}
+	if dryRun {
+		outcome = ranv1alpha1.ManifestOutcomes.Validated
+		opts = append(opts, client.DryRunAll)
+	}
+
+	var results []ranv1alpha1.ManifestResult
+	var failed []string
+	for _, manifest := range extra {
+		obj := manifest.obj.DeepCopy()
+		result := ranv1alpha1.ManifestResult{
+			ConfigMap:  manifest.configMap,
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			Outcome:    outcome,
+		}
+		err := c.Patch(ctx, obj, client.Apply, opts...)
+		if err != nil && dryRun && createdByEarlierManifest(err, manifest.obj, namespaces, kinds) {
+			err = nil
+		}
+		if err != nil {
+			result.Outcome = ranv1alpha1.ManifestOutcomes.Failed
+			result.Message = err.Error()
+			failed = append(failed, fmt.Sprintf("%s %s from %s: %s", result.Kind, objectKey(manifest.obj), manifest.configMap, err))
+		} else if !dryRun {
+			r.Log.Info("Applied extra manifest", "kind", result.Kind, "object", objectKey(manifest.obj))
+		}
+		results = append(results, result)
+		recordCreatedTypes(manifest.obj, namespaces, kinds)
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%d of %d failed: %s", len(failed), len(extra), strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// recordCreatedTypes remembers the namespaces and the custom resource kinds an applied manifest creates
+func recordCreatedTypes(obj *unstructured.Unstructured, namespaces, kinds map[string]bool) {
+	switch obj.GroupVersionKind().GroupKind().String() {
+	case "Namespace":
+		namespaces[obj.GetName()] = true
+	case "CustomResourceDefinition.apiextensions.k8s.io":
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		kinds[kind+"."+group] = true
+	}
+}
+
+// createdByEarlierManifest tells whether a dry-run failed only because the namespace or the CRD of the object
+// is created by an earlier manifest, which a dry-run does not persist
+func createdByEarlierManifest(err error, obj *unstructured.Unstructured, namespaces, kinds map[string]bool) bool {
+	if meta.IsNoMatchError(err) {
+		return kinds[obj.GroupVersionKind().GroupKind().String()]
+	}
+	if apierrors.IsNotFound(err) && obj.GetNamespace() != "" {
+		return namespaces[obj.GetNamespace()]
+	}
+	return false
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
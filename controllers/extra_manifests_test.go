@@ -0,0 +1,240 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const testExtraManifests = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: ran
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: du-config
+  namespace: ran
+data:
+  mode: standalone
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: tunings.ran.example.com
+spec:
+  group: ran.example.com
+  names:
+    kind: Tuning
+---
+apiVersion: ran.example.com/v1
+kind: Tuning
+metadata:
+  name: du
+  namespace: ran
+`
+
+// applyInterceptor plays the API server for server-side applies, which the fake client does not support: an apply
+// creates the object, fails when its namespace or its CRD is missing, and a dry-run only checks.
+// The objects with the reject annotation are invalid.
+func applyInterceptor(crds map[schema.GroupKind]bool) interceptor.Funcs {
+	return interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if patch != client.Apply {
+				return c.Patch(ctx, obj, patch, opts...)
+			}
+			gvk := obj.GetObjectKind().GroupVersionKind()
+			if gvk.Group != "" && gvk.Group != "apiextensions.k8s.io" && !crds[gvk.GroupKind()] {
+				return &meta.NoKindMatchError{GroupKind: gvk.GroupKind(), SearchedVersions: []string{gvk.Version}}
+			}
+			if obj.GetNamespace() != "" {
+				if err := c.Get(ctx, types.NamespacedName{Name: obj.GetNamespace()}, &corev1.Namespace{}); err != nil {
+					return err
+				}
+			}
+			if _, ok := obj.GetAnnotations()["test/reject"]; ok {
+				return apierrors.NewInvalid(gvk.GroupKind(), obj.GetName(), nil)
+			}
+			patchOpts := &client.PatchOptions{}
+			patchOpts.ApplyOptions(opts)
+			if len(patchOpts.DryRun) > 0 {
+				return nil
+			}
+			if gvk.Kind == "CustomResourceDefinition" {
+				crds[schema.GroupKind{Group: "ran.example.com", Kind: "Tuning"}] = true
+			}
+			return c.Create(ctx, obj)
+		},
+	}
+}
+
+// clientAs hands out c for any user and records the users
+func clientAs(c client.Client, users *[]string) func(string) (client.Client, error) {
+	return func(user string) (client.Client, error) {
+		*users = append(*users, user)
+		return c, nil
+	}
+}
+
+func TestExtraManifests(t *testing.T) {
+	roomyFilesystems(t)
+	manifests := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "du-manifests", Namespace: lcaNs},
+		Data:       map[string]string{"manifests.yaml": testExtraManifests},
+	}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:                        ranv1alpha1.Stages.Prep,
+			SeedImageRef:                 testSeedImageRef,
+			ExtraManifests:               []ranv1alpha1.ConfigMapRef{{Name: "du-manifests"}},
+			ExtraManifestsServiceAccount: "du-deployer",
+			RollbackTarget:               "rhcos",
+		},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(testscheme).
+		WithObjects(ibu, manifests).
+		WithInterceptorFuncs(applyInterceptor(map[schema.GroupKind]bool{})).
+		Build()
+	var users []string
+	r := &ImageBasedUpgradeReconciler{
+		Client:       fakeClient,
+		Log:          logr.Discard(),
+		ClientAs:     clientAs(fakeClient, &users),
+		OstreeClient: pivotedOstree(),
+	}
+
+	// The dry-run accepts the objects in the namespace and of the CRD created by the earlier manifests
+	done, message, err := r.validateExtraManifests(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "Validated 4 extra manifests", message)
+	assert.Equal(t, []ranv1alpha1.ManifestResult{
+		{ConfigMap: "openshift-lifecycle-agent/du-manifests", APIVersion: "v1", Kind: "Namespace", Name: "ran", Outcome: ranv1alpha1.ManifestOutcomes.Validated},
+		{ConfigMap: "openshift-lifecycle-agent/du-manifests", APIVersion: "v1", Kind: "ConfigMap", Namespace: "ran", Name: "du-config", Outcome: ranv1alpha1.ManifestOutcomes.Validated},
+		{ConfigMap: "openshift-lifecycle-agent/du-manifests", APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition", Name: "tunings.ran.example.com", Outcome: ranv1alpha1.ManifestOutcomes.Validated},
+		{ConfigMap: "openshift-lifecycle-agent/du-manifests", APIVersion: "ran.example.com/v1", Kind: "Tuning", Namespace: "ran", Name: "du", Outcome: ranv1alpha1.ManifestOutcomes.Validated},
+	}, ibu.Status.ExtraManifests)
+	assert.True(t, apierrors.IsNotFound(fakeClient.Get(context.TODO(), types.NamespacedName{Name: "ran"}, &corev1.Namespace{})),
+		"the dry-run creates nothing")
+
+	// After the pivot they are applied in order
+	ibu.Status.SeedImage = &ranv1alpha1.SeedImageStatus{Version: "4.14.1"}
+	done, message, err = r.applyExtraManifestsAfterPivot(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "Applied 4 extra manifests", message)
+	for _, result := range ibu.Status.ExtraManifests {
+		assert.Equal(t, ranv1alpha1.ManifestOutcomes.Applied, result.Outcome)
+	}
+	cm := &corev1.ConfigMap{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "du-config", Namespace: "ran"}, cm))
+	assert.Equal(t, "standalone", cm.Data["mode"])
+	assert.Equal(t, []string{
+		"system:serviceaccount:openshift-lifecycle-agent:du-deployer",
+		"system:serviceaccount:openshift-lifecycle-agent:du-deployer",
+	}, users, "the manifests are validated and applied as the ServiceAccount")
+}
+
+func TestExtraManifestsWithoutServiceAccount(t *testing.T) {
+	manifests := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "du-manifests", Namespace: lcaNs},
+		Data:       map[string]string{"manifests.yaml": testExtraManifests},
+	}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:          ranv1alpha1.Stages.Prep,
+			ExtraManifests: []ranv1alpha1.ConfigMapRef{{Name: "du-manifests"}},
+		},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu, manifests)
+	var users []string
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), ClientAs: clientAs(fakeClient, &users)}
+
+	_, _, err := r.validateExtraManifests(context.TODO(), ibu)
+	assert.EqualError(t, err, "extraManifestsServiceAccount is not set, the ExtraManifests are applied as that ServiceAccount")
+	assert.Empty(t, users, "the operator never applies the manifests with its own permissions")
+}
+
+func TestExtraManifestsValidationFailure(t *testing.T) {
+	roomyFilesystems(t)
+	manifests := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "du-manifests", Namespace: lcaNs},
+		Data: map[string]string{
+			"broken.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: broken\n  namespace: default\n  annotations:\n    test/reject: \"\"\n",
+			"orphan.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: orphan\n  namespace: missing\n",
+			"valid.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: valid\n  namespace: default\n",
+		},
+	}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:                        ranv1alpha1.Stages.Prep,
+			SeedImageRef:                 testSeedImageRef,
+			ExtraManifests:               []ranv1alpha1.ConfigMapRef{{Name: "du-manifests"}},
+			ExtraManifestsServiceAccount: "du-deployer",
+		},
+	}
+	defaultNs := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	fakeClient := fake.NewClientBuilder().WithScheme(testscheme).
+		WithObjects(ibu, manifests, defaultNs).WithStatusSubresource(ibu).
+		WithInterceptorFuncs(applyInterceptor(map[schema.GroupKind]bool{})).
+		Build()
+	r := &ImageBasedUpgradeReconciler{
+		Client:          fakeClient,
+		APIReader:       fakeClient,
+		Log:             logr.Discard(),
+		Scheme:          fakeClient.Scheme(),
+		Recorder:        record.NewFakeRecorder(100),
+		SeedImageClient: &fakeSeedImageClient{},
+	}
+	r.ClientAs = clientAs(fakeClient, &[]string{})
+	_, err := r.handlePrep(context.TODO(), ibu)
+	assert.NoError(t, err)
+
+	completed := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted))
+	assert.Equal(t, metav1.ConditionFalse, completed.Status)
+	assert.Contains(t, completed.Message, "extra manifests failed validation: 2 of 3 failed: "+
+		"ConfigMap default/broken from openshift-lifecycle-agent/du-manifests: ConfigMap \"broken\" is invalid")
+	assert.Contains(t, completed.Message, "ConfigMap missing/orphan from openshift-lifecycle-agent/du-manifests: namespaces \"missing\" not found")
+	var outcomes []ranv1alpha1.ManifestOutcome
+	for _, result := range ibu.Status.ExtraManifests {
+		outcomes = append(outcomes, result.Outcome)
+	}
+	assert.Equal(t, []ranv1alpha1.ManifestOutcome{
+		ranv1alpha1.ManifestOutcomes.Failed, ranv1alpha1.ManifestOutcomes.Failed, ranv1alpha1.ManifestOutcomes.Validated,
+	}, outcomes)
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+)
+
+// httpProbeTimeout bounds each HTTP probe of a health gate
+const httpProbeTimeout = 10 * time.Second
+
+var (
+	clusterOperatorListGVK   = schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "ClusterOperatorList"}
+	machineConfigPoolListGVK = schema.GroupVersionKind{Group: "machineconfiguration.openshift.io", Version: "v1", Kind: "MachineConfigPoolList"}
+	etcdGVK                  = schema.GroupVersionKind{Group: "operator.openshift.io", Version: "v1", Kind: "Etcd"}
+)
+
+// clusterHealthCheck is one of the checks run by a health gate, run returns why the check failed
+type clusterHealthCheck struct {
+	name ranv1alpha1.HealthCheckName
+	run  func(ctx context.Context) error
+}
+
+// prePivotHealthChecks returns the checks run before the pivot, in the order they run
+func (r *ImageBasedUpgradeReconciler) prePivotHealthChecks(gate ranv1alpha1.HealthGate) []clusterHealthCheck {
+	return append([]clusterHealthCheck{
+		{name: ranv1alpha1.HealthCheckNames.ClusterOperators, run: r.checkClusterOperators},
+		{name: ranv1alpha1.HealthCheckNames.NodeReady, run: r.checkNodesReady},
+		{name: ranv1alpha1.HealthCheckNames.MachineConfigPools, run: r.checkMachineConfigPools},
+		{name: ranv1alpha1.HealthCheckNames.CertificateSigningRequests, run: r.checkPendingCSRs},
+		{name: ranv1alpha1.HealthCheckNames.Etcd, run: r.checkEtcd},
+	}, r.gateHealthChecks(gate)...)
+}
+
+// postPivotHealthChecks returns the checks run once the node booted the new stateroot, in the order they run
+func (r *ImageBasedUpgradeReconciler) postPivotHealthChecks(gate ranv1alpha1.HealthGate) []clusterHealthCheck {
+	return append([]clusterHealthCheck{
+		{name: ranv1alpha1.HealthCheckNames.ClusterOperators, run: r.checkClusterOperators},
+		{name: ranv1alpha1.HealthCheckNames.NodeReady, run: r.checkNodesReady},
+	}, r.gateHealthChecks(gate)...)
+}
+
+// gateHealthChecks returns the checks of the workloads and HTTP probes listed by the gate, if any
+func (r *ImageBasedUpgradeReconciler) gateHealthChecks(gate ranv1alpha1.HealthGate) []clusterHealthCheck {
+	var checks []clusterHealthCheck
+	if len(gate.Workloads) > 0 {
+		checks = append(checks, clusterHealthCheck{
+			name: ranv1alpha1.HealthCheckNames.Workloads,
+			run:  func(ctx context.Context) error { return r.checkWorkloads(ctx, gate.Workloads) },
+		})
+	}
+	if len(gate.HTTPProbes) > 0 {
+		checks = append(checks, clusterHealthCheck{
+			name: ranv1alpha1.HealthCheckNames.HTTPProbes,
+			run:  func(ctx context.Context) error { return checkHTTPProbes(ctx, gate.HTTPProbes) },
+		})
+	}
+	return checks
+}
+
+// runHealthChecks runs the checks the gate does not skip and returns their results, along with
+// the failures formatted as "check: reason"
+func runHealthChecks(ctx context.Context, checks []clusterHealthCheck, gate ranv1alpha1.HealthGate) ([]ranv1alpha1.HealthCheckResult, []string) {
+	skipped := map[ranv1alpha1.HealthCheckName]bool{}
+	for _, name := range gate.SkippedChecks {
+		skipped[name] = true
+	}
+
+	var results []ranv1alpha1.HealthCheckResult
+	var failures []string
+	for _, check := range checks {
+		if skipped[check.name] {
+			continue
+		}
+		result := ranv1alpha1.HealthCheckResult{Name: string(check.name), Passed: true}
+		if err := check.run(ctx); err != nil {
+			result.Passed = false
+			result.Message = err.Error()
+			failures = append(failures, fmt.Sprintf("%s: %s", check.name, err))
+		}
+		results = append(results, result)
+	}
+	return results, failures
+}
+
+// checkClusterOperators returns an error listing the ClusterOperators that are not available or are degraded
+func (r *ImageBasedUpgradeReconciler) checkClusterOperators(ctx context.Context) error {
+	operators := &unstructured.UnstructuredList{}
+	operators.SetGroupVersionKind(clusterOperatorListGVK)
+	if err := r.List(ctx, operators); err != nil {
+		return fmt.Errorf("failed to list ClusterOperators: %w", err)
+	}
+
+	var unhealthy []string
+	for _, operator := range operators.Items {
+		if conditionStatus(operator, "Available") != "True" || conditionStatus(operator, "Degraded") == "True" {
+			unhealthy = append(unhealthy, operator.GetName())
+		}
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("ClusterOperators not available or degraded: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// checkNodesReady returns an error listing the nodes that are not Ready
+func (r *ImageBasedUpgradeReconciler) checkNodesReady(ctx context.Context) error {
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if len(nodes.Items) == 0 {
+		return fmt.Errorf("no nodes found")
+	}
+
+	var notReady []string
+	for _, node := range nodes.Items {
+		ready := false
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+		if !ready {
+			notReady = append(notReady, node.Name)
+		}
+	}
+	if len(notReady) > 0 {
+		return fmt.Errorf("nodes not ready: %s", strings.Join(notReady, ", "))
+	}
+	return nil
+}
+
+// checkMachineConfigPools returns an error listing the MachineConfigPools that are updating or degraded
+func (r *ImageBasedUpgradeReconciler) checkMachineConfigPools(ctx context.Context) error {
+	pools := &unstructured.UnstructuredList{}
+	pools.SetGroupVersionKind(machineConfigPoolListGVK)
+	if err := r.List(ctx, pools); err != nil {
+		return fmt.Errorf("failed to list MachineConfigPools: %w", err)
+	}
+
+	var unsettled []string
+	for _, pool := range pools.Items {
+		if conditionStatus(pool, "Updating") == "True" || conditionStatus(pool, "Degraded") == "True" {
+			unsettled = append(unsettled, pool.GetName())
+		}
+	}
+	if len(unsettled) > 0 {
+		return fmt.Errorf("MachineConfigPools updating or degraded: %s", strings.Join(unsettled, ", "))
+	}
+	return nil
+}
+
+// checkPendingCSRs returns an error listing the CertificateSigningRequests neither approved nor denied
+func (r *ImageBasedUpgradeReconciler) checkPendingCSRs(ctx context.Context) error {
+	csrs := &certificatesv1.CertificateSigningRequestList{}
+	if err := r.List(ctx, csrs); err != nil {
+		return fmt.Errorf("failed to list CertificateSigningRequests: %w", err)
+	}
+
+	var pending []string
+	for _, csr := range csrs.Items {
+		decided := false
+		for _, condition := range csr.Status.Conditions {
+			if condition.Type == certificatesv1.CertificateApproved || condition.Type == certificatesv1.CertificateDenied {
+				decided = true
+			}
+		}
+		if !decided {
+			pending = append(pending, csr.Name)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("CertificateSigningRequests pending: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// checkEtcd returns an error if the etcd members are not all available or the etcd operator is degraded
+func (r *ImageBasedUpgradeReconciler) checkEtcd(ctx context.Context) error {
+	etcd := &unstructured.Unstructured{}
+	etcd.SetGroupVersionKind(etcdGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: "cluster"}, etcd); err != nil {
+		return fmt.Errorf("failed to get the etcd operator: %w", err)
+	}
+	if conditionStatus(*etcd, "EtcdMembersAvailable") != "True" {
+		return fmt.Errorf("etcd members not available")
+	}
+	if conditionStatus(*etcd, "Degraded") == "True" {
+		return fmt.Errorf("etcd operator degraded")
+	}
+	return nil
+}
+
+// checkWorkloads returns an error listing the selected pods that are not running, and the selectors matching no pod
+func (r *ImageBasedUpgradeReconciler) checkWorkloads(ctx context.Context, selectors []ranv1alpha1.WorkloadSelector) error {
+	var problems []string
+	for _, selector := range selectors {
+		labelSelector, err := labels.Parse(selector.LabelSelector)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid label selector %q: %s", selector.LabelSelector, err))
+			continue
+		}
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.InNamespace(selector.Namespace), client.MatchingLabelsSelector{Selector: labelSelector}); err != nil {
+			return fmt.Errorf("failed to list the pods of namespace %s: %w", selector.Namespace, err)
+		}
+		if len(pods.Items) == 0 {
+			problems = append(problems, fmt.Sprintf("no pods in %s match %q", selector.Namespace, selector.LabelSelector))
+			continue
+		}
+		for _, pod := range pods.Items {
+			if problem := podProblem(pod); problem != "" {
+				problems = append(problems, fmt.Sprintf("pod %s/%s %s", pod.Namespace, pod.Name, problem))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("workloads not running: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// podProblem tells why a pod is neither completed nor running with all its containers ready, empty if it is
+func podProblem(pod corev1.Pod) string {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return ""
+	case corev1.PodRunning:
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				return ""
+			}
+		}
+		return "is not ready"
+	default:
+		return fmt.Sprintf("is %s", pod.Status.Phase)
+	}
+}
+
+// checkHTTPProbes returns an error listing the probes that failed
+func checkHTTPProbes(ctx context.Context, probes []ranv1alpha1.HTTPProbe) error {
+	var failed []string
+	for _, probe := range probes {
+		if err := runHTTPProbe(ctx, probe); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", probe.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("HTTP probes failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// runHTTPProbe sends a GET to the probe URL and checks the status code of the response
+func runHTTPProbe(ctx context.Context, probe ranv1alpha1.HTTPProbe) error {
+	ctx, cancel := context.WithTimeout(ctx, httpProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.URL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	defer transport.CloseIdleConnections()
+	if probe.InsecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // requested by the probe
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if probe.ExpectedStatus != 0 {
+		if resp.StatusCode != int(probe.ExpectedStatus) {
+			return fmt.Errorf("status %d, expected %d", resp.StatusCode, probe.ExpectedStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// conditionStatus returns the status of a condition of an unstructured object, or empty if it is not set
+func conditionStatus(obj unstructured.Unstructured, conditionType string) string {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		return status
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+)
+
+func testNode(name string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func testMachineConfigPool(name, updating, degraded string) *unstructured.Unstructured {
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(machineConfigPoolListGVK.GroupVersion().WithKind("MachineConfigPool"))
+	pool.SetName(name)
+	_ = unstructured.SetNestedSlice(pool.Object, []interface{}{
+		map[string]interface{}{"type": "Updating", "status": updating},
+		map[string]interface{}{"type": "Degraded", "status": degraded},
+	}, "status", "conditions")
+	return pool
+}
+
+func testCSR(name string, conditions ...certificatesv1.RequestConditionType) *certificatesv1.CertificateSigningRequest {
+	csr := &certificatesv1.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	for _, condition := range conditions {
+		csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+			Type:   condition,
+			Status: corev1.ConditionTrue,
+		})
+	}
+	return csr
+}
+
+func testPod(namespace, name string, labels map[string]string, phase corev1.PodPhase, ready corev1.ConditionStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Status: corev1.PodStatus{
+			Phase:      phase,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+		},
+	}
+}
+
+func testEtcd(membersAvailable, degraded string) *unstructured.Unstructured {
+	etcd := &unstructured.Unstructured{}
+	etcd.SetGroupVersionKind(etcdGVK)
+	etcd.SetName("cluster")
+	_ = unstructured.SetNestedSlice(etcd.Object, []interface{}{
+		map[string]interface{}{"type": "EtcdMembersAvailable", "status": membersAvailable},
+		map[string]interface{}{"type": "Degraded", "status": degraded},
+	}, "status", "conditions")
+	return etcd
+}
+
+// healthyCluster returns the objects of a cluster passing every health check
+func healthyCluster() []client.Object {
+	return []client.Object{
+		clusterOperator("etcd", "True", "False"),
+		testNode("sno", corev1.ConditionTrue),
+		testMachineConfigPool("master", "False", "False"),
+		testCSR("csr-approved", certificatesv1.CertificateApproved),
+		testEtcd("True", "False"),
+	}
+}
+
+func TestRunHealthChecks(t *testing.T) {
+	testcases := []struct {
+		name     string
+		objs     []client.Object
+		gate     ranv1alpha1.HealthGate
+		failures []string
+	}{
+		{
+			name: "healthy cluster",
+			objs: healthyCluster(),
+		},
+		{
+			name: "every check failing",
+			objs: []client.Object{
+				clusterOperator("etcd", "True", "True"),
+				testNode("sno", corev1.ConditionFalse),
+				testMachineConfigPool("master", "True", "False"),
+				testMachineConfigPool("worker", "False", "True"),
+				testCSR("csr-pending"),
+				testCSR("csr-denied", certificatesv1.CertificateDenied),
+				testEtcd("False", "False"),
+			},
+			failures: []string{
+				"ClusterOperators: ClusterOperators not available or degraded: etcd",
+				"NodeReady: nodes not ready: sno",
+				"MachineConfigPools: MachineConfigPools updating or degraded: master, worker",
+				"CertificateSigningRequests: CertificateSigningRequests pending: csr-pending",
+				"Etcd: etcd members not available",
+			},
+		},
+		{
+			name:     "degraded etcd operator",
+			objs:     append(healthyCluster()[:4], testEtcd("True", "True")),
+			failures: []string{"Etcd: etcd operator degraded"},
+		},
+		{
+			name:     "no nodes",
+			objs:     append(healthyCluster()[:1], healthyCluster()[2:]...),
+			failures: []string{"NodeReady: no nodes found"},
+		},
+		{
+			name: "skipped checks",
+			objs: []client.Object{
+				clusterOperator("etcd", "True", "False"),
+				testNode("sno", corev1.ConditionTrue),
+				testMachineConfigPool("master", "True", "False"),
+				testCSR("csr-pending"),
+				testEtcd("True", "False"),
+			},
+			gate: ranv1alpha1.HealthGate{SkippedChecks: []ranv1alpha1.HealthCheckName{
+				ranv1alpha1.HealthCheckNames.MachineConfigPools,
+				ranv1alpha1.HealthCheckNames.CertificateSigningRequests,
+			}},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClient, _ := getFakeClientFromObjects(tc.objs...)
+			r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+			results, failures := runHealthChecks(context.TODO(), r.prePivotHealthChecks(tc.gate), tc.gate)
+			assert.Equal(t, tc.failures, failures)
+			assert.Len(t, results, 5-len(tc.gate.SkippedChecks))
+			for _, result := range results {
+				assert.Equal(t, result.Message == "", result.Passed, result.Name)
+			}
+		})
+	}
+}
+
+func TestPostPivotHealthChecks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	web := map[string]string{"app": "web"}
+	healthy := []client.Object{clusterOperator("etcd", "True", "False"), testNode("sno", corev1.ConditionTrue)}
+
+	testcases := []struct {
+		name     string
+		objs     []client.Object
+		gate     ranv1alpha1.HealthGate
+		results  int
+		failures []string
+	}{
+		{
+			name:    "cluster operators and node only",
+			objs:    healthy,
+			results: 2,
+		},
+		{
+			name: "running workloads",
+			objs: append(healthy,
+				testPod("web", "web-1", web, corev1.PodRunning, corev1.ConditionTrue),
+				testPod("web", "web-job", web, corev1.PodSucceeded, corev1.ConditionFalse),
+				testPod("web", "cache-1", map[string]string{"app": "cache"}, corev1.PodPending, corev1.ConditionFalse),
+			),
+			gate:    ranv1alpha1.HealthGate{Workloads: []ranv1alpha1.WorkloadSelector{{Namespace: "web", LabelSelector: "app=web"}}},
+			results: 3,
+		},
+		{
+			name: "workloads not running",
+			objs: append(healthy,
+				testPod("web", "web-1", web, corev1.PodRunning, corev1.ConditionFalse),
+				testPod("web", "web-2", web, corev1.PodPending, corev1.ConditionFalse),
+			),
+			gate: ranv1alpha1.HealthGate{Workloads: []ranv1alpha1.WorkloadSelector{
+				{Namespace: "web"},
+				{Namespace: "db", LabelSelector: "app=db"},
+				{Namespace: "web", LabelSelector: "app in (web"},
+			}},
+			results: 3,
+			failures: []string{
+				"Workloads: workloads not running: pod web/web-1 is not ready, pod web/web-2 is Pending, " +
+					`no pods in db match "app=db", invalid label selector "app in (web": ` +
+					`unable to parse requirement: found '', expected: ',' or ')'`,
+			},
+		},
+		{
+			name: "HTTP probes",
+			objs: healthy,
+			gate: ranv1alpha1.HealthGate{HTTPProbes: []ranv1alpha1.HTTPProbe{
+				{Name: "healthz", URL: server.URL + "/healthz"},
+				{Name: "moved", URL: server.URL + "/moved", ExpectedStatus: http.StatusNoContent},
+			}},
+			results: 3,
+		},
+		{
+			name: "failing HTTP probes",
+			objs: healthy,
+			gate: ranv1alpha1.HealthGate{HTTPProbes: []ranv1alpha1.HTTPProbe{
+				{Name: "down", URL: server.URL + "/down"},
+				{Name: "unexpected", URL: server.URL + "/healthz", ExpectedStatus: http.StatusNoContent},
+			}},
+			results:  3,
+			failures: []string{"HTTPProbes: HTTP probes failed: down: status 503; unexpected: status 200, expected 204"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClient, _ := getFakeClientFromObjects(tc.objs...)
+			r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+			results, failures := runHealthChecks(context.TODO(), r.postPivotHealthChecks(tc.gate), tc.gate)
+			assert.Equal(t, tc.failures, failures)
+			assert.Len(t, results, tc.results)
+		})
+	}
+}
@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -32,18 +33,52 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
 	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+	"github.com/openshift-kni/lifecycle-agent/internal/precache"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
 )
 
 // ImageBasedUpgradeReconciler reconciles a ImageBasedUpgrade object
 type ImageBasedUpgradeReconciler struct {
 	client.Client
-	Log      logr.Logger
-	Scheme   *runtime.Scheme
-	Recorder record.EventRecorder
+	// APIReader reads from the API server rather than the cache, for the Secrets the operator does not watch
+	APIReader client.Reader
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	// Executor runs commands on the host
+	Executor ops.Executor
+	// OstreeClient manages the ostree stateroots and deployments of the host
+	OstreeClient ostreeclient.IClient
+	// SeedImageClient pulls and inspects the seed image
+	SeedImageClient seedimage.Client
+	// ImagePuller pulls the additional images during Prep
+	ImagePuller precache.Puller
+	// Namespace is where the ImageBasedUpgrade managed by the operator lives
+	Namespace string
+	// ClientAs returns a client acting as the given user, the ExtraManifests are applied with the permissions of
+	// the ServiceAccount set in the spec rather than the ones of the operator
+	ClientAs func(user string) (client.Client, error)
+	// DefaultTimeouts apply to the stages whose timeout is not set in the spec
+	DefaultTimeouts ranv1alpha1.StageTimeouts
+
+	// precache is the running precache job, reconciles are not concurrent so it needs no lock
+	precache *precacheJob
+	// seedPull is the running seed image pull, or the finished one until its result is recorded
+	seedPull *seedPullJob
+
+	// staterootUsages caches the disk usage of the stateroots by name
+	staterootUsages map[string]staterootUsage
+
+	// verifiedSteps are the succeeded steps checked since the operator started, keyed by run, start and step
+	verifiedSteps map[string]bool
 }
 
 func doNotRequeue() ctrl.Result {
@@ -75,18 +110,30 @@ func requeueWithCustomInterval(interval time.Duration) ctrl.Result {
 //+kubebuilder:rbac:groups=ran.openshift.io,resources=imagebasedupgrades/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch
+//+kubebuilder:rbac:groups=machineconfiguration.openshift.io,resources=machineconfigpools,verbs=get;list;watch
+//+kubebuilder:rbac:groups=operator.openshift.io,resources=etcds,verbs=get;list;watch
+//+kubebuilder:rbac:groups=config.openshift.io,resources=clusterversions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=config.openshift.io,resources=clusteroperators,verbs=get;list;watch
+//+kubebuilder:rbac:groups=config.openshift.io,resources=networks,verbs=get;list;watch
+//+kubebuilder:rbac:groups=config.openshift.io,resources=imagedigestmirrorsets;imagetagmirrorsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=operator.openshift.io,resources=imagecontentsourcepolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=operators.coreos.com,resources=clusterserviceversions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=velero.io,resources=backups;restores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=performance.openshift.io,resources=performanceprofiles,verbs=get;list;watch
+//+kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,resourceNames=privileged,verbs=use
 //+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=monitoring.coreos.com,resources=prometheusrules,verbs=get;list;watch;create;update;patch;delete
+// The ExtraManifests are applied as the ServiceAccount of the spec, the impersonate permission is granted in the
+// namespace of the operator by config/rbac/impersonation_role.yaml
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the ImageBasedUpgrade object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+// Reconcile drives the ImageBasedUpgrade through its lifecycle. The state is derived from the status conditions:
+// the handler of the stage active in that state runs first, then the transition to the stage requested in
+// spec.stage is looked up in the state machine and, when it starts a stage, its handler runs right away. The
+// stateroot inventory and the derived state are written to the status last.
 func (r *ImageBasedUpgradeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (nextReconcile ctrl.Result, err error) {
 	r.Log.Info("Start reconciling IBU", "name", req.NamespacedName)
 	defer func() {
@@ -99,7 +146,8 @@ func (r *ImageBasedUpgradeReconciler) Reconcile(ctx context.Context, req ctrl.Re
 
 	nextReconcile = doNotRequeue()
 
-	if req.Name != utils.IBUName {
+	if req.NamespacedName != r.singletonKey() {
+		err = r.flagStray(ctx, req.NamespacedName)
 		return
 	}
 
@@ -107,7 +155,8 @@ func (r *ImageBasedUpgradeReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	err = r.Get(ctx, req.NamespacedName, ibu)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			err = nil
+			// The operator owns the ImageBasedUpgrade, recreate it when deleted
+			err = r.ensureSingleton(ctx)
 			return
 		}
 		r.Log.Error(err, "Failed to get ImageBasedUpgrade")
@@ -116,34 +165,45 @@ func (r *ImageBasedUpgradeReconciler) Reconcile(ctx context.Context, req ctrl.Re
 
 	r.Log.Info("Loaded IBU", "name", req.NamespacedName, "version", ibu.GetResourceVersion(), "desired stage", ibu.Spec.Stage)
 
-	currentInProgressStage := utils.GetCurrentInProgressStage(ibu)
-	if currentInProgressStage != "" {
-		nextReconcile, err = r.handleStage(ctx, ibu, currentInProgressStage)
+	state := statemachine.GetState(ibu.Status.Conditions)
+	activeStage := statemachine.ActiveStage(state)
+	if activeStage != "" {
+		nextReconcile, err = r.handleStageWithTimeout(ctx, ibu, activeStage)
 		if err != nil {
 			return
 		}
 	}
 
 	desiredStage := ibu.Spec.Stage
-	if desiredStage != currentInProgressStage {
-		if validateStageTransition(ibu) {
-			// Update in progress condition to true and idle condition to false when transitioning to non idle stage
-			if desiredStage != ranv1alpha1.Stages.Idle {
-				utils.SetStatusCondition(&ibu.Status.Conditions,
-					utils.GetInProgressConditionType(desiredStage),
-					utils.ConditionReasons.InProgress,
-					metav1.ConditionTrue,
-					"In progress",
-					ibu.Generation,
-				)
-			}
-			nextReconcile, err = r.handleStage(ctx, ibu, desiredStage)
+	if desiredStage != activeStage {
+		// The stage handler may have moved the state forward
+		state = statemachine.GetState(ibu.Status.Conditions)
+		var transition statemachine.Transition
+		transition, err = statemachine.LookupFor(ibu, desiredStage)
+		if err != nil {
+			r.Log.Error(err, "Failed to look up stage transition")
+			return
+		}
+		r.Log.Info("Stage transition", "state", state, "desired stage", desiredStage, "action", transition.Action)
+		r.recordTransitionEvent(ibu, transition)
+		statemachine.Apply(ibu, transition)
+		if transition.Action == statemachine.Actions.StartPrep {
+			// A new upgrade starts, forget about the rollback of the previous one
+			ibu.Status.AutoRollback = nil
+		}
+		if transition.RunsHandler() {
+			nextReconcile, err = r.handleStageWithTimeout(ctx, ibu, desiredStage)
 			if err != nil {
 				return
 			}
 		}
 	}
 
+	// A failure to inspect the stateroots must not block the stage handling
+	if stateRootsErr := r.updateStateRoots(ctx, ibu); stateRootsErr != nil {
+		r.Log.Error(stateRootsErr, "Failed to update stateroot inventory")
+	}
+
 	// Update status
 	err = r.updateStatus(ctx, ibu)
 	return
@@ -166,7 +226,8 @@ func (r *ImageBasedUpgradeReconciler) handleStage(ctx context.Context, ibu *ranv
 func (r *ImageBasedUpgradeReconciler) handleAbortOrFinalize(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (nextReconcile ctrl.Result, err error) {
 	idleCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.Idle))
 	if idleCondition != nil && idleCondition.Status == metav1.ConditionFalse {
-		switch idleCondition.Reason {
+		reason := idleCondition.Reason
+		switch reason {
 		case string(utils.ConditionReasons.Aborting):
 			nextReconcile, err = r.handleAbort(ctx, ibu)
 		case string(utils.ConditionReasons.AbortFailed):
@@ -176,122 +237,21 @@ func (r *ImageBasedUpgradeReconciler) handleAbortOrFinalize(ctx context.Context,
 		case string(utils.ConditionReasons.FinalizeFailed):
 			nextReconcile, err = r.handleFinalizeFailure(ctx, ibu)
 		}
-		if nextReconcile.Requeue == false {
+		// Go back to idle once the handler is done, unless it moved to a failed state
+		idleCondition = meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.Idle))
+		if err == nil && nextReconcile == doNotRequeue() && idleCondition != nil && idleCondition.Reason == reason {
 			utils.ResetStatusConditions(&ibu.Status.Conditions, ibu.Generation)
 		}
 	}
 	return
 }
 
-func isRollbackAllowed(ibu *ranv1alpha1.ImageBasedUpgrade) bool {
-	upgradeInProgressCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeInProgress))
-	// TODO check if pivot is done
-	if upgradeInProgressCondition != nil {
-		// allowed if upgrade stage is in progress or has failed/completed
-		return true
-	}
-	return false
-}
-
-// isFinalizeAllowed returns true if upgrade completed or rollback completed
-func isFinalizeAllowed(ibu *ranv1alpha1.ImageBasedUpgrade) bool {
-	for _, conditionType := range utils.FinalConditionTypes {
-		condition := meta.FindStatusCondition(ibu.Status.Conditions, string(conditionType))
-		if condition != nil && condition.Status == metav1.ConditionTrue {
-			return true
-		}
-	}
-	return false
-}
-
-func isAbortAllowed(ibu *ranv1alpha1.ImageBasedUpgrade) bool {
-	upgradeCompletedCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeCompleted))
-	idleCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.Idle))
-	// TODO check if pivot has not been done
-	if idleCondition != nil && idleCondition.Status == metav1.ConditionFalse && upgradeCompletedCondition == nil {
-		// allowed if upgrade has not completed or failed yet
-		return true
-	}
-	return false
-}
-
-// TODO unit test this function once the logic is stablized
-func validateStageTransition(ibu *ranv1alpha1.ImageBasedUpgrade) bool {
-	switch ibu.Spec.Stage {
-	case ranv1alpha1.Stages.Rollback:
-		if !isRollbackAllowed(ibu) {
-			utils.SetStatusCondition(&ibu.Status.Conditions,
-				utils.ConditionTypes.RollbackInProgress,
-				utils.ConditionReasons.InvalidTransition,
-				metav1.ConditionFalse,
-				"Upgrade not started or already finalized",
-				ibu.Generation,
-			)
-			return false
-		}
-
-	case ranv1alpha1.Stages.Idle:
-		if isFinalizeAllowed(ibu) {
-			utils.SetStatusCondition(&ibu.Status.Conditions,
-				utils.ConditionTypes.Idle,
-				utils.ConditionReasons.Finalizing,
-				metav1.ConditionFalse,
-				"Finalizing",
-				ibu.Generation,
-			)
-		} else if isAbortAllowed(ibu) {
-			utils.SetStatusCondition(&ibu.Status.Conditions,
-				utils.ConditionTypes.Idle,
-				utils.ConditionReasons.Aborting,
-				metav1.ConditionFalse,
-				"Aborting",
-				ibu.Generation,
-			)
-		} else {
-			rollbackCompletedCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.RollbackCompleted))
-			idleCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.Idle))
-			// Special cases for setting idle when the IBU just got created or after manual cleanup for rollback failure is done
-			if (rollbackCompletedCondition != nil && rollbackCompletedCondition.Status == metav1.ConditionFalse) ||
-				idleCondition == nil {
-				utils.ResetStatusConditions(&ibu.Status.Conditions, ibu.Generation)
-			} else {
-				utils.SetStatusCondition(&ibu.Status.Conditions,
-					utils.ConditionTypes.Idle,
-					utils.ConditionReasons.InvalidTransition,
-					metav1.ConditionFalse,
-					"Upgrade or rollback still in progress",
-					ibu.Generation,
-				)
-				return false
-			}
-		}
-	default:
-		previousCompletedCondition := utils.GetPreviousCompletedCondition(ibu)
-		if previousCompletedCondition == nil || previousCompletedCondition.Status == metav1.ConditionFalse {
-			utils.SetStatusCondition(&ibu.Status.Conditions,
-				utils.GetInProgressConditionType(ibu.Spec.Stage),
-				utils.ConditionReasons.InvalidTransition,
-				metav1.ConditionFalse,
-				"Previous stage not succeeded yet",
-				ibu.Generation,
-			)
-			return false
-		}
-		// Set idle to false when transitioning to prep
-		if ibu.Spec.Stage == ranv1alpha1.Stages.Prep {
-			utils.SetStatusCondition(&ibu.Status.Conditions,
-				utils.ConditionTypes.Idle,
-				utils.ConditionReasons.InProgress,
-				metav1.ConditionFalse,
-				"In progress",
-				ibu.Generation)
-		}
-	}
-	return true
-}
-
 func (r *ImageBasedUpgradeReconciler) updateStatus(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
 	ibu.Status.ObservedGeneration = ibu.ObjectMeta.Generation
+	// Derived here rather than by the API conversion, so the API does not depend on the state machine
+	state := statemachine.GetState(ibu.Status.Conditions)
+	ibu.Status.State = string(state)
+	ibu.Status.ValidNextStages = statemachine.NextStagesFor(ibu)
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		err := r.Status().Update(ctx, ibu)
 		return err
@@ -304,10 +264,40 @@ func (r *ImageBasedUpgradeReconciler) updateStatus(ctx context.Context, ibu *ran
 	return nil
 }
 
+// requestStage sets spec.stage on behalf of the user, when the state machine allows moving to
+// that stage. It returns false if the transition is not allowed.
+func (r *ImageBasedUpgradeReconciler) requestStage(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, stage ranv1alpha1.ImageBasedUpgradeStage) (bool, error) {
+	state := statemachine.GetState(ibu.Status.Conditions)
+	transition, err := statemachine.LookupFor(ibu, stage)
+	if err != nil {
+		return false, err
+	}
+	if transition.Action == statemachine.Actions.Reject || stage == ibu.Spec.Stage {
+		r.Log.Info("Cannot request stage", "stage", stage, "state", state, "reason", transition.Message())
+		return false, nil
+	}
+
+	// Patch a copy so the status changes not saved yet are kept
+	r.Log.Info("Requesting stage", "stage", stage, "state", state)
+	updated := ibu.DeepCopy()
+	updated.Spec.Stage = stage
+	if err := r.Patch(ctx, updated, client.MergeFrom(ibu)); err != nil {
+		return false, fmt.Errorf("failed to set stage %s: %w", stage, err)
+	}
+	ibu.Spec.Stage = stage
+	ibu.ResourceVersion = updated.ResourceVersion
+	ibu.Generation = updated.Generation
+	return true, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ImageBasedUpgradeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("ImageBasedUpgrade")
 
+	if err := mgr.Add(manager.RunnableFunc(r.createSingletonOnStart)); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&ranv1alpha1.ImageBasedUpgrade{}, builder.WithPredicates(predicate.Funcs{
 			UpdateFunc: func(e event.UpdateEvent) bool {
@@ -320,7 +310,7 @@ func (r *ImageBasedUpgradeReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			},
 			CreateFunc:  func(ce event.CreateEvent) bool { return true },
 			GenericFunc: func(ge event.GenericEvent) bool { return false },
-			DeleteFunc:  func(de event.DeleteEvent) bool { return false },
+			DeleteFunc:  func(de event.DeleteEvent) bool { return true },
 		})).
 		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
 		Complete(r)
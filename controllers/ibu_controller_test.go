@@ -18,11 +18,15 @@ package controllers
 
 import (
 	"context"
+	"os"
 	"testing"
 
 	"github.com/go-logr/logr"
 	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
 	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -30,6 +34,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -46,12 +51,25 @@ func init() {
 	testscheme.AddKnownTypes(ranv1alpha1.GroupVersion, &ranv1alpha1.ImageBasedUpgrade{})
 }
 
+// TestMain points the host filesystem to a scratch directory, the stage handlers write to it
+func TestMain(m *testing.M) {
+	hostPath, err := os.MkdirTemp("", "lca-host")
+	if err != nil {
+		panic(err)
+	}
+	utils.HostPath = hostPath
+	code := m.Run()
+	_ = os.RemoveAll(hostPath)
+	os.Exit(code)
+}
+
 func getFakeClientFromObjects(objs ...client.Object) (client.WithWatch, error) {
 	c := fake.NewClientBuilder().WithScheme(testscheme).WithObjects(objs...).WithStatusSubresource(objs...).Build()
 	return c, nil
 }
 
 func TestImageBasedUpgradeReconciler_Reconcile(t *testing.T) {
+	roomyFilesystems(t)
 	testcases := []struct {
 		name         string
 		ibu          client.Object
@@ -83,6 +101,100 @@ func TestImageBasedUpgradeReconciler_Reconcile(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "idle IBU stays idle",
+			ibu: &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      utils.IBUName,
+					Namespace: lcaNs,
+				},
+				Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+					Stage: ranv1alpha1.Stages.Idle,
+				},
+				Status: ranv1alpha1.ImageBasedUpgradeStatus{
+					Conditions: []metav1.Condition{{
+						Type:   string(utils.ConditionTypes.Idle),
+						Reason: string(utils.ConditionReasons.Idle),
+						Status: metav1.ConditionTrue,
+					}},
+				},
+			},
+			request: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      utils.IBUName,
+					Namespace: lcaNs,
+				},
+			},
+			validateFunc: func(t *testing.T, result ctrl.Result, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.Idle, statemachine.GetState(ibu.Status.Conditions))
+				idleCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.Idle))
+				assert.Equal(t, metav1.ConditionTrue, idleCondition.Status)
+			},
+		},
+		{
+			name: "idle IBU to prep",
+			ibu: &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      utils.IBUName,
+					Namespace: lcaNs,
+				},
+				Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+					Stage:        ranv1alpha1.Stages.Prep,
+					SeedImageRef: testSeedImageRef,
+				},
+				Status: ranv1alpha1.ImageBasedUpgradeStatus{
+					Conditions: []metav1.Condition{{
+						Type:   string(utils.ConditionTypes.Idle),
+						Reason: string(utils.ConditionReasons.Idle),
+						Status: metav1.ConditionTrue,
+					}},
+				},
+			},
+			request: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      utils.IBUName,
+					Namespace: lcaNs,
+				},
+			},
+			validateFunc: func(t *testing.T, result ctrl.Result, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.PrepCompleted, statemachine.GetState(ibu.Status.Conditions))
+				assert.Equal(t, "PrepCompleted", ibu.Status.State)
+				assert.Equal(t, []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Idle, ranv1alpha1.Stages.Upgrade}, ibu.Status.ValidNextStages)
+				assert.Equal(t, "Prep", ibu.Status.Progress.Name)
+				assert.Equal(t, ranv1alpha1.StageRunOutcomes.Succeeded, ibu.Status.Progress.Outcome)
+				assert.False(t, ibu.Status.StartedAt.IsZero())
+			},
+		},
+		{
+			name: "idle IBU to upgrade is rejected",
+			ibu: &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      utils.IBUName,
+					Namespace: lcaNs,
+				},
+				Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+					Stage: ranv1alpha1.Stages.Upgrade,
+				},
+				Status: ranv1alpha1.ImageBasedUpgradeStatus{
+					Conditions: []metav1.Condition{{
+						Type:   string(utils.ConditionTypes.Idle),
+						Reason: string(utils.ConditionReasons.Idle),
+						Status: metav1.ConditionTrue,
+					}},
+				},
+			},
+			request: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      utils.IBUName,
+					Namespace: lcaNs,
+				},
+			},
+			validateFunc: func(t *testing.T, result ctrl.Result, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.Idle, statemachine.GetState(ibu.Status.Conditions))
+				upgradeCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeInProgress))
+				assert.Equal(t, string(utils.ConditionReasons.InvalidTransition), upgradeCondition.Reason)
+			},
+		},
 	}
 	ns := &corev1.Namespace{
 		ObjectMeta: v1.ObjectMeta{
@@ -98,9 +210,14 @@ func TestImageBasedUpgradeReconciler_Reconcile(t *testing.T) {
 			}
 
 			r := &ImageBasedUpgradeReconciler{
-				Client: fakeClient,
-				Log:    logr.Discard(),
-				Scheme: fakeClient.Scheme(),
+				Client:          fakeClient,
+				APIReader:       fakeClient,
+				Recorder:        record.NewFakeRecorder(100),
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Executor:        &ops.MockExecutor{},
+				OstreeClient:    ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: "rhcos", Booted: true}),
+				SeedImageClient: &fakeSeedImageClient{},
 			}
 			result, err := r.Reconcile(context.TODO(), tc.request)
 			if err != nil {
@@ -18,14 +18,39 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 
 	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 func (r *ImageBasedUpgradeReconciler) handleAbort(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (ctrl.Result, error) {
 
+	r.stopPrecache()
+	r.stopSeedPull()
 	// TODO actual steps
+	done, err := r.runSteps(ctx, ibu, abortRun, []stageStep{
+		{name: removePullSecretStep, run: r.removePullSecret},
+		{name: deleteOADPObjectsStep, run: r.deleteOADPObjects},
+	})
+	if err != nil {
+		r.Log.Error(err, "Abort failed")
+		r.recordEvent(ibu, corev1.EventTypeWarning, utils.ConditionReasons.AbortFailed, fmt.Sprintf("Abort failed: %s", err))
+		utils.SetStatusCondition(&ibu.Status.Conditions,
+			utils.ConditionTypes.Idle,
+			utils.ConditionReasons.AbortFailed,
+			metav1.ConditionFalse,
+			fmt.Sprintf("Abort failed: %s", err),
+			ibu.Generation)
+		return doNotRequeue(), nil
+	}
+	if !done {
+		return requeueWithShortInterval(), nil
+	}
+	r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.AbortCompleted, "Abort completed")
 	// If succeeds, return doNotRequeue
 	return doNotRequeue(), nil
 }
@@ -40,6 +65,25 @@ func (r *ImageBasedUpgradeReconciler) handleAbortFailure(ctx context.Context, ib
 func (r *ImageBasedUpgradeReconciler) handleFinalize(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (ctrl.Result, error) {
 
 	// TODO actual steps
+	done, err := r.runSteps(ctx, ibu, finalizeRun, []stageStep{
+		{name: removePullSecretStep, run: r.removePullSecret},
+		{name: deleteOADPObjectsStep, run: r.deleteOADPObjects},
+	})
+	if err != nil {
+		r.Log.Error(err, "Finalize failed")
+		r.recordEvent(ibu, corev1.EventTypeWarning, utils.ConditionReasons.FinalizeFailed, fmt.Sprintf("Finalize failed: %s", err))
+		utils.SetStatusCondition(&ibu.Status.Conditions,
+			utils.ConditionTypes.Idle,
+			utils.ConditionReasons.FinalizeFailed,
+			metav1.ConditionFalse,
+			fmt.Sprintf("Finalize failed: %s", err),
+			ibu.Generation)
+		return doNotRequeue(), nil
+	}
+	if !done {
+		return requeueWithShortInterval(), nil
+	}
+	r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.FinalizeCompleted, "Finalize completed")
 	// If succeeds, return doNotRequeue
 	return doNotRequeue(), nil
 }
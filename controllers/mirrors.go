@@ -0,0 +1,85 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/mirrors"
+)
+
+var (
+	imageDigestMirrorSetListGVK     = schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "ImageDigestMirrorSetList"}
+	imageTagMirrorSetListGVK        = schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "ImageTagMirrorSetList"}
+	imageContentSourcePolicyListGVK = schema.GroupVersionKind{Group: "operator.openshift.io", Version: "v1alpha1", Kind: "ImageContentSourcePolicyList"}
+	neverContactSourceMirrorPolicy  = "NeverContactSource"
+	imageDigestMirrorsField         = "imageDigestMirrors"
+	imageTagMirrorsField            = "imageTagMirrors"
+	repositoryDigestMirrorsField    = "repositoryDigestMirrors"
+)
+
+// mirrorSet returns the mirror rules of the ImageDigestMirrorSets, ImageTagMirrorSets and legacy
+// ImageContentSourcePolicies of the cluster, the kinds the cluster does not serve are skipped
+func (r *ImageBasedUpgradeReconciler) mirrorSet(ctx context.Context) (*mirrors.Set, error) {
+	var rules []mirrors.Rule
+	for _, source := range []struct {
+		gvk   schema.GroupVersionKind
+		field string
+		kind  mirrors.Kind
+	}{
+		{gvk: imageDigestMirrorSetListGVK, field: imageDigestMirrorsField, kind: mirrors.Digest},
+		{gvk: imageTagMirrorSetListGVK, field: imageTagMirrorsField, kind: mirrors.Tag},
+		{gvk: imageContentSourcePolicyListGVK, field: repositoryDigestMirrorsField, kind: mirrors.Digest},
+	} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(source.gvk)
+		if err := r.List(ctx, list); err != nil {
+			if isAbsent(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to list %s: %w", source.gvk.Kind, err)
+		}
+		for _, item := range list.Items {
+			entries, _, _ := unstructured.NestedSlice(item.Object, "spec", source.field)
+			for _, entry := range entries {
+				rule, ok := mirrorRule(entry, source.kind)
+				if ok {
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	return mirrors.NewSet(rules...), nil
+}
+
+func mirrorRule(entry interface{}, kind mirrors.Kind) (mirrors.Rule, bool) {
+	fields, ok := entry.(map[string]interface{})
+	if !ok {
+		return mirrors.Rule{}, false
+	}
+	rule := mirrors.Rule{Kind: kind}
+	rule.Source, _, _ = unstructured.NestedString(fields, "source")
+	rule.Mirrors, _, _ = unstructured.NestedStringSlice(fields, "mirrors")
+	policy, _, _ := unstructured.NestedString(fields, "mirrorSourcePolicy")
+	rule.NeverContactSource = policy == neverContactSourceMirrorPolicy
+	// A source without mirrors would only be blocked, which is left to the registries configuration of the node
+	return rule, rule.Source != "" && len(rule.Mirrors) > 0
+}
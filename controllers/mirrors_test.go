@@ -0,0 +1,107 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func testMirrorObject(gvk schema.GroupVersionKind, field, source string, policy string, mirrors ...string) *unstructured.Unstructured {
+	entry := map[string]interface{}{"source": source}
+	var list []interface{}
+	for _, mirror := range mirrors {
+		list = append(list, mirror)
+	}
+	if list != nil {
+		entry["mirrors"] = list
+	}
+	if policy != "" {
+		entry["mirrorSourcePolicy"] = policy
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{field: []interface{}{entry}},
+	}}
+	obj.SetGroupVersionKind(gvk.GroupVersion().WithKind(strings.TrimSuffix(gvk.Kind, "List")))
+	obj.SetName(strings.NewReplacer("/", "-", ".", "-").Replace(source + "-" + field))
+	return obj
+}
+
+func testDigestMirrorSet(source string, mirrors ...string) *unstructured.Unstructured {
+	return testMirrorObject(imageDigestMirrorSetListGVK, imageDigestMirrorsField, source, "", mirrors...)
+}
+
+func testTagMirrorSet(source string, mirrors ...string) *unstructured.Unstructured {
+	return testMirrorObject(imageTagMirrorSetListGVK, imageTagMirrorsField, source, "", mirrors...)
+}
+
+func TestMirrorSet(t *testing.T) {
+	testcases := []struct {
+		name     string
+		objs     []client.Object
+		image    string
+		expected []string
+	}{
+		{
+			name:     "no mirrors",
+			image:    "quay.io/ran/du:1.0",
+			expected: []string{"quay.io/ran/du:1.0"},
+		},
+		{
+			name: "digest mirrors merged with the legacy policies",
+			objs: []client.Object{
+				testDigestMirrorSet("quay.io/ran", "mirror1.example.com/ran"),
+				testMirrorObject(imageContentSourcePolicyListGVK, repositoryDigestMirrorsField, "quay.io/ran", "",
+					"mirror2.example.com/ran", "mirror1.example.com/ran"),
+			},
+			image:    "quay.io/ran/du@sha256:1234",
+			expected: []string{"mirror1.example.com/ran/du@sha256:1234", "mirror2.example.com/ran/du@sha256:1234", "quay.io/ran/du@sha256:1234"},
+		},
+		{
+			name: "source never contacted",
+			objs: []client.Object{
+				testMirrorObject(imageTagMirrorSetListGVK, imageTagMirrorsField, "quay.io/ran", neverContactSourceMirrorPolicy, "mirror.example.com/ran"),
+			},
+			image:    "quay.io/ran/du:1.0",
+			expected: []string{"mirror.example.com/ran/du:1.0"},
+		},
+		{
+			name: "sources without mirrors are ignored",
+			objs: []client.Object{
+				testMirrorObject(imageTagMirrorSetListGVK, imageTagMirrorsField, "quay.io/ran", neverContactSourceMirrorPolicy),
+			},
+			image:    "quay.io/ran/du:1.0",
+			expected: []string{"quay.io/ran/du:1.0"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClient, _ := getFakeClientFromObjects(tc.objs...)
+			r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+			mirrorSet, err := r.mirrorSet(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, mirrorSet.Resolve(tc.image))
+		})
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/internal/oadp"
+)
+
+const (
+	backupApplicationsStep  = "BackupApplications"
+	restoreApplicationsStep = "RestoreApplications"
+	deleteOADPObjectsStep   = "DeleteOADPObjects"
+)
+
+// oadpContent reads the Backups and Restores of the OADPContent ConfigMap, nil if none is set
+func (r *ImageBasedUpgradeReconciler) oadpContent(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (*oadp.Content, error) {
+	ref := ibu.Spec.OADPContent
+	if ref.Name == "" {
+		return nil, nil
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = ibu.Namespace
+	}
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, cm); err != nil {
+		return nil, fmt.Errorf("failed to get the OADPContent ConfigMap %s/%s: %w", namespace, ref.Name, err)
+	}
+	content, err := oadp.Parse(cm.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OADPContent ConfigMap %s/%s: %w", namespace, ref.Name, err)
+	}
+	return content, nil
+}
+
+// backupApplications applies the Backups of the OADPContent wave by wave, each wave once the previous
+// one completed, and fails when a Backup fails
+func (r *ImageBasedUpgradeReconciler) backupApplications(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	content, err := r.oadpContent(ctx, ibu)
+	if err != nil {
+		return false, "", err
+	}
+	if content == nil || len(content.Backups) == 0 {
+		ibu.Status.Backups = nil
+		return true, "No OADP backups to apply", nil
+	}
+
+	operations, waiting, err := r.applyOADPWaves(ctx, oadpRun(ibu), content.Backups)
+	ibu.Status.Backups = operations
+	if err != nil {
+		return false, "", err
+	}
+	if waiting != "" {
+		return false, waiting, nil
+	}
+	return true, fmt.Sprintf("Completed %d backups", len(operations)), nil
+}
+
+// restoreApplications applies the Restores of the OADPContent wave by wave once the node booted the new
+// stateroot, and fails when a Restore fails
+func (r *ImageBasedUpgradeReconciler) restoreApplications(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	if pivoted, waiting, err := r.pivoted(ctx, ibu); err != nil || !pivoted {
+		return false, waiting, err
+	}
+	content, err := r.oadpContent(ctx, ibu)
+	if err != nil {
+		return false, "", err
+	}
+	if content == nil || len(content.Restores) == 0 {
+		ibu.Status.Restores = nil
+		return true, "No OADP restores to apply", nil
+	}
+
+	operations, waiting, err := r.applyOADPWaves(ctx, oadpRun(ibu), content.Restores)
+	ibu.Status.Restores = operations
+	if err != nil {
+		return false, "", err
+	}
+	if waiting != "" {
+		return false, waiting, nil
+	}
+	var warnings int64
+	for _, operation := range operations {
+		warnings += operation.Warnings
+	}
+	if warnings > 0 {
+		return true, fmt.Sprintf("Completed %d restores with %d warnings", len(operations), warnings), nil
+	}
+	return true, fmt.Sprintf("Completed %d restores", len(operations)), nil
+}
+
+// applyOADPWaves applies the CRs of the first waves that are not complete yet and reports the progress of all
+// the CRs. It returns why it is waiting while a wave is in progress, and an error listing the failed CRs of
+// the wave if any failed.
+func (r *ImageBasedUpgradeReconciler) applyOADPWaves(ctx context.Context, run string, waves []oadp.Wave) ([]ranv1alpha1.OADPOperation, string, error) {
+	var operations []ranv1alpha1.OADPOperation
+	var waiting string
+	for _, wave := range waves {
+		if waiting != "" {
+			// Later waves wait for the one in progress
+			for _, obj := range wave.Objects {
+				operations = append(operations, oadpOperation(obj, wave.Number, oadp.Progress{}))
+			}
+			continue
+		}
+
+		completed := 0
+		var failed []string
+		for _, obj := range wave.Objects {
+			current, err := r.applyOADPObject(ctx, run, obj)
+			if err != nil {
+				return operations, "", err
+			}
+			progress := oadp.GetProgress(current)
+			operations = append(operations, oadpOperation(obj, wave.Number, progress))
+			switch {
+			case progress.Done():
+				completed++
+			case progress.Failed():
+				failed = append(failed, fmt.Sprintf("%s %s/%s failed with %s", obj.GetKind(), obj.GetNamespace(), obj.GetName(), progress.Error()))
+			}
+		}
+		if len(failed) > 0 {
+			return operations, "", fmt.Errorf("wave %s: %s", waveName(wave.Number), strings.Join(failed, "; "))
+		}
+		if completed < len(wave.Objects) {
+			waiting = fmt.Sprintf("Waiting for wave %s: %d of %d %ss completed",
+				waveName(wave.Number), completed, len(wave.Objects), wave.Objects[0].GetKind())
+		}
+	}
+	return operations, waiting, nil
+}
+
+// oadpRun identifies the upgrade run the CRs are applied for, from the spec and the start of the run, which the
+// checkpoint keeps across the pivot
+func oadpRun(ibu *ranv1alpha1.ImageBasedUpgrade) string {
+	run := specFingerprint(ibu)[:16]
+	if progress := ibu.Status.Progress; progress != nil {
+		run += "-" + strconv.FormatInt(progress.StartedAt.Unix(), 10)
+	}
+	return run
+}
+
+// applyOADPObject creates the CR unless it exists already for the same run, e.g. applied before a restart, and
+// returns it as on the cluster. A CR left by another run is deleted and created again once it is gone, until then
+// it is returned without progress.
+func (r *ImageBasedUpgradeReconciler) applyOADPObject(ctx context.Context, run string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(obj.GroupVersionKind())
+	err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, current)
+	if err == nil {
+		applied, ok := current.GetLabels()[oadp.RunLabel]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s %s/%s exists and was not applied by an upgrade, remove it or rename it in the OADPContent",
+				obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		case applied == run:
+			return current, nil
+		case current.GetDeletionTimestamp() == nil:
+			if err := r.Delete(ctx, current); err != nil && !apierrors.IsNotFound(err) {
+				return nil, oadpError(obj, "delete stale", err)
+			}
+			r.Log.Info("Deleted OADP CR of another upgrade run", "kind", obj.GetKind(), "namespace", obj.GetNamespace(),
+				"name", obj.GetName(), "run", applied)
+		}
+		return obj.DeepCopy(), nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, oadpError(obj, "get", err)
+	}
+	created := obj.DeepCopy()
+	labels := created.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[oadp.RunLabel] = run
+	created.SetLabels(labels)
+	if err := r.Create(ctx, created); err != nil {
+		return nil, oadpError(obj, "create", err)
+	}
+	r.Log.Info("Applied OADP CR", "kind", obj.GetKind(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+	return created, nil
+}
+
+// deleteOADPObjects deletes the Backups and Restores applied by the upgrade runs, once they are no longer needed
+func (r *ImageBasedUpgradeReconciler) deleteOADPObjects(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	deleted := 0
+	for _, gvk := range []schema.GroupVersionKind{oadp.BackupGVK, oadp.RestoreGVK} {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, list, client.HasLabels{oadp.RunLabel}); err != nil {
+			if isAbsent(err) {
+				continue
+			}
+			return false, "", fmt.Errorf("failed to list the %ss applied by the upgrade: %w", gvk.Kind, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				return false, "", oadpError(obj, "delete", err)
+			}
+			deleted++
+		}
+	}
+	return true, fmt.Sprintf("Deleted %d OADP CRs", deleted), nil
+}
+
+func oadpError(obj *unstructured.Unstructured, verb string, err error) error {
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("failed to %s %s %s/%s, OADP is not installed: %w", verb, obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+	}
+	return fmt.Errorf("failed to %s %s %s/%s: %w", verb, obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+}
+
+func oadpOperation(obj *unstructured.Unstructured, wave int, progress oadp.Progress) ranv1alpha1.OADPOperation {
+	operation := ranv1alpha1.OADPOperation{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		Wave:      wave,
+		Phase:     string(progress.Phase),
+		Warnings:  progress.Warnings,
+		Errors:    progress.Errors,
+	}
+	if progress.Failed() {
+		operation.Message = progress.Message
+	}
+	return operation
+}
+
+func waveName(number int) string {
+	if number == oadp.LastWave {
+		return "last"
+	}
+	return strconv.Itoa(number)
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/oadp"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+const testOADPBackups = `apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  name: apps
+  annotations:
+    lca.openshift.io/apply-wave: "10"
+---
+apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  name: cluster
+  annotations:
+    lca.openshift.io/apply-wave: "1"
+---
+apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  name: leftovers
+`
+
+func testOADPContent(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "oadp-content", Namespace: lcaNs},
+		Data:       data,
+	}
+}
+
+// setOADPStatus plays Velero, reporting the status of a Backup or a Restore
+func setOADPStatus(t *testing.T, c client.Client, kind, name string, status map[string]interface{}) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(oadp.BackupGVK.GroupVersion().WithKind(kind))
+	assert.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: oadp.DefaultNamespace}, obj))
+	obj.Object["status"] = status
+	assert.NoError(t, c.Update(context.TODO(), obj))
+}
+
+func phases(operations []ranv1alpha1.OADPOperation) map[string]string {
+	result := map[string]string{}
+	for _, operation := range operations {
+		result[operation.Name] = operation.Phase
+	}
+	return result
+}
+
+func TestBackupApplications(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:       ranv1alpha1.Stages.Upgrade,
+			OADPContent: ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+		},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu, testOADPContent(map[string]string{"backups.yaml": testOADPBackups}))
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+	done, message, err := r.backupApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "Waiting for wave 1: 0 of 1 Backups completed", message)
+	assert.Equal(t, []ranv1alpha1.OADPOperation{
+		{Name: "cluster", Namespace: oadp.DefaultNamespace, Wave: 1},
+		{Name: "apps", Namespace: oadp.DefaultNamespace, Wave: 10},
+		{Name: "leftovers", Namespace: oadp.DefaultNamespace, Wave: oadp.LastWave},
+	}, ibu.Status.Backups)
+
+	// The next wave is only applied once the previous one completed
+	backups := &unstructured.UnstructuredList{}
+	backups.SetGroupVersionKind(oadp.BackupGVK.GroupVersion().WithKind("BackupList"))
+	assert.NoError(t, fakeClient.List(context.TODO(), backups))
+	assert.Len(t, backups.Items, 1)
+	setOADPStatus(t, fakeClient, "Backup", "cluster", map[string]interface{}{"phase": "InProgress"})
+	_, message, _ = r.backupApplications(context.TODO(), ibu)
+	assert.Equal(t, "Waiting for wave 1: 0 of 1 Backups completed", message)
+	assert.Equal(t, map[string]string{"cluster": "InProgress", "apps": "", "leftovers": ""}, phases(ibu.Status.Backups))
+
+	setOADPStatus(t, fakeClient, "Backup", "cluster", map[string]interface{}{"phase": "Completed", "warnings": int64(2)})
+	_, message, _ = r.backupApplications(context.TODO(), ibu)
+	assert.Equal(t, "Waiting for wave 10: 0 of 1 Backups completed", message)
+	assert.Equal(t, int64(2), ibu.Status.Backups[0].Warnings)
+
+	setOADPStatus(t, fakeClient, "Backup", "apps", map[string]interface{}{"phase": "Completed"})
+	_, message, _ = r.backupApplications(context.TODO(), ibu)
+	assert.Equal(t, "Waiting for wave last: 0 of 1 Backups completed", message)
+
+	setOADPStatus(t, fakeClient, "Backup", "leftovers", map[string]interface{}{"phase": "Completed"})
+	done, message, err = r.backupApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "Completed 3 backups", message)
+	assert.Equal(t, map[string]string{"cluster": "Completed", "apps": "Completed", "leftovers": "Completed"}, phases(ibu.Status.Backups))
+}
+
+func TestBackupApplicationsFailure(t *testing.T) {
+	roomyFilesystems(t)
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:       ranv1alpha1.Stages.Upgrade,
+			OADPContent: ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+		},
+	}
+	objs := append([]client.Object{ibu, testOADPContent(map[string]string{"backups.yaml": testOADPBackups})}, healthyCluster()...)
+	fakeClient, _ := getFakeClientFromObjects(objs...)
+	r := &ImageBasedUpgradeReconciler{
+		Client:   fakeClient,
+		Log:      logr.Discard(),
+		Recorder: record.NewFakeRecorder(100),
+	}
+
+	_, err := r.handleUpgrade(context.TODO(), ibu)
+	assert.NoError(t, err)
+	setOADPStatus(t, fakeClient, "Backup", "cluster", map[string]interface{}{
+		"phase":            "FailedValidation",
+		"validationErrors": []interface{}{"backup storage location default not found"},
+	})
+	_, err = r.handleUpgrade(context.TODO(), ibu)
+	assert.NoError(t, err)
+
+	completed := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeCompleted))
+	assert.Equal(t, metav1.ConditionFalse, completed.Status)
+	assert.Contains(t, completed.Message,
+		"wave 1: Backup openshift-adp/cluster failed with phase FailedValidation: backup storage location default not found")
+	assert.Equal(t, "backup storage location default not found", ibu.Status.Backups[0].Message)
+}
+
+// testOADPObject is a CR as left on the cluster, labelled with the run that applied it if any
+func testOADPObject(kind, name, run string, status map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	obj.SetGroupVersionKind(oadp.BackupGVK.GroupVersion().WithKind(kind))
+	obj.SetName(name)
+	obj.SetNamespace(oadp.DefaultNamespace)
+	if run != "" {
+		obj.SetLabels(map[string]string{oadp.RunLabel: run})
+	}
+	return obj
+}
+
+func TestBackupApplicationsOfAnotherRun(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:       ranv1alpha1.Stages.Upgrade,
+			OADPContent: ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+		},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{Progress: &ranv1alpha1.StageRun{Name: "Upgrade", StartedAt: metav1.Now()}},
+	}
+	stale := testOADPObject("Backup", "cluster", "0123456789abcdef-1", map[string]interface{}{"phase": "Completed"})
+	fakeClient, _ := getFakeClientFromObjects(ibu, testOADPContent(map[string]string{"backups.yaml": testOADPBackups}))
+	assert.NoError(t, fakeClient.Create(context.TODO(), stale))
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+	// The completed Backup of a previous run is not taken for this one
+	_, message, err := r.backupApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.Equal(t, "Waiting for wave 1: 0 of 1 Backups completed", message)
+	_, message, err = r.backupApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.Equal(t, "Waiting for wave 1: 0 of 1 Backups completed", message)
+	backup := &unstructured.Unstructured{}
+	backup.SetGroupVersionKind(oadp.BackupGVK)
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "cluster", Namespace: oadp.DefaultNamespace}, backup))
+	assert.Equal(t, oadpRun(ibu), backup.GetLabels()[oadp.RunLabel])
+	assert.Nil(t, backup.Object["status"], "the Backup is taken again")
+
+	// The same run keeps its Backup
+	setOADPStatus(t, fakeClient, "Backup", "cluster", map[string]interface{}{"phase": "Completed"})
+	_, message, _ = r.backupApplications(context.TODO(), ibu)
+	assert.Equal(t, "Waiting for wave 10: 0 of 1 Backups completed", message)
+}
+
+func TestBackupApplicationsNotApplied(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:       ranv1alpha1.Stages.Upgrade,
+			OADPContent: ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+		},
+	}
+	users := testOADPObject("Backup", "cluster", "", map[string]interface{}{"phase": "Completed"})
+	fakeClient, _ := getFakeClientFromObjects(ibu, testOADPContent(map[string]string{"backups.yaml": testOADPBackups}), users)
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+	_, _, err := r.backupApplications(context.TODO(), ibu)
+	assert.EqualError(t, err,
+		"Backup openshift-adp/cluster exists and was not applied by an upgrade, remove it or rename it in the OADPContent")
+}
+
+func TestDeleteOADPObjects(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs}}
+	fakeClient, _ := getFakeClientFromObjects(ibu,
+		testOADPObject("Backup", "cluster", "0123456789abcdef-1", nil),
+		testOADPObject("Backup", "apps", "0123456789abcdef-2", nil),
+		testOADPObject("Backup", "users", "", nil),
+		testOADPObject("Restore", "cluster", "0123456789abcdef-2", nil))
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+	done, message, err := r.deleteOADPObjects(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "Deleted 3 OADP CRs", message)
+	restores := &unstructured.UnstructuredList{}
+	restores.SetGroupVersionKind(oadp.RestoreGVK.GroupVersion().WithKind("RestoreList"))
+	assert.NoError(t, fakeClient.List(context.TODO(), restores))
+	assert.Empty(t, restores.Items)
+	backups := &unstructured.UnstructuredList{}
+	backups.SetGroupVersionKind(oadp.BackupGVK.GroupVersion().WithKind("BackupList"))
+	assert.NoError(t, fakeClient.List(context.TODO(), backups))
+	assert.Len(t, backups.Items, 1)
+	assert.Equal(t, "users", backups.Items[0].GetName(), "the Backups not applied by an upgrade are kept")
+}
+
+func TestBackupApplicationsAfterHealthGate(t *testing.T) {
+	roomyFilesystems(t)
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:       ranv1alpha1.Stages.Upgrade,
+			OADPContent: ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+		},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu, testOADPContent(map[string]string{"backups.yaml": testOADPBackups}),
+		testNode("sno", corev1.ConditionFalse))
+	r := &ImageBasedUpgradeReconciler{
+		Client:   fakeClient,
+		Log:      logr.Discard(),
+		Recorder: record.NewFakeRecorder(100),
+	}
+
+	_, err := r.handleUpgrade(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.Equal(t, ranv1alpha1.StepStates.Running, findStep(ibu.Status.Progress, prePivotHealthCheckStep).State)
+	assert.Equal(t, ranv1alpha1.StepStates.Pending, findStep(ibu.Status.Progress, backupApplicationsStep).State)
+	backups := &unstructured.UnstructuredList{}
+	backups.SetGroupVersionKind(oadp.BackupGVK.GroupVersion().WithKind("BackupList"))
+	assert.NoError(t, fakeClient.List(context.TODO(), backups))
+	assert.Empty(t, backups.Items, "nothing is backed up from an unhealthy cluster")
+}
+
+func TestBackupApplicationsWithoutContent(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Status:     ranv1alpha1.ImageBasedUpgradeStatus{Backups: []ranv1alpha1.OADPOperation{{Name: "stale"}}},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu)
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+	done, message, err := r.backupApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "No OADP backups to apply", message)
+	assert.Nil(t, ibu.Status.Backups)
+
+	ibu.Spec.OADPContent = ranv1alpha1.ConfigMapRef{Name: "missing"}
+	_, _, err = r.backupApplications(context.TODO(), ibu)
+	assert.ErrorContains(t, err, "failed to get the OADPContent ConfigMap openshift-lifecycle-agent/missing")
+}
+
+const testOADPRestores = `apiVersion: velero.io/v1
+kind: Restore
+metadata:
+  name: apps
+  annotations:
+    lca.openshift.io/apply-wave: "2"
+spec:
+  backupName: apps
+---
+apiVersion: velero.io/v1
+kind: Restore
+metadata:
+  name: cluster
+  annotations:
+    lca.openshift.io/apply-wave: "1"
+spec:
+  backupName: cluster
+`
+
+func TestRestoreApplications(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:          ranv1alpha1.Stages.Upgrade,
+			OADPContent:    ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+			RollbackTarget: "rhcos",
+		},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{SeedImage: &ranv1alpha1.SeedImageStatus{Version: "4.14.1"}},
+	}
+	content := testOADPContent(map[string]string{"backups.yaml": testOADPBackups, "restores.yaml": testOADPRestores})
+	fakeClient, _ := getFakeClientFromObjects(ibu, content)
+	r := &ImageBasedUpgradeReconciler{
+		Client:       fakeClient,
+		Log:          logr.Discard(),
+		OstreeClient: ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: "rhcos", Booted: true}),
+	}
+
+	// Nothing is restored before the pivot
+	done, message, err := r.restoreApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "Waiting for the node to boot the new stateroot of release 4.14.1, it still runs stateroot rhcos", message)
+	assert.Nil(t, ibu.Status.Restores)
+
+	r.OstreeClient = pivotedOstree()
+	_, message, _ = r.restoreApplications(context.TODO(), ibu)
+	assert.Equal(t, "Waiting for wave 1: 0 of 1 Restores completed", message)
+	assert.Equal(t, map[string]string{"cluster": "", "apps": ""}, phases(ibu.Status.Restores))
+
+	setOADPStatus(t, fakeClient, "Restore", "cluster", map[string]interface{}{"phase": "Completed", "warnings": int64(3)})
+	_, message, _ = r.restoreApplications(context.TODO(), ibu)
+	assert.Equal(t, "Waiting for wave 2: 0 of 1 Restores completed", message)
+
+	setOADPStatus(t, fakeClient, "Restore", "apps", map[string]interface{}{"phase": "Completed"})
+	done, message, err = r.restoreApplications(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "Completed 2 restores with 3 warnings", message)
+	assert.Equal(t, []ranv1alpha1.OADPOperation{
+		{Name: "cluster", Namespace: oadp.DefaultNamespace, Wave: 1, Phase: "Completed", Warnings: 3},
+		{Name: "apps", Namespace: oadp.DefaultNamespace, Wave: 2, Phase: "Completed"},
+	}, ibu.Status.Restores)
+}
+
+func TestRestoreApplicationsFailure(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:          ranv1alpha1.Stages.Upgrade,
+			OADPContent:    ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+			RollbackTarget: "rhcos",
+		},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{SeedImage: &ranv1alpha1.SeedImageStatus{Version: "4.14.1"}},
+	}
+	content := testOADPContent(map[string]string{"restores.yaml": testOADPRestores})
+	fakeClient, _ := getFakeClientFromObjects(ibu, content)
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), OstreeClient: pivotedOstree()}
+
+	_, _, _ = r.restoreApplications(context.TODO(), ibu)
+	setOADPStatus(t, fakeClient, "Restore", "cluster", map[string]interface{}{
+		"phase": "PartiallyFailed", "errors": int64(4), "warnings": int64(1),
+	})
+	_, _, err := r.restoreApplications(context.TODO(), ibu)
+	assert.EqualError(t, err, "wave 1: Restore openshift-adp/cluster failed with phase PartiallyFailed (4 errors)")
+	assert.Equal(t, ranv1alpha1.OADPOperation{
+		Name: "cluster", Namespace: oadp.DefaultNamespace, Wave: 1, Phase: "PartiallyFailed", Warnings: 1, Errors: 4,
+	}, ibu.Status.Restores[0])
+}
+
+func TestRestoreApplicationsOfAnotherRun(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:          ranv1alpha1.Stages.Upgrade,
+			OADPContent:    ranv1alpha1.ConfigMapRef{Name: "oadp-content"},
+			RollbackTarget: "rhcos",
+		},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{
+			SeedImage: &ranv1alpha1.SeedImageStatus{Version: "4.14.1"},
+			Progress:  &ranv1alpha1.StageRun{Name: "Upgrade", StartedAt: metav1.Now()},
+		},
+	}
+	content := testOADPContent(map[string]string{"restores.yaml": testOADPRestores})
+	fakeClient, _ := getFakeClientFromObjects(ibu, content)
+	assert.NoError(t, fakeClient.Create(context.TODO(),
+		testOADPObject("Restore", "cluster", "0123456789abcdef-1", map[string]interface{}{"phase": "Completed"})))
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), OstreeClient: pivotedOstree()}
+
+	// The Restore of a previous run, of other Backups, is not taken for this one
+	for i := 0; i < 2; i++ {
+		done, message, err := r.restoreApplications(context.TODO(), ibu)
+		assert.NoError(t, err)
+		assert.False(t, done)
+		assert.Equal(t, "Waiting for wave 1: 0 of 1 Restores completed", message)
+	}
+	restore := &unstructured.Unstructured{}
+	restore.SetGroupVersionKind(oadp.RestoreGVK)
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "cluster", Namespace: oadp.DefaultNamespace}, restore))
+	assert.Equal(t, oadpRun(ibu), restore.GetLabels()[oadp.RunLabel])
+	assert.Nil(t, restore.Object["status"], "the applications are restored again")
+}
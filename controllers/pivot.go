@@ -0,0 +1,95 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const (
+	prePivotHealthCheckStep = "PrePivotHealthCheck"
+
+	defaultHealthGateTimeout = 5 * time.Minute
+)
+
+// prePivotHealthCheck runs the checks of the pre-pivot health gate until they all pass. The step,
+// and so the pivot, fails once a check still fails past the gate timeout.
+func (r *ImageBasedUpgradeReconciler) prePivotHealthCheck(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	gate := ibu.Spec.PrePivotHealthGate
+	results, failures := runHealthChecks(ctx, r.prePivotHealthChecks(gate), gate)
+	ibu.Status.PrePivotHealth = results
+	if len(failures) == 0 {
+		return true, fmt.Sprintf("Passed %d health checks", len(results)), nil
+	}
+
+	timeout := defaultHealthGateTimeout
+	if gate.Timeout != nil {
+		timeout = gate.Timeout.Duration
+	}
+	step := findStep(ibu.Status.Progress, prePivotHealthCheckStep)
+	if step != nil && step.StartedAt != nil && time.Since(step.StartedAt.Time) >= timeout {
+		return false, "", &stageError{
+			reason: utils.ConditionReasons.HealthCheckFailed,
+			err:    fmt.Errorf("cluster not healthy after %s: %s", timeout, strings.Join(failures, "; ")),
+		}
+	}
+	return false, fmt.Sprintf("Waiting for the cluster to be healthy: %s", strings.Join(failures, "; ")), nil
+}
+
+// pivoted tells whether the node booted the new stateroot, that is one other than the rollback target, and
+// records when it first did. Otherwise it returns why the post-pivot steps are waiting.
+func (r *ImageBasedUpgradeReconciler) pivoted(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	seed := ibu.Status.SeedImage
+	if seed == nil || seed.Version == "" {
+		return false, "", fmt.Errorf("the seed image is not reported in the status, Prep did not complete")
+	}
+	if ibu.Spec.RollbackTarget == "" {
+		return false, "", fmt.Errorf("no rollback target is set, the stateroot booted before the upgrade is unknown")
+	}
+	deployments, err := r.OstreeClient.QueryDeployments(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to query ostree deployments: %w", err)
+	}
+	booted := ""
+	for _, d := range deployments {
+		if d.Booted {
+			booted = d.OSName
+			break
+		}
+	}
+	if booted == "" {
+		return false, "", fmt.Errorf("no booted ostree deployment found")
+	}
+	if booted == ibu.Spec.RollbackTarget {
+		return false, fmt.Sprintf("Waiting for the node to boot the new stateroot of release %s, it still runs stateroot %s", seed.Version, booted), nil
+	}
+	// Recorded once per Upgrade run
+	if !statemachine.Pivoted(ibu) {
+		now := metav1.Now()
+		ibu.Status.PivotedAt = &now
+	}
+	return true, "", nil
+}
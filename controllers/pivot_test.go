@@ -0,0 +1,180 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+// pivotedOstree is the ostree of a node that booted the new stateroot, rhcos being the rollback target
+func pivotedOstree() *ostreeclient.FakeClient {
+	return ostreeclient.NewFakeClient(
+		ostreeclient.Deployment{OSName: "rhcos_4.14.1", Booted: true},
+		ostreeclient.Deployment{OSName: "rhcos"},
+	)
+}
+
+func TestPivoted(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	r := &ImageBasedUpgradeReconciler{
+		Log:          logr.Discard(),
+		OstreeClient: ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: "rhcos", Booted: true}),
+	}
+
+	_, _, err := r.pivoted(context.TODO(), ibu)
+	assert.ErrorContains(t, err, "Prep did not complete")
+
+	ibu.Status.SeedImage = &ranv1alpha1.SeedImageStatus{Version: "4.14.1"}
+	_, _, err = r.pivoted(context.TODO(), ibu)
+	assert.ErrorContains(t, err, "no rollback target is set")
+
+	ibu.Spec.RollbackTarget = "rhcos"
+	pivoted, waiting, err := r.pivoted(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.False(t, pivoted)
+	assert.Equal(t, "Waiting for the node to boot the new stateroot of release 4.14.1, it still runs stateroot rhcos", waiting)
+
+	r.OstreeClient = pivotedOstree()
+	pivoted, _, err = r.pivoted(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, pivoted)
+	assert.NotNil(t, ibu.Status.PivotedAt)
+
+	// The first time the pivot is seen is kept, unless it predates the run
+	pivotedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+	ibu.Status.PivotedAt = &pivotedAt
+	ibu.Status.Progress = &ranv1alpha1.StageRun{StartedAt: metav1.NewTime(pivotedAt.Add(-time.Minute))}
+	_, _, _ = r.pivoted(context.TODO(), ibu)
+	assert.Equal(t, pivotedAt, *ibu.Status.PivotedAt)
+	ibu.Status.Progress.StartedAt = metav1.NewTime(pivotedAt.Add(time.Minute))
+	_, _, _ = r.pivoted(context.TODO(), ibu)
+	assert.True(t, ibu.Status.PivotedAt.After(pivotedAt.Time))
+}
+
+func TestPivotedSameRelease(t *testing.T) {
+	// The seed runs the release of the cluster, only the booted stateroot tells the pivot apart
+	fakeClient, _ := getFakeClientFromObjects(testClusterVersion("4.14.1"))
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		Spec:   ranv1alpha1.ImageBasedUpgradeSpec{RollbackTarget: "rhcos"},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{SeedImage: &ranv1alpha1.SeedImageStatus{Version: "4.14.1"}},
+	}
+	r := &ImageBasedUpgradeReconciler{
+		Client:       fakeClient,
+		Log:          logr.Discard(),
+		OstreeClient: ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: "rhcos", Booted: true}),
+	}
+
+	pivoted, waiting, err := r.pivoted(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.False(t, pivoted)
+	assert.Equal(t, "Waiting for the node to boot the new stateroot of release 4.14.1, it still runs stateroot rhcos", waiting)
+	assert.Nil(t, ibu.Status.PivotedAt)
+}
+
+func TestPrePivotHealthCheck(t *testing.T) {
+	unhealthy := healthyCluster()
+	unhealthy[1] = testNode("sno", corev1.ConditionFalse)
+
+	testcases := []struct {
+		name         string
+		objs         []client.Object
+		gate         ranv1alpha1.HealthGate
+		failingFor   time.Duration
+		validateFunc func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, done bool, message string, err error)
+	}{
+		{
+			name: "healthy cluster passes the gate",
+			objs: healthyCluster(),
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, done bool, message string, err error) {
+				assert.NoError(t, err)
+				assert.True(t, done)
+				assert.Equal(t, "Passed 5 health checks", message)
+				assert.Len(t, ibu.Status.PrePivotHealth, 5)
+			},
+		},
+		{
+			name:       "unhealthy cluster within the timeout",
+			objs:       unhealthy,
+			failingFor: time.Minute,
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, done bool, message string, err error) {
+				assert.NoError(t, err)
+				assert.False(t, done)
+				assert.Equal(t, "Waiting for the cluster to be healthy: NodeReady: nodes not ready: sno", message)
+				assert.Contains(t, ibu.Status.PrePivotHealth, ranv1alpha1.HealthCheckResult{Name: "NodeReady", Message: "nodes not ready: sno"})
+			},
+		},
+		{
+			name:       "unhealthy cluster past the timeout blocks the pivot",
+			objs:       unhealthy,
+			failingFor: 10 * time.Minute,
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, done bool, message string, err error) {
+				assert.EqualError(t, err, "cluster not healthy after 5m0s: NodeReady: nodes not ready: sno")
+				assert.Equal(t, utils.ConditionReasons.HealthCheckFailed, failureReason(err))
+			},
+		},
+		{
+			name:       "configured timeout",
+			objs:       unhealthy,
+			gate:       ranv1alpha1.HealthGate{Timeout: &metav1.Duration{Duration: 30 * time.Second}},
+			failingFor: time.Minute,
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, done bool, message string, err error) {
+				assert.EqualError(t, err, "cluster not healthy after 30s: NodeReady: nodes not ready: sno")
+			},
+		},
+		{
+			name: "skipped check",
+			objs: unhealthy,
+			gate: ranv1alpha1.HealthGate{SkippedChecks: []ranv1alpha1.HealthCheckName{ranv1alpha1.HealthCheckNames.NodeReady}},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, done bool, message string, err error) {
+				assert.NoError(t, err)
+				assert.True(t, done)
+				assert.Equal(t, "Passed 4 health checks", message)
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			since := metav1.NewTime(time.Now().Add(-tc.failingFor))
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				Spec: ranv1alpha1.ImageBasedUpgradeSpec{PrePivotHealthGate: tc.gate},
+				Status: ranv1alpha1.ImageBasedUpgradeStatus{
+					Progress: &ranv1alpha1.StageRun{
+						Steps: []ranv1alpha1.Step{{Name: prePivotHealthCheckStep, State: ranv1alpha1.StepStates.Running, StartedAt: &since}},
+					},
+				},
+			}
+			fakeClient, _ := getFakeClientFromObjects(tc.objs...)
+			r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard()}
+
+			done, message, err := r.prePivotHealthCheck(context.TODO(), ibu)
+			tc.validateFunc(t, ibu, done, message, err)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/internal/mirrors"
+	"github.com/openshift-kni/lifecycle-agent/internal/precache"
+)
+
+const (
+	precacheImagesStep = "PrecacheImages"
+
+	defaultPrecacheParallelism = 4
+	defaultPrecacheRetries     = 3
+
+	// maxPrecacheFailures caps the failed images listed in the status
+	maxPrecacheFailures = 10
+	// maxPrecacheMirrored caps the mirrored images listed in the status
+	maxPrecacheMirrored = 10
+)
+
+// precacheRetryDelay is the wait before the first retry of a failed pull
+var precacheRetryDelay = 10 * time.Second
+
+// precacheJob pulls the additional images in the background, the pulls outlive the reconciles
+type precacheJob struct {
+	images []string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status ranv1alpha1.PrecacheStatus
+}
+
+func (j *precacheJob) record(result precache.Result) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch result.Outcome {
+	case precache.Pulled:
+		j.status.Pulled++
+		if result.PulledFrom != "" && result.PulledFrom != result.Image {
+			j.status.Mirrored++
+			if len(j.status.MirroredImages) < maxPrecacheMirrored {
+				j.status.MirroredImages = append(j.status.MirroredImages, ranv1alpha1.MirroredImage{
+					Image:  result.Image,
+					Mirror: result.PulledFrom,
+				})
+			}
+		}
+	case precache.Skipped:
+		j.status.Skipped++
+	case precache.Failed:
+		j.status.Failed++
+		if len(j.status.FailedImages) < maxPrecacheFailures {
+			j.status.FailedImages = append(j.status.FailedImages, ranv1alpha1.PrecacheFailure{
+				Image:   result.Image,
+				Message: fmt.Sprintf("failed after %d attempts: %s", result.Attempts, result.Err),
+			})
+		}
+	}
+}
+
+func (j *precacheJob) snapshot() *ranv1alpha1.PrecacheStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.FailedImages = append([]ranv1alpha1.PrecacheFailure(nil), j.status.FailedImages...)
+	status.MirroredImages = append([]ranv1alpha1.MirroredImage(nil), j.status.MirroredImages...)
+	return &status
+}
+
+// precacheImages pulls the images listed in the AdditionalImages ConfigMap and reports their
+// progress until all of them are on the node. A restarted operator starts over, skipping the
+// images already pulled.
+func (r *ImageBasedUpgradeReconciler) precacheImages(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	images, err := r.additionalImages(ctx, ibu)
+	if err != nil {
+		return false, "", err
+	}
+	if len(images) == 0 {
+		r.stopPrecache()
+		ibu.Status.Precache = nil
+		return true, "No additional images to precache", nil
+	}
+
+	job := r.precache
+	if job == nil || !equalStrings(job.images, images) {
+		mirrorSet, err := r.mirrorSet(ctx)
+		if err != nil {
+			return false, "", err
+		}
+		r.stopPrecache()
+		job = r.startPrecache(images, ibu.Spec.Precache, mirrorSet)
+	}
+	ibu.Status.Precache = job.snapshot()
+	status := ibu.Status.Precache
+
+	select {
+	case <-job.done:
+		r.precache = nil
+	default:
+		return false, fmt.Sprintf("Precached %d of %d images", status.Pulled+status.Skipped+status.Failed, status.Total), nil
+	}
+
+	if status.Failed > 0 {
+		var failed []string
+		for _, failure := range status.FailedImages {
+			failed = append(failed, failure.Image)
+		}
+		return false, "", fmt.Errorf("failed to precache %d of %d images: %s", status.Failed, status.Total, strings.Join(failed, ", "))
+	}
+	return true, fmt.Sprintf("Precached %d images, %d were already present", status.Total, status.Skipped), nil
+}
+
+// verifyPrecachedImages checks that the images precached before a restart are still on the node
+func (r *ImageBasedUpgradeReconciler) verifyPrecachedImages(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	images, err := r.additionalImages(ctx, ibu)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		exists, err := r.ImagePuller.Exists(ctx, image)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("image %s is no longer on the node", image)
+		}
+	}
+	return nil
+}
+
+// additionalImages returns the images listed in the AdditionalImages ConfigMap, if one is set
+func (r *ImageBasedUpgradeReconciler) additionalImages(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) ([]string, error) {
+	ref := ibu.Spec.AdditionalImages
+	if ref.Name == "" {
+		return nil, nil
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = ibu.Namespace
+	}
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, cm); err != nil {
+		return nil, fmt.Errorf("failed to get the AdditionalImages ConfigMap %s/%s: %w", namespace, ref.Name, err)
+	}
+	return precache.ParseImageList(cm.Data), nil
+}
+
+func (r *ImageBasedUpgradeReconciler) startPrecache(images []string, config ranv1alpha1.PrecacheConfig, mirrorSet *mirrors.Set) *precacheJob {
+	parallelism, retries := defaultPrecacheParallelism, defaultPrecacheRetries
+	if config.Parallelism > 0 {
+		parallelism = int(config.Parallelism)
+	}
+	if config.Retries != nil {
+		retries = int(*config.Retries)
+	}
+	r.Log.Info("Starting to precache images", "images", len(images), "parallelism", parallelism, "retries", retries)
+
+	// Not bound to the reconcile context, the job is stopped by stopPrecache
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &precacheJob{
+		images: images,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: ranv1alpha1.PrecacheStatus{Total: len(images)},
+	}
+	go func() {
+		defer close(job.done)
+		precache.Run(ctx, r.ImagePuller, images, precache.Config{
+			Parallelism: parallelism,
+			Retries:     retries,
+			RetryDelay:  precacheRetryDelay,
+			Resolve:     mirrorSet.Resolve,
+		}, job.record)
+	}()
+	r.precache = job
+	return job
+}
+
+// stopPrecache cancels the precache job, if one is running, and waits for it to end
+func (r *ImageBasedUpgradeReconciler) stopPrecache() {
+	if r.precache == nil {
+		return
+	}
+	r.precache.cancel()
+	<-r.precache.done
+	r.precache = nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
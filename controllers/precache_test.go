@@ -0,0 +1,229 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// fakeImagePuller has the present images and fails to pull the broken ones
+type fakeImagePuller struct {
+	mu      sync.Mutex
+	present map[string]bool
+	broken  map[string]bool
+	sizes   map[string]int64
+	block   chan struct{}
+	pulled  []string
+	tagged  []string
+}
+
+func (p *fakeImagePuller) Exists(ctx context.Context, image string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.present[image], nil
+}
+
+func (p *fakeImagePuller) Pull(ctx context.Context, image string) error {
+	if p.block != nil {
+		select {
+		case <-p.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.broken[image] {
+		return fmt.Errorf("manifest unknown")
+	}
+	p.pulled = append(p.pulled, image)
+	return nil
+}
+
+func (p *fakeImagePuller) Tag(ctx context.Context, source, target string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tagged = append(p.tagged, source+" "+target)
+	return nil
+}
+
+func (p *fakeImagePuller) Size(ctx context.Context, image string) (int64, error) {
+	if p.broken[image] {
+		return 0, fmt.Errorf("manifest unknown")
+	}
+	return p.sizes[image], nil
+}
+
+func TestPrecacheImages(t *testing.T) {
+	roomyFilesystems(t)
+	defer func(old time.Duration) { precacheRetryDelay = old }(precacheRetryDelay)
+	precacheRetryDelay = time.Millisecond
+
+	images := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ran-images", Namespace: "default"},
+		Data:       map[string]string{"images": "quay.io/ran/du:1.0\nquay.io/ran/cu:1.0\n# comment\nquay.io/ran/ru:1.0\n"},
+	}
+	testcases := []struct {
+		name     string
+		ref      ranv1alpha1.ConfigMapRef
+		mirrors  []client.Object
+		puller   *fakeImagePuller
+		validate func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition)
+	}{
+		{
+			name:   "pulls the missing images",
+			ref:    ranv1alpha1.ConfigMapRef{Name: "ran-images", Namespace: "default"},
+			puller: &fakeImagePuller{present: map[string]bool{"quay.io/ran/cu:1.0": true}},
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionTrue, completed.Status)
+				assert.Equal(t, &ranv1alpha1.PrecacheStatus{Total: 3, Pulled: 2, Skipped: 1}, ibu.Status.Precache)
+			},
+		},
+		{
+			name:   "failed pulls fail Prep",
+			ref:    ranv1alpha1.ConfigMapRef{Name: "ran-images", Namespace: "default"},
+			puller: &fakeImagePuller{broken: map[string]bool{"quay.io/ran/ru:1.0": true}},
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionFalse, completed.Status)
+				assert.Contains(t, completed.Message, "failed to precache 1 of 3 images: quay.io/ran/ru:1.0")
+				assert.Equal(t, 2, ibu.Status.Precache.Pulled)
+				assert.Equal(t, []ranv1alpha1.PrecacheFailure{{
+					Image:   "quay.io/ran/ru:1.0",
+					Message: "failed after 2 attempts: manifest unknown",
+				}}, ibu.Status.Precache.FailedImages)
+			},
+		},
+		{
+			name:    "pulls through the mirrors",
+			ref:     ranv1alpha1.ConfigMapRef{Name: "ran-images", Namespace: "default"},
+			mirrors: []client.Object{testTagMirrorSet("quay.io/ran/du", "mirror.example.com/du")},
+			puller:  &fakeImagePuller{broken: map[string]bool{"quay.io/ran/du:1.0": true}},
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionTrue, completed.Status)
+				assert.Equal(t, &ranv1alpha1.PrecacheStatus{
+					Total:          3,
+					Pulled:         3,
+					Mirrored:       1,
+					MirroredImages: []ranv1alpha1.MirroredImage{{Image: "quay.io/ran/du:1.0", Mirror: "mirror.example.com/du:1.0"}},
+				}, ibu.Status.Precache)
+			},
+		},
+		{
+			name:   "missing ConfigMap",
+			ref:    ranv1alpha1.ConfigMapRef{Name: "missing"},
+			puller: &fakeImagePuller{},
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionFalse, completed.Status)
+				assert.Contains(t, completed.Message, "failed to get the AdditionalImages ConfigMap openshift-lifecycle-agent/missing")
+			},
+		},
+		{
+			name:   "no additional images",
+			puller: &fakeImagePuller{},
+			validate: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade, completed *metav1.Condition) {
+				assert.Equal(t, metav1.ConditionTrue, completed.Status)
+				assert.Nil(t, ibu.Status.Precache)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			retries := int32(1)
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+				Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+					Stage:            ranv1alpha1.Stages.Prep,
+					SeedImageRef:     testSeedImageRef,
+					AdditionalImages: tc.ref,
+					Precache:         ranv1alpha1.PrecacheConfig{Parallelism: 2, Retries: &retries},
+				},
+			}
+			fakeClient, _ := getFakeClientFromObjects(append([]client.Object{ibu, images}, tc.mirrors...)...)
+			r := &ImageBasedUpgradeReconciler{
+				Client:          fakeClient,
+				APIReader:       fakeClient,
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Recorder:        record.NewFakeRecorder(100),
+				SeedImageClient: &fakeSeedImageClient{},
+				ImagePuller:     tc.puller,
+			}
+			for i := 0; i < 100; i++ {
+				_, err := r.handlePrep(context.TODO(), ibu)
+				assert.NoError(t, err)
+				if meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted)) != nil {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			tc.validate(t, ibu, meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted)))
+			assert.Nil(t, r.precache, "the finished job is forgotten")
+			if tc.mirrors != nil {
+				assert.Equal(t, []string{"mirror.example.com/du:1.0 quay.io/ran/du:1.0"}, tc.puller.tagged)
+			}
+		})
+	}
+}
+
+func TestPrecacheImagesInProgress(t *testing.T) {
+	images := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ran-images", Namespace: lcaNs},
+		Data:       map[string]string{"images": "quay.io/ran/du:1.0\nquay.io/ran/cu:1.0"},
+	}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:            ranv1alpha1.Stages.Prep,
+			AdditionalImages: ranv1alpha1.ConfigMapRef{Name: "ran-images"},
+		},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu, images)
+	puller := &fakeImagePuller{block: make(chan struct{})}
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), ImagePuller: puller}
+
+	done, message, err := r.precacheImages(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "Precached 0 of 2 images", message)
+	assert.Equal(t, 2, ibu.Status.Precache.Total)
+	job := r.precache
+
+	// The same job keeps running across reconciles
+	_, _, _ = r.precacheImages(context.TODO(), ibu)
+	assert.Same(t, job, r.precache)
+
+	// Aborting cancels the pulls
+	r.stopPrecache()
+	assert.Nil(t, r.precache)
+	assert.Equal(t, 2, job.snapshot().Failed)
+	assert.Empty(t, puller.pulled)
+}
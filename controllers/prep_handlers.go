@@ -20,26 +20,19 @@ import (
 	"context"
 
 	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
-	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 func (r *ImageBasedUpgradeReconciler) handlePrep(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (ctrl.Result, error) {
-
-	// TODO actual steps
-	// If completed, update conditions and return doNotRequeue
-	utils.SetStatusCondition(&ibu.Status.Conditions,
-		utils.GetCompletedConditionType(ranv1alpha1.Stages.Prep),
-		utils.ConditionReasons.Completed,
-		metav1.ConditionTrue,
-		"Prep completed",
-		ibu.Generation)
-	utils.SetStatusCondition(&ibu.Status.Conditions,
-		utils.GetInProgressConditionType(ranv1alpha1.Stages.Prep),
-		utils.ConditionReasons.Completed,
-		metav1.ConditionFalse,
-		"Prep completed",
-		ibu.Generation)
-	return doNotRequeue(), nil
+	// TODO remaining steps
+	return r.runStage(ctx, ibu, ranv1alpha1.Stages.Prep, []stageStep{
+		{name: resolvePullSecretStep, run: r.resolvePullSecret, verify: r.verifyPullSecret},
+		{name: checkDiskSpaceStep, run: r.checkDiskSpace},
+		{name: pullSeedImageStep, run: r.pullSeedImage, verify: r.verifySeedImage},
+		{name: verifySeedSignatureStep, run: r.verifySeedSignature, verify: r.verifySeedSignatureResult},
+		{name: inspectSeedImageStep, run: r.inspectSeedImage},
+		{name: checkSeedCompatibilityStep, run: r.checkSeedCompatibility},
+		{name: validateExtraManifestsStep, run: r.validateExtraManifests},
+		{name: precacheImagesStep, run: r.precacheImages, verify: r.verifyPrecachedImages},
+	})
 }
@@ -0,0 +1,256 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// maxStageHistory is the number of past stage runs kept in the status
+const maxStageHistory = 10
+
+// Names of the runs of the Idle stage handler, the other runs are named after their stage
+const (
+	abortRun    = "Abort"
+	finalizeRun = "Finalize"
+)
+
+// stageStep is one step of a stage handler. run returns done=false while the step needs
+// more reconciles to finish, and an error when the step and so the whole run failed.
+// verify, if set, checks that the results of a step that succeeded before a restart are still
+// there, the step runs again when it fails. Steps without verify are trusted once succeeded.
+type stageStep struct {
+	name   string
+	run    func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (done bool, message string, err error)
+	verify func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error
+}
+
+// stageError fails a stage with a more specific condition reason than Failed
+type stageError struct {
+	reason utils.ConditionReason
+	err    error
+}
+
+func (e *stageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// failureReason returns the condition reason for a stage failing with err
+func failureReason(err error) utils.ConditionReason {
+	var stageErr *stageError
+	if errors.As(err, &stageErr) {
+		return stageErr.reason
+	}
+	return utils.ConditionReasons.Failed
+}
+
+// runStage runs the steps of the Prep, Upgrade or Rollback stage and sets the stage conditions once they end
+func (r *ImageBasedUpgradeReconciler) runStage(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, stage ranv1alpha1.ImageBasedUpgradeStage, steps []stageStep) (ctrl.Result, error) {
+	done, err := r.runSteps(ctx, ibu, string(stage), steps)
+	if err != nil {
+		r.Log.Error(err, "Stage failed", "stage", stage)
+		message := fmt.Sprintf("%s failed: %s", stage, err)
+		reason := failureReason(err)
+		r.recordEvent(ibu, corev1.EventTypeWarning, reason, message)
+		utils.SetStatusCondition(&ibu.Status.Conditions,
+			utils.GetCompletedConditionType(stage),
+			reason,
+			metav1.ConditionFalse,
+			message,
+			ibu.Generation)
+		utils.SetStatusCondition(&ibu.Status.Conditions,
+			utils.GetInProgressConditionType(stage),
+			reason,
+			metav1.ConditionFalse,
+			message,
+			ibu.Generation)
+		if stage == ranv1alpha1.Stages.Upgrade {
+			trigger := ranv1alpha1.AutoRollbackTriggers.UpgradeFailed
+			var healthErr *healthCheckError
+			if errors.As(err, &healthErr) {
+				trigger = ranv1alpha1.AutoRollbackTriggers.HealthCheckFailed
+			}
+			return doNotRequeue(), r.autoRollback(ctx, ibu, trigger, message)
+		}
+		return doNotRequeue(), nil
+	}
+	if !done {
+		return requeueWithShortInterval(), nil
+	}
+
+	message := fmt.Sprintf("%s completed", stage)
+	r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.Completed, message)
+	utils.SetStatusCondition(&ibu.Status.Conditions,
+		utils.GetCompletedConditionType(stage),
+		utils.ConditionReasons.Completed,
+		metav1.ConditionTrue,
+		message,
+		ibu.Generation)
+	utils.SetStatusCondition(&ibu.Status.Conditions,
+		utils.GetInProgressConditionType(stage),
+		utils.ConditionReasons.Completed,
+		metav1.ConditionFalse,
+		message,
+		ibu.Generation)
+	return doNotRequeue(), nil
+}
+
+// runSteps runs the steps of the named run in order, resuming after the steps that already
+// succeeded, and records their progress in the status and in a checkpoint on the host.
+// It returns true once every step succeeded.
+func (r *ImageBasedUpgradeReconciler) runSteps(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, name string, steps []stageStep) (bool, error) {
+	run := ibu.Status.Progress
+	if run == nil || run.Name != name || run.Outcome != ranv1alpha1.StageRunOutcomes.InProgress {
+		if run != nil && run.Outcome == ranv1alpha1.StageRunOutcomes.InProgress {
+			// The interrupted run does not resume, e.g. an aborted Prep starts over
+			r.removeCheckpoint(run.Name)
+		}
+		run = startStageRun(ibu, name, steps)
+		r.restoreCheckpoint(ibu, run)
+	}
+	defer updateDurations(run)
+
+	for _, step := range steps {
+		progress := findStep(run, step.name)
+		if progress == nil {
+			// Steps added after the run started, e.g. by an operator upgrade
+			run.Steps = append(run.Steps, ranv1alpha1.Step{Name: step.name, State: ranv1alpha1.StepStates.Pending})
+			progress = &run.Steps[len(run.Steps)-1]
+		}
+		if progress.State == ranv1alpha1.StepStates.Succeeded {
+			if r.verifyStep(ctx, ibu, run, step) {
+				continue
+			}
+			progress.State = ranv1alpha1.StepStates.Pending
+			progress.CompletedAt = nil
+		}
+		if progress.State != ranv1alpha1.StepStates.Running {
+			now := metav1.Now()
+			progress.State = ranv1alpha1.StepStates.Running
+			progress.StartedAt = &now
+		}
+
+		done, message, err := step.run(ctx, ibu)
+		progress.Message = message
+		if err != nil {
+			now := metav1.Now()
+			progress.State = ranv1alpha1.StepStates.Failed
+			progress.CompletedAt = &now
+			progress.Message = err.Error()
+			finishStageRun(ibu, ranv1alpha1.StageRunOutcomes.Failed, fmt.Sprintf("step %s failed: %s", step.name, err))
+			r.removeCheckpoint(name)
+			// The caller reports the failure with its specific reason
+			return false, err
+		}
+		if !done {
+			return false, nil
+		}
+		now := metav1.Now()
+		progress.State = ranv1alpha1.StepStates.Succeeded
+		progress.CompletedAt = &now
+		r.markVerified(run, step.name)
+		r.saveCheckpoint(ibu, run)
+		r.recordEvent(ibu, corev1.EventTypeNormal, utils.ConditionReasons.Completed,
+			fmt.Sprintf("%s step %s completed", name, step.name))
+	}
+
+	finishStageRun(ibu, ranv1alpha1.StageRunOutcomes.Succeeded, fmt.Sprintf("%s completed", name))
+	r.removeCheckpoint(name)
+	return true, nil
+}
+
+// startStageRun moves the previous run to the history and starts reporting a new one with all its steps pending
+func startStageRun(ibu *ranv1alpha1.ImageBasedUpgrade, name string, steps []stageStep) *ranv1alpha1.StageRun {
+	if previous := ibu.Status.Progress; previous != nil {
+		if previous.Outcome == ranv1alpha1.StageRunOutcomes.InProgress {
+			finishStageRun(ibu, ranv1alpha1.StageRunOutcomes.Interrupted, fmt.Sprintf("Interrupted by %s", name))
+		}
+		ibu.Status.History = append(ibu.Status.History, *previous)
+		if len(ibu.Status.History) > maxStageHistory {
+			ibu.Status.History = ibu.Status.History[len(ibu.Status.History)-maxStageHistory:]
+		}
+	}
+
+	run := &ranv1alpha1.StageRun{
+		Name:      name,
+		Outcome:   ranv1alpha1.StageRunOutcomes.InProgress,
+		StartedAt: metav1.Now(),
+	}
+	for _, step := range steps {
+		run.Steps = append(run.Steps, ranv1alpha1.Step{Name: step.name, State: ranv1alpha1.StepStates.Pending})
+	}
+	ibu.Status.Progress = run
+	ibu.Status.StartedAt = run.StartedAt
+	ibu.Status.CompletedAt = metav1.Time{}
+	return run
+}
+
+// finishStageRun ends the run in progress with the given outcome
+func finishStageRun(ibu *ranv1alpha1.ImageBasedUpgrade, outcome ranv1alpha1.StageRunOutcome, message string) {
+	run := ibu.Status.Progress
+	if run == nil || run.Outcome != ranv1alpha1.StageRunOutcomes.InProgress {
+		return
+	}
+	now := metav1.Now()
+	run.Outcome = outcome
+	run.CompletedAt = &now
+	run.Message = message
+	updateDurations(run)
+	ibu.Status.CompletedAt = now
+}
+
+// updateDurations refreshes the durations of the run and its steps, so a running step shows how long it has been running
+func updateDurations(run *ranv1alpha1.StageRun) {
+	run.Duration = duration(&run.StartedAt, run.CompletedAt)
+	for i := range run.Steps {
+		run.Steps[i].Duration = duration(run.Steps[i].StartedAt, run.Steps[i].CompletedAt)
+	}
+}
+
+func duration(start, end *metav1.Time) *metav1.Duration {
+	if start == nil || start.IsZero() {
+		return nil
+	}
+	stop := metav1.Now()
+	if end != nil {
+		stop = *end
+	}
+	return &metav1.Duration{Duration: stop.Sub(start.Time).Round(time.Second)}
+}
+
+func findStep(run *ranv1alpha1.StageRun, name string) *ranv1alpha1.Step {
+	for i := range run.Steps {
+		if run.Steps[i].Name == name {
+			return &run.Steps[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+)
+
+func TestRunSteps(t *testing.T) {
+	calls := map[string]int{}
+	ready := false
+	step := func(name string, done func() bool, err error) stageStep {
+		return stageStep{name: name, run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+			calls[name]++
+			return done(), fmt.Sprintf("%s called", name), err
+		}}
+	}
+	steps := []stageStep{
+		step("first", func() bool { return true }, nil),
+		step("second", func() bool { return ready }, nil),
+	}
+
+	r := &ImageBasedUpgradeReconciler{Log: logr.Discard(), Recorder: record.NewFakeRecorder(100)}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	done, err := r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	run := ibu.Status.Progress
+	assert.Equal(t, ranv1alpha1.StageRunOutcomes.InProgress, run.Outcome)
+	assert.False(t, ibu.Status.StartedAt.IsZero())
+	assert.Equal(t, ranv1alpha1.StepStates.Succeeded, run.Steps[0].State)
+	assert.NotNil(t, run.Steps[0].Duration)
+	assert.Equal(t, ranv1alpha1.StepStates.Running, run.Steps[1].State)
+	assert.Equal(t, "second called", run.Steps[1].Message)
+	assert.Nil(t, run.Steps[1].CompletedAt)
+
+	// The next reconcile resumes at the running step
+	ready = true
+	done, err = r.runSteps(context.TODO(), ibu, "Prep", steps)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 1, calls["first"])
+	assert.Equal(t, 2, calls["second"])
+	assert.Equal(t, ranv1alpha1.StageRunOutcomes.Succeeded, ibu.Status.Progress.Outcome)
+	assert.NotNil(t, ibu.Status.Progress.CompletedAt)
+	assert.False(t, ibu.Status.CompletedAt.IsZero())
+	assert.Empty(t, ibu.Status.History)
+
+	// A new run moves the previous one to the history
+	failing := []stageStep{step("broken", func() bool { return false }, fmt.Errorf("boom"))}
+	done, err = r.runSteps(context.TODO(), ibu, "Upgrade", failing)
+	assert.Error(t, err)
+	assert.False(t, done)
+	assert.Equal(t, ranv1alpha1.StageRunOutcomes.Failed, ibu.Status.Progress.Outcome)
+	assert.Equal(t, ranv1alpha1.StepStates.Failed, ibu.Status.Progress.Steps[0].State)
+	assert.Equal(t, "boom", ibu.Status.Progress.Steps[0].Message)
+	assert.Len(t, ibu.Status.History, 1)
+	assert.Equal(t, "Prep", ibu.Status.History[0].Name)
+}
+
+func TestStageRunInterruptedAndHistoryRetention(t *testing.T) {
+	r := &ImageBasedUpgradeReconciler{Log: logr.Discard(), Recorder: record.NewFakeRecorder(100)}
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	pending := []stageStep{{name: "wait", run: func(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+		return false, "", nil
+	}}}
+	_, _ = r.runSteps(context.TODO(), ibu, "Prep", pending)
+	_, _ = r.runSteps(context.TODO(), ibu, "Abort", nil)
+	assert.Equal(t, ranv1alpha1.StageRunOutcomes.Interrupted, ibu.Status.History[0].Outcome)
+	assert.Equal(t, ranv1alpha1.StageRunOutcomes.Succeeded, ibu.Status.Progress.Outcome)
+
+	for i := 0; i < 2*maxStageHistory; i++ {
+		_, _ = r.runSteps(context.TODO(), ibu, "Prep", nil)
+	}
+	assert.Len(t, ibu.Status.History, maxStageHistory)
+}
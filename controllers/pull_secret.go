@@ -0,0 +1,149 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const (
+	resolvePullSecretStep = "ResolvePullSecret"
+	removePullSecretStep  = "RemovePullSecret"
+)
+
+// globalPullSecret is the pull secret of the cluster, the one of the release payloads
+var globalPullSecret = types.NamespacedName{Namespace: "openshift-config", Name: "pull-secret"}
+
+// resolvePullSecret writes on the host the auth file the seed image and the AdditionalImages are pulled with:
+// the global pull secret with the credentials of spec.seedImageRef.pullSecretRef merged over it
+func (r *ImageBasedUpgradeReconciler) resolvePullSecret(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	global, err := r.readPullSecret(ctx, globalPullSecret)
+	if err != nil && !isAbsent(err) {
+		return false, "", err
+	}
+	merged := global
+
+	var registries []string
+	if ref := ibu.Spec.SeedImageRef.PullSecretRef; ref != nil {
+		spec, err := r.readPullSecret(ctx, types.NamespacedName{Namespace: ibu.Namespace, Name: ref.Name})
+		if err != nil {
+			return false, "", err
+		}
+		if merged, err = mergePullSecrets(global, spec); err != nil {
+			return false, "", fmt.Errorf("failed to merge pull secret %s/%s: %w", ibu.Namespace, ref.Name, err)
+		}
+		if registries, err = pullSecretRegistries(spec); err != nil {
+			return false, "", fmt.Errorf("invalid pull secret %s/%s: %w", ibu.Namespace, ref.Name, err)
+		}
+	}
+	if merged == nil {
+		merged = []byte(`{"auths":{}}`)
+	}
+
+	path := filepath.Join(utils.HostPath, utils.PullSecretFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return false, "", fmt.Errorf("failed to write pull secret: %w", err)
+	}
+	if err := os.WriteFile(path, merged, 0o600); err != nil {
+		return false, "", fmt.Errorf("failed to write pull secret: %w", err)
+	}
+	if len(registries) == 0 {
+		return true, "Using the cluster pull secret", nil
+	}
+	return true, fmt.Sprintf("Using the cluster pull secret with the credentials of %s for %s",
+		ibu.Spec.SeedImageRef.PullSecretRef.Name, sortedJoin(registries)), nil
+}
+
+// verifyPullSecret checks the auth file written before a restart is still on the host
+func (r *ImageBasedUpgradeReconciler) verifyPullSecret(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	_, err := os.Stat(filepath.Join(utils.HostPath, utils.PullSecretFile))
+	return err
+}
+
+// removePullSecret deletes the auth file from the host once no more image is pulled for the upgrade
+func (r *ImageBasedUpgradeReconciler) removePullSecret(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	if err := os.Remove(filepath.Join(utils.HostPath, utils.PullSecretFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, "", fmt.Errorf("failed to remove pull secret: %w", err)
+	}
+	return true, "Removed the pull secret from the host", nil
+}
+
+func (r *ImageBasedUpgradeReconciler) readPullSecret(ctx context.Context, key types.NamespacedName) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := r.APIReader.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get pull secret %s: %w", key, err)
+	}
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return nil, fmt.Errorf("pull secret %s has no %s", key, corev1.DockerConfigJsonKey)
+	}
+	return data, nil
+}
+
+// mergePullSecrets returns the auths of base with the ones of override added, override wins for the
+// registries in both. The other fields of the entries, such as email, are kept.
+func mergePullSecrets(base, override []byte) ([]byte, error) {
+	merged := map[string]json.RawMessage{}
+	for _, data := range [][]byte{base, override} {
+		if data == nil {
+			continue
+		}
+		auths, err := parseAuths(data)
+		if err != nil {
+			return nil, err
+		}
+		for registry, auth := range auths {
+			merged[registry] = auth
+		}
+	}
+	return json.Marshal(map[string]map[string]json.RawMessage{"auths": merged})
+}
+
+func pullSecretRegistries(data []byte) ([]string, error) {
+	auths, err := parseAuths(data)
+	if err != nil {
+		return nil, err
+	}
+	registries := make([]string, 0, len(auths))
+	for registry := range auths {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+	return registries, nil
+}
+
+func parseAuths(data []byte) (map[string]json.RawMessage, error) {
+	config := struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse pull secret: %w", err)
+	}
+	return config.Auths, nil
+}
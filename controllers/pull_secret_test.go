@@ -0,0 +1,139 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+func pullSecret(namespace, name, data string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(data)},
+	}
+}
+
+func TestMergePullSecrets(t *testing.T) {
+	merged, err := mergePullSecrets(
+		[]byte(`{"auths": {"quay.io": {"auth": "Z2xvYmFs", "email": "ops@example.com"}, "registry.redhat.io": {"auth": "cmVkaGF0"}}}`),
+		[]byte(`{"auths": {"quay.io": {"auth": "c2VlZA=="}, "seed.example.com:5000": {"auth": "cHJpdmF0ZQ=="}}}`),
+	)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"auths": {
+		"quay.io": {"auth": "c2VlZA=="},
+		"registry.redhat.io": {"auth": "cmVkaGF0"},
+		"seed.example.com:5000": {"auth": "cHJpdmF0ZQ=="}
+	}}`, string(merged))
+
+	_, err = mergePullSecrets([]byte(`{"auths": {}}`), []byte(`not json`))
+	assert.ErrorContains(t, err, "failed to parse pull secret")
+}
+
+func TestResolvePullSecret(t *testing.T) {
+	global := pullSecret("openshift-config", "pull-secret", `{"auths": {"quay.io": {"auth": "Z2xvYmFs"}}}`)
+	seed := pullSecret(lcaNs, "seed-pull-secret", `{"auths": {"seed.example.com:5000": {"auth": "cHJpdmF0ZQ=="}}}`)
+	broken := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: lcaNs}, Data: map[string][]byte{"token": []byte("x")}}
+
+	testcases := []struct {
+		name    string
+		objects []client.Object
+		ref     *ranv1alpha1.PullSecretRef
+		auths   string
+		message string
+		fail    string
+	}{
+		{
+			name:    "global pull secret only",
+			objects: []client.Object{global},
+			auths:   `{"auths": {"quay.io": {"auth": "Z2xvYmFs"}}}`,
+			message: "Using the cluster pull secret",
+		},
+		{
+			name:    "spec pull secret merged",
+			objects: []client.Object{global, seed},
+			ref:     &ranv1alpha1.PullSecretRef{Name: "seed-pull-secret"},
+			auths:   `{"auths": {"quay.io": {"auth": "Z2xvYmFs"}, "seed.example.com:5000": {"auth": "cHJpdmF0ZQ=="}}}`,
+			message: "Using the cluster pull secret with the credentials of seed-pull-secret for seed.example.com:5000",
+		},
+		{
+			name:    "no global pull secret",
+			objects: []client.Object{seed},
+			ref:     &ranv1alpha1.PullSecretRef{Name: "seed-pull-secret"},
+			auths:   `{"auths": {"seed.example.com:5000": {"auth": "cHJpdmF0ZQ=="}}}`,
+		},
+		{
+			name:    "missing spec pull secret",
+			objects: []client.Object{global},
+			ref:     &ranv1alpha1.PullSecretRef{Name: "seed-pull-secret"},
+			fail:    "failed to get pull secret openshift-lifecycle-agent/seed-pull-secret",
+		},
+		{
+			name:    "not a pull secret",
+			objects: []client.Object{global, broken},
+			ref:     &ranv1alpha1.PullSecretRef{Name: "broken"},
+			fail:    "pull secret openshift-lifecycle-agent/broken has no .dockerconfigjson",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+				Spec:       ranv1alpha1.ImageBasedUpgradeSpec{SeedImageRef: ranv1alpha1.SeedImageRef{Image: testSeedImage, PullSecretRef: tc.ref}},
+			}
+			fakeClient, _ := getFakeClientFromObjects(append(tc.objects, ibu)...)
+			r := &ImageBasedUpgradeReconciler{Client: fakeClient, APIReader: fakeClient, Log: logr.Discard()}
+			path := filepath.Join(utils.HostPath, utils.PullSecretFile)
+			_ = os.Remove(path)
+
+			done, message, err := r.resolvePullSecret(context.TODO(), ibu)
+			if tc.fail != "" {
+				assert.ErrorContains(t, err, tc.fail)
+				assert.NoFileExists(t, path)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, done)
+			if tc.message != "" {
+				assert.Equal(t, tc.message, message)
+			}
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.auths, string(data))
+			info, _ := os.Stat(path)
+			assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+			assert.NoError(t, r.verifyPullSecret(context.TODO(), ibu))
+
+			_, _, err = r.removePullSecret(context.TODO(), ibu)
+			assert.NoError(t, err)
+			assert.NoFileExists(t, path)
+			assert.Error(t, r.verifyPullSecret(context.TODO(), ibu))
+		})
+	}
+}
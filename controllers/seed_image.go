@@ -0,0 +1,210 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
+)
+
+const (
+	pullSeedImageStep    = "PullSeedImage"
+	inspectSeedImageStep = "InspectSeedImage"
+)
+
+// seedPullWait is how long a reconcile waits for the seed pull before requeueing, an image already
+// on the node is done at once
+var seedPullWait = 5 * time.Second
+
+// seedPullJob pulls the seed image in the background, a pull of several GB must not block the reconciles
+type seedPullJob struct {
+	image  string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// Set once done
+	pulled *seedimage.Image
+	err    error
+}
+
+// pullSeedImage pulls the seed image, through the cluster mirrors if any, and records its digest in the
+// status. Nothing is read from the image before its signature is verified.
+func (r *ImageBasedUpgradeReconciler) pullSeedImage(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	ref := ibu.Spec.SeedImageRef
+	if ref.Image == "" {
+		return false, "", fmt.Errorf("spec.seedImageRef.image is not set")
+	}
+
+	job := r.seedPull
+	if job == nil || job.image != ref.Image {
+		mirrorSet, err := r.mirrorSet(ctx)
+		if err != nil {
+			return false, "", err
+		}
+		r.stopSeedPull()
+		job = r.startSeedPull(ref.Image, mirrorSet.Resolve(ref.Image))
+	}
+	select {
+	case <-job.done:
+		r.seedPull = nil
+	case <-time.After(seedPullWait):
+		return false, fmt.Sprintf("Pulling seed image %s", ref.Image), nil
+	}
+	if job.err != nil {
+		return false, "", job.err
+	}
+
+	image := job.pulled
+	ibu.Status.SeedImage = &ranv1alpha1.SeedImageStatus{
+		Image:      ref.Image,
+		Digest:     image.Digest,
+		PulledFrom: image.Reference,
+	}
+	if image.Reference != ref.Image {
+		return true, fmt.Sprintf("Pulled seed image %s (%s) from mirror %s", ref.Image, image.Digest, image.Reference), nil
+	}
+	return true, fmt.Sprintf("Pulled seed image %s (%s)", ref.Image, image.Digest), nil
+}
+
+func (r *ImageBasedUpgradeReconciler) startSeedPull(image string, candidates []string) *seedPullJob {
+	r.Log.Info("Starting to pull the seed image", "image", image, "candidates", candidates)
+
+	// Not bound to the reconcile context, the job is stopped by stopSeedPull
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &seedPullJob{image: image, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(job.done)
+		job.pulled, job.err = r.pullFirstCandidate(ctx, image, candidates)
+	}()
+	r.seedPull = job
+	return job
+}
+
+// pullFirstCandidate pulls the seed image from the first of its mirrors, or itself, that has it
+func (r *ImageBasedUpgradeReconciler) pullFirstCandidate(ctx context.Context, image string, candidates []string) (*seedimage.Image, error) {
+	var errs []string
+	var err error
+	for _, candidate := range candidates {
+		var pulled *seedimage.Image
+		if pulled, err = r.SeedImageClient.Pull(ctx, candidate); err == nil {
+			return pulled, nil
+		}
+		r.Log.Info("Failed to pull the seed image", "reference", candidate, "error", err.Error())
+		errs = append(errs, fmt.Sprintf("%s: %s", candidate, err))
+	}
+	if len(candidates) == 1 {
+		return nil, err
+	}
+	return nil, fmt.Errorf("failed to pull seed image %s from any of its mirrors: %s", image, strings.Join(errs, "; "))
+}
+
+// stopSeedPull cancels the seed pull, if one is running, and waits for it to end
+func (r *ImageBasedUpgradeReconciler) stopSeedPull() {
+	if r.seedPull == nil {
+		return
+	}
+	r.seedPull.cancel()
+	<-r.seedPull.done
+	r.seedPull = nil
+}
+
+// inspectSeedImage reads the seed metadata of the pulled image, once its signature is verified, records it
+// in the status and checks the seed is of the requested version
+func (r *ImageBasedUpgradeReconciler) inspectSeedImage(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	ref := ibu.Spec.SeedImageRef
+	status := ibu.Status.SeedImage
+	if status == nil || status.Image != ref.Image {
+		return false, "", fmt.Errorf("seed image %s not reported in the status", ref.Image)
+	}
+	metadata, err := r.SeedImageClient.Metadata(ctx, seedImageReference(ibu))
+	if err != nil {
+		return false, "", err
+	}
+	setSeedMetadata(status, metadata)
+
+	if ref.Version != "" && ref.Version != metadata.Version {
+		return false, "", &stageError{
+			reason: utils.ConditionReasons.SeedMismatch,
+			err: fmt.Errorf("seed image %s is version %s, spec.seedImageRef.version is %s",
+				ref.Image, metadata.Version, ref.Version),
+		}
+	}
+	return true, fmt.Sprintf("Seed image %s is version %s", ref.Image, metadata.Version), nil
+}
+
+// seedImageReference returns the reference of the pulled seed image, from its mirror or the image itself,
+// pinned to the digest that was pulled so a moved tag does not change the image
+func seedImageReference(ibu *ranv1alpha1.ImageBasedUpgrade) string {
+	status := ibu.Status.SeedImage
+	if status == nil || status.Image != ibu.Spec.SeedImageRef.Image {
+		return ibu.Spec.SeedImageRef.Image
+	}
+	reference := status.Image
+	if status.PulledFrom != "" {
+		reference = status.PulledFrom
+	}
+	if status.Digest != "" {
+		reference = digestReference(reference, status.Digest)
+	}
+	return reference
+}
+
+// digestReference replaces the tag or digest of an image reference with the given digest
+func digestReference(image, digest string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + "@" + digest
+}
+
+// verifySeedImage checks that the seed image pulled before a restart is still on the node, without pulling it
+func (r *ImageBasedUpgradeReconciler) verifySeedImage(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	if ibu.Status.SeedImage == nil || ibu.Status.SeedImage.Image != ibu.Spec.SeedImageRef.Image {
+		return fmt.Errorf("seed image %s not reported in the status", ibu.Spec.SeedImageRef.Image)
+	}
+	exists, err := r.SeedImageClient.Exists(ctx, seedImageReference(ibu))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("seed image %s is no longer on the node", ibu.Spec.SeedImageRef.Image)
+	}
+	return nil
+}
+
+// setSeedMetadata records the seed metadata in the seed image status
+func setSeedMetadata(status *ranv1alpha1.SeedImageStatus, metadata *seedimage.Metadata) {
+	status.Version = metadata.Version
+	status.ReleaseImage = metadata.ReleaseImage
+	status.Architecture = metadata.Architecture
+	status.SeedCluster = ranv1alpha1.SeedClusterInfo(metadata.SeedCluster)
+	status.BuildTime = nil
+	if !metadata.BuildTime.IsZero() {
+		buildTime := metav1.NewTime(metadata.BuildTime)
+		status.BuildTime = &buildTime
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
+)
+
+const testSeedImage = "quay.io/openshift-kni/seed:4.14.1"
+
+var testSeedImageRef = ranv1alpha1.SeedImageRef{Image: testSeedImage, Version: "4.14.1"}
+
+// fakeSeedImageClient serves seed images of the given version for any reference
+type fakeSeedImageClient struct {
+	version    string
+	properties *seedimage.ClusterProperties
+	size       int64
+	missing    bool
+	err        error
+	// unreachable references fail to pull, such as a mirror that is down
+	unreachable map[string]bool
+	// trusted policies verify the image, by file name
+	trusted   map[string]bool
+	pulled    []string
+	inspected []string
+	verified  []seedimage.Policy
+	// verifiedImages are the references verified, in order
+	verifiedImages []string
+	// block, if set, holds the pulls until closed
+	block chan struct{}
+}
+
+func (c *fakeSeedImageClient) Pull(ctx context.Context, reference string) (*seedimage.Image, error) {
+	if c.block != nil {
+		select {
+		case <-c.block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c.pulled = append(c.pulled, reference)
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.unreachable[reference] {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return &seedimage.Image{Reference: reference, Digest: "sha256:1234", Size: c.size}, nil
+}
+
+func (c *fakeSeedImageClient) Metadata(ctx context.Context, reference string) (*seedimage.Metadata, error) {
+	c.inspected = append(c.inspected, reference)
+	if c.err != nil {
+		return nil, c.err
+	}
+	version := c.version
+	if version == "" {
+		version = testSeedImageRef.Version
+	}
+	return &seedimage.Metadata{
+		Version:      version,
+		ReleaseImage: "quay.io/openshift-release-dev/ocp-release:" + version + "-x86_64",
+		Architecture: runtime.GOARCH,
+		BuildTime:    time.Date(2023, 11, 2, 10, 0, 0, 0, time.UTC),
+		SeedCluster:  seedimage.ClusterInfo{ClusterName: "seed", BaseDomain: "example.com"},
+		Properties:   c.properties,
+	}, nil
+}
+
+func (c *fakeSeedImageClient) Size(ctx context.Context, reference string) (int64, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.unreachable[reference] {
+		return 0, fmt.Errorf("connection refused")
+	}
+	return c.size, nil
+}
+
+func (c *fakeSeedImageClient) Verify(ctx context.Context, reference string, policy seedimage.Policy) error {
+	c.verified = append(c.verified, policy)
+	c.verifiedImages = append(c.verifiedImages, reference)
+	if !c.trusted[filepath.Base(policy.Path)] {
+		return fmt.Errorf("signature not valid")
+	}
+	return nil
+}
+
+func (c *fakeSeedImageClient) Exists(ctx context.Context, reference string) (bool, error) {
+	return !c.missing && c.err == nil, nil
+}
+
+func TestPullSeedImage(t *testing.T) {
+	roomyFilesystems(t)
+	testcases := []struct {
+		name       string
+		ref        ranv1alpha1.SeedImageRef
+		mirrors    []client.Object
+		client     *fakeSeedImageClient
+		version    string
+		pulledFrom string
+		reason     utils.ConditionReason
+		fail       string
+	}{
+		{
+			name:    "matching version",
+			ref:     testSeedImageRef,
+			client:  &fakeSeedImageClient{},
+			version: "4.14.1",
+		},
+		{
+			name:       "pulled from the first mirror that has it",
+			ref:        testSeedImageRef,
+			mirrors:    []client.Object{testTagMirrorSet("quay.io/openshift-kni", "mirror1.example.com/kni", "mirror2.example.com/kni")},
+			client:     &fakeSeedImageClient{unreachable: map[string]bool{"mirror1.example.com/kni/seed:4.14.1": true}},
+			version:    "4.14.1",
+			pulledFrom: "mirror2.example.com/kni/seed:4.14.1",
+		},
+		{
+			name:    "digest mirrors do not apply to tags",
+			ref:     testSeedImageRef,
+			mirrors: []client.Object{testDigestMirrorSet("quay.io/openshift-kni", "mirror.example.com/kni")},
+			client:  &fakeSeedImageClient{},
+			version: "4.14.1",
+		},
+		{
+			name: "most specific source wins",
+			ref:  testSeedImageRef,
+			mirrors: []client.Object{
+				testTagMirrorSet("quay.io/openshift-kni", "mirror.example.com/kni"),
+				testTagMirrorSet("quay.io/openshift-kni/seed", "mirror.example.com/seed"),
+			},
+			client:     &fakeSeedImageClient{},
+			version:    "4.14.1",
+			pulledFrom: "mirror.example.com/seed:4.14.1",
+		},
+		{
+			name:    "mirrors and source unreachable",
+			ref:     testSeedImageRef,
+			mirrors: []client.Object{testTagMirrorSet("quay.io/openshift-kni", "mirror.example.com/kni")},
+			client: &fakeSeedImageClient{unreachable: map[string]bool{
+				"mirror.example.com/kni/seed:4.14.1": true,
+				testSeedImage:                        true,
+			}},
+			reason: utils.ConditionReasons.Failed,
+			fail: "failed to get the size of seed image quay.io/openshift-kni/seed:4.14.1 from any of its mirrors: " +
+				"mirror.example.com/kni/seed:4.14.1: connection refused; quay.io/openshift-kni/seed:4.14.1: connection refused",
+		},
+		{
+			name:    "any version",
+			ref:     ranv1alpha1.SeedImageRef{Image: testSeedImage},
+			client:  &fakeSeedImageClient{version: "4.15.0"},
+			version: "4.15.0",
+		},
+		{
+			name:   "version mismatch",
+			ref:    testSeedImageRef,
+			client: &fakeSeedImageClient{version: "4.15.0"},
+			reason: utils.ConditionReasons.SeedMismatch,
+			fail:   "seed image quay.io/openshift-kni/seed:4.14.1 is version 4.15.0, spec.seedImageRef.version is 4.14.1",
+		},
+		{
+			name:   "pull failure",
+			ref:    testSeedImageRef,
+			client: &fakeSeedImageClient{err: fmt.Errorf("manifest unknown")},
+			reason: utils.ConditionReasons.Failed,
+			fail:   "manifest unknown",
+		},
+		{
+			name:   "no image",
+			ref:    ranv1alpha1.SeedImageRef{Version: "4.14.1"},
+			client: &fakeSeedImageClient{},
+			reason: utils.ConditionReasons.Failed,
+			fail:   "spec.seedImageRef.image is not set",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+				Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: tc.ref},
+			}
+			fakeClient, _ := getFakeClientFromObjects(append([]client.Object{ibu}, tc.mirrors...)...)
+			r := &ImageBasedUpgradeReconciler{
+				Client:          fakeClient,
+				APIReader:       fakeClient,
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Recorder:        record.NewFakeRecorder(100),
+				SeedImageClient: tc.client,
+			}
+			_, err := r.handlePrep(context.TODO(), ibu)
+			assert.NoError(t, err)
+
+			completed := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted))
+			if tc.fail != "" {
+				assert.Equal(t, metav1.ConditionFalse, completed.Status)
+				assert.Equal(t, string(tc.reason), completed.Reason)
+				assert.Contains(t, completed.Message, tc.fail)
+				return
+			}
+			assert.Equal(t, metav1.ConditionTrue, completed.Status)
+			pulledFrom := tc.pulledFrom
+			if pulledFrom == "" {
+				pulledFrom = testSeedImage
+			}
+			// The later steps read the seed image from where it was pulled, pinned to the digest pulled
+			assert.Contains(t, tc.client.pulled, pulledFrom)
+			assert.NotEmpty(t, tc.client.inspected)
+			for _, reference := range tc.client.inspected {
+				assert.Equal(t, digestReference(pulledFrom, "sha256:1234"), reference)
+			}
+			buildTime := metav1.NewTime(time.Date(2023, 11, 2, 10, 0, 0, 0, time.UTC))
+			assert.Equal(t, &ranv1alpha1.SeedImageStatus{
+				Image:        testSeedImage,
+				Digest:       "sha256:1234",
+				Version:      tc.version,
+				ReleaseImage: "quay.io/openshift-release-dev/ocp-release:" + tc.version + "-x86_64",
+				Architecture: runtime.GOARCH,
+				BuildTime:    &buildTime,
+				SeedCluster:  ranv1alpha1.SeedClusterInfo{ClusterName: "seed", BaseDomain: "example.com"},
+				PulledFrom:   pulledFrom,
+			}, ibu.Status.SeedImage)
+		})
+	}
+}
+
+func TestPullSeedImageInBackground(t *testing.T) {
+	defer func(old time.Duration) { seedPullWait = old }(seedPullWait)
+	seedPullWait = time.Millisecond
+
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: testSeedImageRef},
+	}
+	fakeClient, _ := getFakeClientFromObjects(ibu)
+	seedImageClient := &fakeSeedImageClient{block: make(chan struct{})}
+	r := &ImageBasedUpgradeReconciler{Client: fakeClient, Log: logr.Discard(), SeedImageClient: seedImageClient}
+
+	done, message, err := r.pullSeedImage(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "Pulling seed image quay.io/openshift-kni/seed:4.14.1", message)
+	job := r.seedPull
+
+	// The same pull keeps running across reconciles
+	_, _, _ = r.pullSeedImage(context.TODO(), ibu)
+	assert.Same(t, job, r.seedPull)
+
+	close(seedImageClient.block)
+	<-job.done
+	done, _, err = r.pullSeedImage(context.TODO(), ibu)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Nil(t, r.seedPull, "the finished pull is forgotten")
+	assert.Equal(t, "sha256:1234", ibu.Status.SeedImage.Digest)
+
+	// Aborting cancels the pull
+	seedImageClient = &fakeSeedImageClient{block: make(chan struct{})}
+	r.SeedImageClient = seedImageClient
+	_, _, _ = r.pullSeedImage(context.TODO(), ibu)
+	job = r.seedPull
+	r.stopSeedPull()
+	assert.Nil(t, r.seedPull)
+	assert.ErrorIs(t, job.err, context.Canceled)
+	assert.Empty(t, seedImageClient.pulled)
+
+	r.SeedImageClient = &fakeSeedImageClient{unreachable: map[string]bool{"mirror.example.com/seed:4.14.1": true, testSeedImage: true}}
+	_, err = r.pullFirstCandidate(context.TODO(), testSeedImage, []string{"mirror.example.com/seed:4.14.1", testSeedImage})
+	assert.EqualError(t, err, "failed to pull seed image quay.io/openshift-kni/seed:4.14.1 from any of its mirrors: "+
+		"mirror.example.com/seed:4.14.1: connection refused; quay.io/openshift-kni/seed:4.14.1: connection refused")
+}
+
+func TestDigestReference(t *testing.T) {
+	for image, expected := range map[string]string{
+		"quay.io/openshift-kni/seed:4.14.1":      "quay.io/openshift-kni/seed@sha256:1234",
+		"quay.io/openshift-kni/seed@sha256:abcd": "quay.io/openshift-kni/seed@sha256:1234",
+		"quay.io/openshift-kni/seed":             "quay.io/openshift-kni/seed@sha256:1234",
+		"registry.example.com:5000/seed:4.14.1":  "registry.example.com:5000/seed@sha256:1234",
+		"registry.example.com:5000/seed":         "registry.example.com:5000/seed@sha256:1234",
+		"oci:/var/lib/seed:4.14.1":               "oci:/var/lib/seed@sha256:1234",
+		"oci:/var/lib/seed":                      "oci:/var/lib/seed@sha256:1234",
+	} {
+		assert.Equal(t, expected, digestReference(image, "sha256:1234"), image)
+	}
+}
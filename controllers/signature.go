@@ -0,0 +1,214 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
+)
+
+const (
+	verifySeedSignatureStep = "VerifySeedSignature"
+
+	// signaturePolicyKey holds a complete containers-policy.json(5) document
+	signaturePolicyKey = "policy.json"
+	// publicKeySuffix ends the keys holding cosign public keys
+	publicKeySuffix = ".pub"
+)
+
+// sigstoreRegistriesConfig has the cosign signatures looked up as attachments of the images in their registry
+const sigstoreRegistriesConfig = "default-docker:\n  use-sigstore-attachments: true\n"
+
+// namedPolicy is a policy the seed image may be verified with, named after the key it comes from
+type namedPolicy struct {
+	name   string
+	policy seedimage.Policy
+}
+
+// verifySeedSignature verifies the signature of the seed image against the policy or the public keys of
+// spec.seedImageRef.signatureVerification, before anything is read from the image. The digest pulled is verified,
+// a tag could have moved since.
+func (r *ImageBasedUpgradeReconciler) verifySeedSignature(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (bool, string, error) {
+	verification := ibu.Spec.SeedImageRef.SignatureVerification
+	if verification == nil {
+		return true, "Signature verification is not configured", nil
+	}
+	status := ibu.Status.SeedImage
+	if status == nil || status.Image != ibu.Spec.SeedImageRef.Image || status.Digest == "" {
+		return false, "", fmt.Errorf("seed image %s not reported in the status", ibu.Spec.SeedImageRef.Image)
+	}
+	data, err := r.signaturePolicyData(ctx, ibu, verification)
+	if err != nil {
+		return false, "", err
+	}
+
+	dir := filepath.Join(utils.HostPath, utils.SignaturePolicyDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return false, "", fmt.Errorf("failed to clean up the signature policies: %w", err)
+	}
+	// The policies are only needed while verifying
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	policies, err := writeSignaturePolicies(dir, data)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to write the signature policies of %s %s: %w", verification.Kind, verification.Name, err)
+	}
+	if len(policies) == 0 {
+		return false, "", fmt.Errorf("%s %s has neither a %s nor keys ending in %s", verification.Kind, verification.Name,
+			signaturePolicyKey, publicKeySuffix)
+	}
+
+	// The digest that was pulled, under the identity the image is signed with
+	image := digestReference(ibu.Spec.SeedImageRef.Image, status.Digest)
+	var errs []string
+	for _, policy := range policies {
+		err := r.SeedImageClient.Verify(ctx, image, policy.policy)
+		if err == nil {
+			status.SignatureVerifiedBy = policy.name
+			return true, fmt.Sprintf("Verified the signature of seed image %s with %s", image, policy.name), nil
+		}
+		r.Log.Info("Seed image signature verification failed", "policy", policy.name, "error", err.Error())
+		errs = append(errs, fmt.Sprintf("%s: %s", policy.name, err))
+	}
+	return false, "", &stageError{
+		reason: utils.ConditionReasons.SignatureInvalid,
+		err:    fmt.Errorf("seed image %s failed signature verification: %s", image, strings.Join(errs, "; ")),
+	}
+}
+
+// verifySeedSignatureResult checks the signature verification before a restart still applies to the seed
+// image reported in the status, pulling the seed image again drops it
+func (r *ImageBasedUpgradeReconciler) verifySeedSignatureResult(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	if ibu.Spec.SeedImageRef.SignatureVerification == nil {
+		return nil
+	}
+	if ibu.Status.SeedImage == nil || ibu.Status.SeedImage.SignatureVerifiedBy == "" {
+		return fmt.Errorf("the signature of seed image %s is not verified", ibu.Spec.SeedImageRef.Image)
+	}
+	return nil
+}
+
+// signaturePolicyData returns the data of the ConfigMap or Secret holding the signature policy
+func (r *ImageBasedUpgradeReconciler) signaturePolicyData(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, verification *ranv1alpha1.SignatureVerification) (map[string][]byte, error) {
+	key := types.NamespacedName{Name: verification.Name, Namespace: verification.Namespace}
+	if key.Namespace == "" {
+		key.Namespace = ibu.Namespace
+	}
+	switch verification.Kind {
+	case "ConfigMap":
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, key, cm); err != nil {
+			return nil, fmt.Errorf("failed to get the signature policy ConfigMap %s: %w", key, err)
+		}
+		data := map[string][]byte{}
+		for name, value := range cm.Data {
+			data[name] = []byte(value)
+		}
+		for name, value := range cm.BinaryData {
+			data[name] = value
+		}
+		return data, nil
+	case "Secret":
+		secret := &corev1.Secret{}
+		if err := r.APIReader.Get(ctx, key, secret); err != nil {
+			return nil, fmt.Errorf("failed to get the signature policy Secret %s: %w", key, err)
+		}
+		return secret.Data, nil
+	}
+	return nil, fmt.Errorf("unsupported signature policy kind %q", verification.Kind)
+}
+
+// writeSignaturePolicies writes the data in dir, where a policy.json can reference the other keys, and returns
+// the policy.json or a policy per public key otherwise. The paths of the policies are the ones on the host.
+func writeSignaturePolicies(dir string, data map[string][]byte) ([]namedPolicy, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	for name, value := range data {
+		if err := os.WriteFile(filepath.Join(dir, name), value, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := data[signaturePolicyKey]; ok {
+		return []namedPolicy{{
+			name:   signaturePolicyKey,
+			policy: seedimage.Policy{Path: filepath.Join(utils.SignaturePolicyDir, signaturePolicyKey)},
+		}}, nil
+	}
+
+	var keys []string
+	for name := range data {
+		if strings.HasSuffix(name, publicKeySuffix) {
+			keys = append(keys, name)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+
+	registriesDir := filepath.Join(dir, "registries.d")
+	if err := os.MkdirAll(registriesDir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(registriesDir, "sigstore.yaml"), []byte(sigstoreRegistriesConfig), 0o600); err != nil {
+		return nil, err
+	}
+	policiesDir := filepath.Join(dir, "policies")
+	if err := os.MkdirAll(policiesDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	// A policy per key: the requirements of a policy must all be satisfied, one signing key is enough here
+	var policies []namedPolicy
+	for _, key := range keys {
+		policy, err := json.Marshal(map[string]interface{}{
+			"default": []interface{}{map[string]interface{}{
+				"type":           "sigstoreSigned",
+				"keyPath":        filepath.Join(utils.SignaturePolicyDir, key),
+				"signedIdentity": map[string]string{"type": "matchRepository"},
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(policiesDir, key+".json"), policy, 0o600); err != nil {
+			return nil, err
+		}
+		policies = append(policies, namedPolicy{
+			name: key,
+			policy: seedimage.Policy{
+				Path:          filepath.Join(utils.SignaturePolicyDir, "policies", key+".json"),
+				RegistriesDir: filepath.Join(utils.SignaturePolicyDir, "registries.d"),
+			},
+		})
+	}
+	return policies, nil
+}
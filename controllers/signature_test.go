@@ -0,0 +1,181 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const testPublicKey = "-----BEGIN PUBLIC KEY-----\nMFkw\n-----END PUBLIC KEY-----\n"
+
+func TestVerifySeedSignature(t *testing.T) {
+	roomyFilesystems(t)
+	keys := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "seed-keys", Namespace: lcaNs},
+		Data:       map[string]string{"release.pub": testPublicKey, "ran.pub": testPublicKey, "README": "keys of the seed builders"},
+	}
+	policy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "seed-policy", Namespace: "security"},
+		Data:       map[string][]byte{"policy.json": []byte(`{"default":[{"type":"reject"}]}`)},
+	}
+	empty := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: lcaNs}}
+
+	testcases := []struct {
+		name         string
+		verification *ranv1alpha1.SignatureVerification
+		trusted      map[string]bool
+		verifiedBy   string
+		policies     []string
+		reason       utils.ConditionReason
+		fail         string
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name:         "signed by one of the keys",
+			verification: &ranv1alpha1.SignatureVerification{Kind: "ConfigMap", Name: "seed-keys"},
+			trusted:      map[string]bool{"release.pub.json": true},
+			verifiedBy:   "release.pub",
+			policies:     []string{"/var/lib/lca/signature-policy/policies/ran.pub.json", "/var/lib/lca/signature-policy/policies/release.pub.json"},
+		},
+		{
+			name:         "accepted by the policy",
+			verification: &ranv1alpha1.SignatureVerification{Kind: "Secret", Name: "seed-policy", Namespace: "security"},
+			trusted:      map[string]bool{"policy.json": true},
+			verifiedBy:   "policy.json",
+			policies:     []string{"/var/lib/lca/signature-policy/policy.json"},
+		},
+		{
+			name:         "signed by none of the keys",
+			verification: &ranv1alpha1.SignatureVerification{Kind: "ConfigMap", Name: "seed-keys"},
+			policies:     []string{"/var/lib/lca/signature-policy/policies/ran.pub.json", "/var/lib/lca/signature-policy/policies/release.pub.json"},
+			reason:       utils.ConditionReasons.SignatureInvalid,
+			fail: "seed image quay.io/openshift-kni/seed@sha256:1234 failed signature verification: " +
+				"ran.pub: signature not valid; release.pub: signature not valid",
+		},
+		{
+			name:         "no keys",
+			verification: &ranv1alpha1.SignatureVerification{Kind: "ConfigMap", Name: "empty"},
+			reason:       utils.ConditionReasons.Failed,
+			fail:         "ConfigMap empty has neither a policy.json nor keys ending in .pub",
+		},
+		{
+			name:         "missing Secret",
+			verification: &ranv1alpha1.SignatureVerification{Kind: "Secret", Name: "seed-policy"},
+			reason:       utils.ConditionReasons.Failed,
+			fail:         "failed to get the signature policy Secret openshift-lifecycle-agent/seed-policy",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ref := testSeedImageRef
+			ref.SignatureVerification = tc.verification
+			ibu := &ranv1alpha1.ImageBasedUpgrade{
+				ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+				Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Prep, SeedImageRef: ref},
+			}
+			fakeClient, _ := getFakeClientFromObjects([]client.Object{ibu, keys, policy, empty}...)
+			seedImageClient := &fakeSeedImageClient{trusted: tc.trusted}
+			r := &ImageBasedUpgradeReconciler{
+				Client:          fakeClient,
+				APIReader:       fakeClient,
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Recorder:        record.NewFakeRecorder(100),
+				SeedImageClient: seedImageClient,
+			}
+			_, err := r.handlePrep(context.TODO(), ibu)
+			assert.NoError(t, err)
+
+			var policies []string
+			for _, policy := range seedImageClient.verified {
+				policies = append(policies, policy.Path)
+			}
+			assert.Equal(t, tc.policies, policies)
+			assert.NoDirExists(t, filepath.Join(utils.HostPath, utils.SignaturePolicyDir), "the policies are removed once verified")
+			for _, image := range seedImageClient.verifiedImages {
+				assert.Equal(t, "quay.io/openshift-kni/seed@sha256:1234", image, "the digest pulled is verified")
+			}
+
+			completed := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.PrepCompleted))
+			if tc.fail != "" {
+				if tc.reason == utils.ConditionReasons.SignatureInvalid {
+					assert.Empty(t, seedImageClient.inspected, "nothing is read from an unverified image")
+				}
+				assert.Equal(t, metav1.ConditionFalse, completed.Status)
+				assert.Equal(t, string(tc.reason), completed.Reason)
+				assert.Contains(t, completed.Message, tc.fail)
+				return
+			}
+			assert.Equal(t, metav1.ConditionTrue, completed.Status)
+			assert.Equal(t, tc.verifiedBy, ibu.Status.SeedImage.SignatureVerifiedBy)
+		})
+	}
+}
+
+func TestVerifySeedSignatureResult(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{SeedImageRef: testSeedImageRef},
+	}
+	r := &ImageBasedUpgradeReconciler{}
+	assert.NoError(t, r.verifySeedSignatureResult(context.TODO(), ibu))
+
+	// Pulling the seed image again resets the status, the new image must be verified
+	ibu.Spec.SeedImageRef.SignatureVerification = &ranv1alpha1.SignatureVerification{Kind: "ConfigMap", Name: "seed-keys"}
+	ibu.Status.SeedImage = &ranv1alpha1.SeedImageStatus{Image: testSeedImage}
+	assert.ErrorContains(t, r.verifySeedSignatureResult(context.TODO(), ibu), "is not verified")
+
+	ibu.Status.SeedImage.SignatureVerifiedBy = "release.pub"
+	assert.NoError(t, r.verifySeedSignatureResult(context.TODO(), ibu))
+}
+
+func TestWriteSignaturePolicies(t *testing.T) {
+	dir := t.TempDir()
+	policies, err := writeSignaturePolicies(dir, map[string][]byte{"release.pub": []byte(testPublicKey)})
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	assert.Equal(t, "/var/lib/lca/signature-policy/registries.d", policies[0].policy.RegistriesDir)
+
+	key, err := os.ReadFile(filepath.Join(dir, "release.pub"))
+	assert.NoError(t, err)
+	assert.Equal(t, testPublicKey, string(key))
+	policy, err := os.ReadFile(filepath.Join(dir, "policies", "release.pub.json"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"default":[{
+		"type": "sigstoreSigned",
+		"keyPath": "/var/lib/lca/signature-policy/release.pub",
+		"signedIdentity": {"type": "matchRepository"}
+	}]}`, string(policy))
+	registries, err := os.ReadFile(filepath.Join(dir, "registries.d", "sigstore.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(registries), "use-sigstore-attachments: true")
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// singletonKey returns the name and namespace of the only ImageBasedUpgrade the operator reconciles
+func (r *ImageBasedUpgradeReconciler) singletonKey() types.NamespacedName {
+	namespace := r.Namespace
+	if namespace == "" {
+		namespace = utils.LCANamespace
+	}
+	return types.NamespacedName{Name: utils.IBUName, Namespace: namespace}
+}
+
+// ensureSingleton creates the ImageBasedUpgrade in the Idle stage if it does not exist
+func (r *ImageBasedUpgradeReconciler) ensureSingleton(ctx context.Context) error {
+	key := r.singletonKey()
+	err := r.Get(ctx, key, &ranv1alpha1.ImageBasedUpgrade{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	r.Log.Info("Creating ImageBasedUpgrade", "name", key)
+	ibu := &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: ranv1alpha1.Stages.Idle},
+	}
+	if err := r.Create(ctx, ibu); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create ImageBasedUpgrade %s: %w", key, err)
+	}
+	return nil
+}
+
+// createSingletonOnStart runs with the manager and creates the ImageBasedUpgrade, retrying until it succeeds
+func (r *ImageBasedUpgradeReconciler) createSingletonOnStart(ctx context.Context) error {
+	err := wait.PollUntilContextCancel(ctx, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		if err := r.ensureSingleton(ctx); err != nil {
+			r.Log.Error(err, "Failed to ensure the ImageBasedUpgrade exists, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+	if ctx.Err() != nil {
+		// The manager is stopping
+		return nil
+	}
+	return err
+}
+
+// flagStray marks an ImageBasedUpgrade other than the singleton as ignored
+func (r *ImageBasedUpgradeReconciler) flagStray(ctx context.Context, key types.NamespacedName) error {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	if err := r.Get(ctx, key, ibu); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	singleton := r.singletonKey()
+	message := fmt.Sprintf("Only the ImageBasedUpgrade %s in namespace %s is reconciled, this one is ignored",
+		singleton.Name, singleton.Namespace)
+	condition := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.Ignored))
+	if condition != nil && condition.Status == metav1.ConditionTrue && condition.Message == message {
+		return nil
+	}
+
+	r.Log.Info("Ignoring ImageBasedUpgrade", "name", key)
+	r.recordEvent(ibu, corev1.EventTypeWarning, utils.ConditionReasons.NotSingleton, message)
+	// Only the condition is patched, the state of a stray object is not tracked
+	patch := client.MergeFrom(ibu.DeepCopy())
+	utils.SetStatusCondition(&ibu.Status.Conditions,
+		utils.ConditionTypes.Ignored,
+		utils.ConditionReasons.NotSingleton,
+		metav1.ConditionTrue,
+		message,
+		ibu.Generation)
+	if err := r.Status().Patch(ctx, ibu, patch); err != nil {
+		return fmt.Errorf("failed to flag ImageBasedUpgrade %s as ignored: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+func TestSingleton(t *testing.T) {
+	singleton := types.NamespacedName{Name: utils.IBUName, Namespace: lcaNs}
+	stray := &ranv1alpha1.ImageBasedUpgrade{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: lcaNs}}
+	strayNamespace := &ranv1alpha1.ImageBasedUpgrade{ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: "default"}}
+	fakeClient, _ := getFakeClientFromObjects([]client.Object{stray, strayNamespace}...)
+	r := &ImageBasedUpgradeReconciler{
+		Client:    fakeClient,
+		Recorder:  record.NewFakeRecorder(100),
+		Log:       logr.Discard(),
+		Scheme:    fakeClient.Scheme(),
+		Namespace: lcaNs,
+	}
+
+	// Created at startup
+	assert.NoError(t, r.createSingletonOnStart(context.TODO()))
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), singleton, ibu))
+	assert.Equal(t, ranv1alpha1.Stages.Idle, ibu.Spec.Stage)
+
+	// Recreated when deleted
+	assert.NoError(t, fakeClient.Delete(context.TODO(), ibu))
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: singleton})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), singleton, &ranv1alpha1.ImageBasedUpgrade{}))
+
+	// Other ImageBasedUpgrades are flagged and left alone
+	for _, obj := range []*ranv1alpha1.ImageBasedUpgrade{stray, strayNamespace} {
+		key := client.ObjectKeyFromObject(obj)
+		_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+		assert.NoError(t, err)
+		ignored := &ranv1alpha1.ImageBasedUpgrade{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), key, ignored))
+		condition := meta.FindStatusCondition(ignored.Status.Conditions, string(utils.ConditionTypes.Ignored))
+		if assert.NotNil(t, condition, key.String()) {
+			assert.Equal(t, metav1.ConditionTrue, condition.Status)
+			assert.Equal(t, string(utils.ConditionReasons.NotSingleton), condition.Reason)
+		}
+		assert.Nil(t, meta.FindStatusCondition(ignored.Status.Conditions, string(utils.ConditionTypes.Idle)))
+		assert.Empty(t, ignored.Status.State)
+		assert.Empty(t, ignored.Status.ValidNextStages)
+	}
+}
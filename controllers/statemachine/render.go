@@ -0,0 +1,82 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statemachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diagramEdges returns the edges worth drawing: every accepted spec transition
+// that changes the state plus every handler outcome. Rejections and no-ops are left out.
+func diagramEdges() (edges []Outcome) {
+	for _, t := range Transitions {
+		if !t.ChangesState() {
+			continue
+		}
+		edges = append(edges, Outcome{
+			From:  t.From,
+			To:    targetState(t),
+			Label: fmt.Sprintf("stage=%s", t.Desired),
+		})
+	}
+	return append(edges, Outcomes...)
+}
+
+func targetState(t Transition) State {
+	switch t.Action {
+	case Actions.StartPrep:
+		return States.PrepInProgress
+	case Actions.StartUpgrade:
+		return States.UpgradeInProgress
+	case Actions.StartRollback:
+		return States.RollbackInProgress
+	case Actions.Abort:
+		return States.Aborting
+	case Actions.Finalize:
+		return States.Finalizing
+	case Actions.Reset:
+		return States.Idle
+	}
+	return t.From
+}
+
+// Dot renders the state machine as a Graphviz digraph
+func Dot() string {
+	var b strings.Builder
+	b.WriteString("digraph ImageBasedUpgrade {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, s := range AllStates {
+		fmt.Fprintf(&b, "  %s;\n", s)
+	}
+	for _, e := range diagramEdges() {
+		fmt.Fprintf(&b, "  %s -> %s [label=%q];\n", e.From, e.To, e.Label)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the state machine as a Mermaid state diagram
+func Mermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	fmt.Fprintf(&b, "  [*] --> %s\n", States.Idle)
+	for _, e := range diagramEdges() {
+		fmt.Fprintf(&b, "  %s --> %s: %s\n", e.From, e.To, e.Label)
+	}
+	return b.String()
+}
@@ -0,0 +1,495 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statemachine declares the ImageBasedUpgrade lifecycle as data: every
+// (current state, desired stage) pair maps to an action and the conditions that
+// action sets. The reconciler and the admission webhook both consume it.
+package statemachine
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// State is the lifecycle state of an IBU as derived from its status conditions
+type State string
+
+// States define every state an IBU can be in
+var States = struct {
+	Idle               State
+	PrepInProgress     State
+	PrepCompleted      State
+	PrepFailed         State
+	UpgradeInProgress  State
+	UpgradeCompleted   State
+	UpgradeFailed      State
+	RollbackInProgress State
+	RollbackCompleted  State
+	RollbackFailed     State
+	Aborting           State
+	AbortFailed        State
+	Finalizing         State
+	FinalizeFailed     State
+}{
+	Idle:               "Idle",
+	PrepInProgress:     "PrepInProgress",
+	PrepCompleted:      "PrepCompleted",
+	PrepFailed:         "PrepFailed",
+	UpgradeInProgress:  "UpgradeInProgress",
+	UpgradeCompleted:   "UpgradeCompleted",
+	UpgradeFailed:      "UpgradeFailed",
+	RollbackInProgress: "RollbackInProgress",
+	RollbackCompleted:  "RollbackCompleted",
+	RollbackFailed:     "RollbackFailed",
+	Aborting:           "Aborting",
+	AbortFailed:        "AbortFailed",
+	Finalizing:         "Finalizing",
+	FinalizeFailed:     "FinalizeFailed",
+}
+
+// AllStates lists the states in lifecycle order
+var AllStates = []State{
+	States.Idle,
+	States.PrepInProgress,
+	States.PrepCompleted,
+	States.PrepFailed,
+	States.UpgradeInProgress,
+	States.UpgradeCompleted,
+	States.UpgradeFailed,
+	States.RollbackInProgress,
+	States.RollbackCompleted,
+	States.RollbackFailed,
+	States.Aborting,
+	States.AbortFailed,
+	States.Finalizing,
+	States.FinalizeFailed,
+}
+
+// AllStages lists the stages a user can request in spec.stage
+var AllStages = []ranv1alpha1.ImageBasedUpgradeStage{
+	ranv1alpha1.Stages.Idle,
+	ranv1alpha1.Stages.Prep,
+	ranv1alpha1.Stages.Upgrade,
+	ranv1alpha1.Stages.Rollback,
+}
+
+// Action is what the reconciler does when a stage is requested in a given state
+type Action string
+
+// Actions define the possible outcomes of requesting a stage
+var Actions = struct {
+	None          Action
+	Reset         Action
+	StartPrep     Action
+	StartUpgrade  Action
+	StartRollback Action
+	Abort         Action
+	Finalize      Action
+	Reject        Action
+}{
+	None:          "None",
+	Reset:         "Reset",
+	StartPrep:     "StartPrep",
+	StartUpgrade:  "StartUpgrade",
+	StartRollback: "StartRollback",
+	Abort:         "Abort",
+	Finalize:      "Finalize",
+	Reject:        "Reject",
+}
+
+// ConditionUpdate is a condition set when a transition is taken
+type ConditionUpdate struct {
+	Type    utils.ConditionType
+	Reason  utils.ConditionReason
+	Status  metav1.ConditionStatus
+	Message string
+}
+
+// Transition is a single edge of the state machine
+type Transition struct {
+	From       State
+	Desired    ranv1alpha1.ImageBasedUpgradeStage
+	Action     Action
+	Conditions []ConditionUpdate
+}
+
+// RunsHandler returns true if the stage handler for the desired stage must run after the transition is applied
+func (t Transition) RunsHandler() bool {
+	switch t.Action {
+	case Actions.StartPrep, Actions.StartUpgrade, Actions.StartRollback, Actions.Abort, Actions.Finalize:
+		return true
+	}
+	return false
+}
+
+// ChangesState returns true if taking the transition moves the IBU to another state
+func (t Transition) ChangesState() bool {
+	switch t.Action {
+	case Actions.None, Actions.Reject:
+		return false
+	case Actions.Reset:
+		return t.From != States.Idle
+	}
+	return true
+}
+
+// Message returns the human-readable message of the transition, used to explain rejections
+func (t Transition) Message() string {
+	if len(t.Conditions) == 0 {
+		return ""
+	}
+	return t.Conditions[len(t.Conditions)-1].Message
+}
+
+// Outcome is an edge taken by a stage handler rather than by a spec change
+type Outcome struct {
+	From  State
+	To    State
+	Label string
+}
+
+const (
+	msgInProgress           = "In progress"
+	msgAborting             = "Aborting"
+	msgFinalizing           = "Finalizing"
+	msgPrevNotSucceeded     = "Previous stage not succeeded yet"
+	msgUpgradeNotStarted    = "Upgrade not started or already finalized"
+	msgStillInProgress      = "Upgrade or rollback still in progress"
+	msgUpgradeFailed        = "Upgrade failed, rollback is required"
+	msgBackwards            = "Cannot go back to an earlier stage, abort or finalize first"
+	msgAbortInProgress      = "Abort in progress"
+	msgAbortFailed          = "Abort failed, manual cleanup required"
+	msgFinalizeInProgress   = "Finalize in progress"
+	msgFinalizeFailed       = "Finalize failed, manual cleanup required"
+	msgUpgradeAfterRollback = "Upgrade cannot be restarted after rollback, finalize first"
+	msgAbortAfterPivot      = "The node runs the new stateroot, rollback is required"
+)
+
+func none(from State, desired ranv1alpha1.ImageBasedUpgradeStage) Transition {
+	return Transition{From: from, Desired: desired, Action: Actions.None}
+}
+
+func reset(from State) Transition {
+	return Transition{From: from, Desired: ranv1alpha1.Stages.Idle, Action: Actions.Reset}
+}
+
+func reject(from State, desired ranv1alpha1.ImageBasedUpgradeStage, message string) Transition {
+	conditionType := utils.GetInProgressConditionType(desired)
+	if desired == ranv1alpha1.Stages.Idle {
+		conditionType = utils.ConditionTypes.Idle
+	}
+	return Transition{
+		From:    from,
+		Desired: desired,
+		Action:  Actions.Reject,
+		Conditions: []ConditionUpdate{
+			{conditionType, utils.ConditionReasons.InvalidTransition, metav1.ConditionFalse, message},
+		},
+	}
+}
+
+func start(from State, desired ranv1alpha1.ImageBasedUpgradeStage) Transition {
+	t := Transition{From: from, Desired: desired}
+	switch desired {
+	case ranv1alpha1.Stages.Prep:
+		t.Action = Actions.StartPrep
+		// Idle goes to false when leaving idle for prep
+		t.Conditions = append(t.Conditions,
+			ConditionUpdate{utils.ConditionTypes.Idle, utils.ConditionReasons.InProgress, metav1.ConditionFalse, msgInProgress})
+	case ranv1alpha1.Stages.Upgrade:
+		t.Action = Actions.StartUpgrade
+	case ranv1alpha1.Stages.Rollback:
+		t.Action = Actions.StartRollback
+	}
+	t.Conditions = append(t.Conditions,
+		ConditionUpdate{utils.GetInProgressConditionType(desired), utils.ConditionReasons.InProgress, metav1.ConditionTrue, msgInProgress})
+	return t
+}
+
+func abort(from State) Transition {
+	return Transition{
+		From:    from,
+		Desired: ranv1alpha1.Stages.Idle,
+		Action:  Actions.Abort,
+		Conditions: []ConditionUpdate{
+			{utils.ConditionTypes.Idle, utils.ConditionReasons.Aborting, metav1.ConditionFalse, msgAborting},
+		},
+	}
+}
+
+func finalize(from State) Transition {
+	return Transition{
+		From:    from,
+		Desired: ranv1alpha1.Stages.Idle,
+		Action:  Actions.Finalize,
+		Conditions: []ConditionUpdate{
+			{utils.ConditionTypes.Idle, utils.ConditionReasons.Finalizing, metav1.ConditionFalse, msgFinalizing},
+		},
+	}
+}
+
+var (
+	idle     = ranv1alpha1.Stages.Idle
+	prep     = ranv1alpha1.Stages.Prep
+	upgrade  = ranv1alpha1.Stages.Upgrade
+	rollback = ranv1alpha1.Stages.Rollback
+)
+
+// Transitions is the full transition table, one entry per (state, desired stage) pair
+var Transitions = []Transition{
+	reset(States.Idle),
+	start(States.Idle, prep),
+	reject(States.Idle, upgrade, msgPrevNotSucceeded),
+	reject(States.Idle, rollback, msgUpgradeNotStarted),
+
+	abort(States.PrepInProgress),
+	none(States.PrepInProgress, prep),
+	reject(States.PrepInProgress, upgrade, msgPrevNotSucceeded),
+	reject(States.PrepInProgress, rollback, msgUpgradeNotStarted),
+
+	abort(States.PrepCompleted),
+	none(States.PrepCompleted, prep),
+	start(States.PrepCompleted, upgrade),
+	reject(States.PrepCompleted, rollback, msgUpgradeNotStarted),
+
+	abort(States.PrepFailed),
+	none(States.PrepFailed, prep),
+	reject(States.PrepFailed, upgrade, msgPrevNotSucceeded),
+	reject(States.PrepFailed, rollback, msgUpgradeNotStarted),
+
+	// Abort is rejected by LookupFor once the node pivoted
+	abort(States.UpgradeInProgress),
+	reject(States.UpgradeInProgress, prep, msgBackwards),
+	none(States.UpgradeInProgress, upgrade),
+	start(States.UpgradeInProgress, rollback),
+
+	finalize(States.UpgradeCompleted),
+	reject(States.UpgradeCompleted, prep, msgBackwards),
+	none(States.UpgradeCompleted, upgrade),
+	start(States.UpgradeCompleted, rollback),
+
+	reject(States.UpgradeFailed, idle, msgUpgradeFailed),
+	reject(States.UpgradeFailed, prep, msgBackwards),
+	none(States.UpgradeFailed, upgrade),
+	start(States.UpgradeFailed, rollback),
+
+	reject(States.RollbackInProgress, idle, msgStillInProgress),
+	reject(States.RollbackInProgress, prep, msgBackwards),
+	reject(States.RollbackInProgress, upgrade, msgUpgradeAfterRollback),
+	none(States.RollbackInProgress, rollback),
+
+	finalize(States.RollbackCompleted),
+	reject(States.RollbackCompleted, prep, msgBackwards),
+	reject(States.RollbackCompleted, upgrade, msgUpgradeAfterRollback),
+	none(States.RollbackCompleted, rollback),
+
+	// Going back to idle after a failed rollback means the manual cleanup is done
+	reset(States.RollbackFailed),
+	reject(States.RollbackFailed, prep, msgBackwards),
+	reject(States.RollbackFailed, upgrade, msgUpgradeAfterRollback),
+	none(States.RollbackFailed, rollback),
+
+	none(States.Aborting, idle),
+	reject(States.Aborting, prep, msgAbortInProgress),
+	reject(States.Aborting, upgrade, msgAbortInProgress),
+	reject(States.Aborting, rollback, msgAbortInProgress),
+
+	none(States.AbortFailed, idle),
+	reject(States.AbortFailed, prep, msgAbortFailed),
+	reject(States.AbortFailed, upgrade, msgAbortFailed),
+	reject(States.AbortFailed, rollback, msgAbortFailed),
+
+	none(States.Finalizing, idle),
+	reject(States.Finalizing, prep, msgFinalizeInProgress),
+	reject(States.Finalizing, upgrade, msgFinalizeInProgress),
+	reject(States.Finalizing, rollback, msgFinalizeInProgress),
+
+	none(States.FinalizeFailed, idle),
+	reject(States.FinalizeFailed, prep, msgFinalizeFailed),
+	reject(States.FinalizeFailed, upgrade, msgFinalizeFailed),
+	reject(States.FinalizeFailed, rollback, msgFinalizeFailed),
+}
+
+// Outcomes lists the edges taken by the stage handlers when their work finishes
+var Outcomes = []Outcome{
+	{States.PrepInProgress, States.PrepCompleted, "completed"},
+	{States.PrepInProgress, States.PrepFailed, "failed"},
+	{States.UpgradeInProgress, States.UpgradeCompleted, "completed"},
+	{States.UpgradeInProgress, States.UpgradeFailed, "failed"},
+	{States.RollbackInProgress, States.RollbackCompleted, "completed"},
+	{States.RollbackInProgress, States.RollbackFailed, "failed"},
+	{States.Aborting, States.Idle, "completed"},
+	{States.Aborting, States.AbortFailed, "failed"},
+	{States.AbortFailed, States.Idle, "cleaned up"},
+	{States.Finalizing, States.Idle, "completed"},
+	{States.Finalizing, States.FinalizeFailed, "failed"},
+	{States.FinalizeFailed, States.Idle, "cleaned up"},
+}
+
+type edgeKey struct {
+	from    State
+	desired ranv1alpha1.ImageBasedUpgradeStage
+}
+
+var transitionIndex = func() map[edgeKey]Transition {
+	index := make(map[edgeKey]Transition, len(Transitions))
+	for _, t := range Transitions {
+		key := edgeKey{t.From, t.Desired}
+		if _, exists := index[key]; exists {
+			panic(fmt.Sprintf("duplicate transition from %s to %s", t.From, t.Desired))
+		}
+		index[key] = t
+	}
+	return index
+}()
+
+// Lookup returns the transition taken when the desired stage is requested in the given state
+func Lookup(state State, desired ranv1alpha1.ImageBasedUpgradeStage) (Transition, error) {
+	t, ok := transitionIndex[edgeKey{state, desired}]
+	if !ok {
+		return Transition{}, fmt.Errorf("no transition from state %q for stage %q", state, desired)
+	}
+	return t, nil
+}
+
+// Pivoted tells whether the node booted the new stateroot during the current Upgrade run. A PivotedAt
+// older than the run comes from a previous one.
+func Pivoted(ibu *ranv1alpha1.ImageBasedUpgrade) bool {
+	run := ibu.Status.Progress
+	return ibu.Status.PivotedAt != nil && (run == nil || !ibu.Status.PivotedAt.Before(&run.StartedAt))
+}
+
+// LookupFor returns the transition taken when the desired stage is requested for the IBU in its current
+// state. Unlike Lookup it rejects the abort of an upgrade once the node pivoted, as only a rollback can
+// bring back the old stateroot.
+func LookupFor(ibu *ranv1alpha1.ImageBasedUpgrade, desired ranv1alpha1.ImageBasedUpgradeStage) (Transition, error) {
+	state := GetState(ibu.Status.Conditions)
+	if state == States.UpgradeInProgress && desired == idle && Pivoted(ibu) {
+		return reject(state, desired, msgAbortAfterPivot), nil
+	}
+	return Lookup(state, desired)
+}
+
+// NextStages returns the stages that can be requested from the given state to move the IBU forward
+func NextStages(state State) []ranv1alpha1.ImageBasedUpgradeStage {
+	var stages []ranv1alpha1.ImageBasedUpgradeStage
+	for _, stage := range AllStages {
+		if t, err := Lookup(state, stage); err == nil && t.ChangesState() {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// NextStagesFor returns the stages that can be requested to move the IBU forward from its current state
+func NextStagesFor(ibu *ranv1alpha1.ImageBasedUpgrade) []ranv1alpha1.ImageBasedUpgradeStage {
+	var stages []ranv1alpha1.ImageBasedUpgradeStage
+	for _, stage := range AllStages {
+		if t, err := LookupFor(ibu, stage); err == nil && t.ChangesState() {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// ActiveStage returns the stage whose handler must keep running in the given state, or "" if none
+func ActiveStage(state State) ranv1alpha1.ImageBasedUpgradeStage {
+	switch state {
+	case States.PrepInProgress:
+		return ranv1alpha1.Stages.Prep
+	case States.UpgradeInProgress:
+		return ranv1alpha1.Stages.Upgrade
+	case States.RollbackInProgress:
+		return ranv1alpha1.Stages.Rollback
+	case States.Aborting, States.AbortFailed, States.Finalizing, States.FinalizeFailed:
+		return ranv1alpha1.Stages.Idle
+	}
+	return ""
+}
+
+// isSet returns true if the condition exists and was not written by a rejected transition
+func isSet(condition *metav1.Condition) bool {
+	return condition != nil && condition.Reason != string(utils.ConditionReasons.InvalidTransition)
+}
+
+// stageState derives the state of a single stage from its in progress and completed conditions
+func stageState(conditions []metav1.Condition, stage ranv1alpha1.ImageBasedUpgradeStage, inProgress, completed, failed State) State {
+	inProgressCondition := meta.FindStatusCondition(conditions, string(utils.GetInProgressConditionType(stage)))
+	completedCondition := meta.FindStatusCondition(conditions, string(utils.GetCompletedConditionType(stage)))
+	if isSet(completedCondition) {
+		if completedCondition.Status == metav1.ConditionTrue {
+			return completed
+		}
+		return failed
+	}
+	if isSet(inProgressCondition) {
+		if inProgressCondition.Status == metav1.ConditionTrue {
+			return inProgress
+		}
+		return failed
+	}
+	return ""
+}
+
+// GetState derives the current state from the IBU status conditions
+func GetState(conditions []metav1.Condition) State {
+	idleCondition := meta.FindStatusCondition(conditions, string(utils.ConditionTypes.Idle))
+	if idleCondition == nil || idleCondition.Status == metav1.ConditionTrue {
+		return States.Idle
+	}
+
+	switch utils.ConditionReason(idleCondition.Reason) {
+	case utils.ConditionReasons.Aborting:
+		return States.Aborting
+	case utils.ConditionReasons.AbortFailed:
+		return States.AbortFailed
+	case utils.ConditionReasons.Finalizing:
+		return States.Finalizing
+	case utils.ConditionReasons.FinalizeFailed:
+		return States.FinalizeFailed
+	}
+
+	// Later stages take precedence since their conditions are added on top of the earlier ones
+	if state := stageState(conditions, ranv1alpha1.Stages.Rollback,
+		States.RollbackInProgress, States.RollbackCompleted, States.RollbackFailed); state != "" {
+		return state
+	}
+	if state := stageState(conditions, ranv1alpha1.Stages.Upgrade,
+		States.UpgradeInProgress, States.UpgradeCompleted, States.UpgradeFailed); state != "" {
+		return state
+	}
+	if state := stageState(conditions, ranv1alpha1.Stages.Prep,
+		States.PrepInProgress, States.PrepCompleted, States.PrepFailed); state != "" {
+		return state
+	}
+	return States.Idle
+}
+
+// Apply sets the conditions of the transition on the IBU
+func Apply(ibu *ranv1alpha1.ImageBasedUpgrade, t Transition) {
+	if t.Action == Actions.Reset {
+		utils.ResetStatusConditions(&ibu.Status.Conditions, ibu.Generation)
+		return
+	}
+	for _, c := range t.Conditions {
+		utils.SetStatusCondition(&ibu.Status.Conditions, c.Type, c.Reason, c.Status, c.Message, ibu.Generation)
+	}
+}
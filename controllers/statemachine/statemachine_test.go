@@ -0,0 +1,252 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statemachine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+func condition(conditionType utils.ConditionType, reason utils.ConditionReason, status metav1.ConditionStatus) metav1.Condition {
+	return metav1.Condition{Type: string(conditionType), Reason: string(reason), Status: status}
+}
+
+var (
+	idleTrue        = condition(utils.ConditionTypes.Idle, utils.ConditionReasons.Idle, metav1.ConditionTrue)
+	idleInProgress  = condition(utils.ConditionTypes.Idle, utils.ConditionReasons.InProgress, metav1.ConditionFalse)
+	prepRunning     = condition(utils.ConditionTypes.PrepInProgress, utils.ConditionReasons.InProgress, metav1.ConditionTrue)
+	prepDone        = condition(utils.ConditionTypes.PrepInProgress, utils.ConditionReasons.Completed, metav1.ConditionFalse)
+	prepCompleted   = condition(utils.ConditionTypes.PrepCompleted, utils.ConditionReasons.Completed, metav1.ConditionTrue)
+	prepFailed      = condition(utils.ConditionTypes.PrepCompleted, utils.ConditionReasons.Failed, metav1.ConditionFalse)
+	upgradeRunning  = condition(utils.ConditionTypes.UpgradeInProgress, utils.ConditionReasons.InProgress, metav1.ConditionTrue)
+	upgradeDone     = condition(utils.ConditionTypes.UpgradeInProgress, utils.ConditionReasons.Completed, metav1.ConditionFalse)
+	upgradeComplete = condition(utils.ConditionTypes.UpgradeCompleted, utils.ConditionReasons.Completed, metav1.ConditionTrue)
+	upgradeFailed   = condition(utils.ConditionTypes.UpgradeCompleted, utils.ConditionReasons.Failed, metav1.ConditionFalse)
+	rollbackRunning = condition(utils.ConditionTypes.RollbackInProgress, utils.ConditionReasons.InProgress, metav1.ConditionTrue)
+	rollbackDone    = condition(utils.ConditionTypes.RollbackInProgress, utils.ConditionReasons.Completed, metav1.ConditionFalse)
+	rollbackOK      = condition(utils.ConditionTypes.RollbackCompleted, utils.ConditionReasons.Completed, metav1.ConditionTrue)
+	rollbackFailed  = condition(utils.ConditionTypes.RollbackCompleted, utils.ConditionReasons.Failed, metav1.ConditionFalse)
+)
+
+// conditionsFor returns a representative set of conditions for each state
+func conditionsFor(state State) []metav1.Condition {
+	switch state {
+	case States.Idle:
+		return []metav1.Condition{idleTrue}
+	case States.PrepInProgress:
+		return []metav1.Condition{idleInProgress, prepRunning}
+	case States.PrepCompleted:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted}
+	case States.PrepFailed:
+		return []metav1.Condition{idleInProgress, prepDone, prepFailed}
+	case States.UpgradeInProgress:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted, upgradeRunning}
+	case States.UpgradeCompleted:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted, upgradeDone, upgradeComplete}
+	case States.UpgradeFailed:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted, upgradeDone, upgradeFailed}
+	case States.RollbackInProgress:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted, upgradeDone, upgradeFailed, rollbackRunning}
+	case States.RollbackCompleted:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted, upgradeDone, upgradeFailed, rollbackDone, rollbackOK}
+	case States.RollbackFailed:
+		return []metav1.Condition{idleInProgress, prepDone, prepCompleted, upgradeDone, upgradeFailed, rollbackDone, rollbackFailed}
+	case States.Aborting:
+		return []metav1.Condition{condition(utils.ConditionTypes.Idle, utils.ConditionReasons.Aborting, metav1.ConditionFalse), prepRunning}
+	case States.AbortFailed:
+		return []metav1.Condition{condition(utils.ConditionTypes.Idle, utils.ConditionReasons.AbortFailed, metav1.ConditionFalse), prepRunning}
+	case States.Finalizing:
+		return []metav1.Condition{condition(utils.ConditionTypes.Idle, utils.ConditionReasons.Finalizing, metav1.ConditionFalse), upgradeComplete}
+	case States.FinalizeFailed:
+		return []metav1.Condition{condition(utils.ConditionTypes.Idle, utils.ConditionReasons.FinalizeFailed, metav1.ConditionFalse), upgradeComplete}
+	}
+	return nil
+}
+
+func TestGetState(t *testing.T) {
+	assert.Equal(t, States.Idle, GetState(nil), "an IBU without conditions is idle")
+	for _, state := range AllStates {
+		assert.Equal(t, state, GetState(conditionsFor(state)))
+	}
+}
+
+func TestTransitionsAreExhaustive(t *testing.T) {
+	assert.Len(t, Transitions, len(AllStates)*len(AllStages))
+	for _, state := range AllStates {
+		for _, stage := range AllStages {
+			_, err := Lookup(state, stage)
+			assert.NoError(t, err, "state %s stage %s", state, stage)
+		}
+	}
+	_, err := Lookup(States.Idle, "Unknown")
+	assert.Error(t, err)
+}
+
+func TestEveryTransition(t *testing.T) {
+	for _, transition := range Transitions {
+		transition := transition
+		t.Run(string(transition.From)+"/"+string(transition.Desired), func(t *testing.T) {
+			ibu := &ranv1alpha1.ImageBasedUpgrade{}
+			ibu.Status.Conditions = conditionsFor(transition.From)
+			Apply(ibu, transition)
+			next := GetState(ibu.Status.Conditions)
+
+			switch transition.Action {
+			case Actions.None, Actions.Reject:
+				assert.Equal(t, transition.From, next, "state must not change")
+				assert.False(t, transition.RunsHandler())
+			default:
+				assert.Equal(t, targetState(transition), next)
+			}
+
+			if transition.Action == Actions.Reject {
+				assert.NotEmpty(t, transition.Message())
+				assert.Len(t, transition.Conditions, 1)
+				assert.Equal(t, utils.ConditionReasons.InvalidTransition, transition.Conditions[0].Reason)
+			}
+			if transition.Action != Actions.None && transition.Action != Actions.Reject && transition.Action != Actions.Reset {
+				assert.True(t, transition.RunsHandler())
+			}
+		})
+	}
+}
+
+func TestActiveStage(t *testing.T) {
+	for _, state := range AllStates {
+		stage := ActiveStage(state)
+		if stage == "" {
+			continue
+		}
+		// The active stage must be a no-op when requested again, so the handler keeps running
+		transition, err := Lookup(state, stage)
+		assert.NoError(t, err)
+		assert.Equal(t, Actions.None, transition.Action, "state %s", state)
+	}
+}
+
+func TestNextStages(t *testing.T) {
+	assert.Equal(t, []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Prep}, NextStages(States.Idle))
+	assert.Equal(t, []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Idle, ranv1alpha1.Stages.Upgrade},
+		NextStages(States.PrepCompleted))
+	assert.Equal(t, []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Idle, ranv1alpha1.Stages.Rollback},
+		NextStages(States.UpgradeCompleted))
+	assert.Empty(t, NextStages(States.Aborting))
+}
+
+func TestLookupForPivot(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-time.Hour))
+	before := metav1.NewTime(started.Add(-time.Minute))
+	after := metav1.NewTime(started.Add(time.Minute))
+
+	testcases := []struct {
+		name       string
+		state      State
+		pivotedAt  *metav1.Time
+		desired    ranv1alpha1.ImageBasedUpgradeStage
+		action     Action
+		nextStages []ranv1alpha1.ImageBasedUpgradeStage
+	}{
+		{
+			name:       "abort before the pivot",
+			state:      States.UpgradeInProgress,
+			desired:    ranv1alpha1.Stages.Idle,
+			action:     Actions.Abort,
+			nextStages: []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Idle, ranv1alpha1.Stages.Rollback},
+		},
+		{
+			name:       "abort after the pivot",
+			state:      States.UpgradeInProgress,
+			pivotedAt:  &after,
+			desired:    ranv1alpha1.Stages.Idle,
+			action:     Actions.Reject,
+			nextStages: []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Rollback},
+		},
+		{
+			name:       "abort after the pivot of a previous run",
+			state:      States.UpgradeInProgress,
+			pivotedAt:  &before,
+			desired:    ranv1alpha1.Stages.Idle,
+			action:     Actions.Abort,
+			nextStages: []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Idle, ranv1alpha1.Stages.Rollback},
+		},
+		{
+			name:       "rollback after the pivot",
+			state:      States.UpgradeInProgress,
+			pivotedAt:  &after,
+			desired:    ranv1alpha1.Stages.Rollback,
+			action:     Actions.StartRollback,
+			nextStages: []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Rollback},
+		},
+		{
+			name:       "finalize after the pivot",
+			state:      States.UpgradeCompleted,
+			pivotedAt:  &after,
+			desired:    ranv1alpha1.Stages.Idle,
+			action:     Actions.Finalize,
+			nextStages: []ranv1alpha1.ImageBasedUpgradeStage{ranv1alpha1.Stages.Idle, ranv1alpha1.Stages.Rollback},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ibu := &ranv1alpha1.ImageBasedUpgrade{}
+			ibu.Status.Conditions = conditionsFor(tc.state)
+			ibu.Status.Progress = &ranv1alpha1.StageRun{Name: string(ranv1alpha1.Stages.Upgrade), StartedAt: started}
+			ibu.Status.PivotedAt = tc.pivotedAt
+
+			transition, err := LookupFor(ibu, tc.desired)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.action, transition.Action)
+			assert.Equal(t, tc.nextStages, NextStagesFor(ibu))
+
+			Apply(ibu, transition)
+			assert.Equal(t, targetState(transition), GetState(ibu.Status.Conditions))
+		})
+	}
+}
+
+func TestRejectedTransitionIsIgnoredByGetState(t *testing.T) {
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	ibu.Status.Conditions = conditionsFor(States.Idle)
+	transition, _ := Lookup(States.Idle, ranv1alpha1.Stages.Upgrade)
+	Apply(ibu, transition)
+	assert.Equal(t, States.Idle, GetState(ibu.Status.Conditions))
+
+	transition, _ = Lookup(States.Idle, ranv1alpha1.Stages.Prep)
+	Apply(ibu, transition)
+	assert.Equal(t, States.PrepInProgress, GetState(ibu.Status.Conditions))
+}
+
+func TestRender(t *testing.T) {
+	dot := Dot()
+	assert.True(t, strings.HasPrefix(dot, "digraph ImageBasedUpgrade {"))
+	assert.Contains(t, dot, `Idle -> PrepInProgress [label="stage=Prep"];`)
+	assert.Contains(t, dot, `UpgradeCompleted -> Finalizing [label="stage=Idle"];`)
+	assert.NotContains(t, dot, "Idle -> Idle")
+
+	mermaid := Mermaid()
+	assert.True(t, strings.HasPrefix(mermaid, "stateDiagram-v2\n"))
+	assert.Contains(t, mermaid, "PrepCompleted --> UpgradeInProgress: stage=Upgrade")
+	assert.Contains(t, mermaid, "Aborting --> Idle: completed")
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+// staterootMetadata is written into every stateroot the operator knows the release of, so the
+// release installed in a stateroot that is not booted can still be reported
+type staterootMetadata struct {
+	Version      string `json:"version,omitempty"`
+	ReleaseImage string `json:"releaseImage,omitempty"`
+}
+
+// staterootUsageRefresh is how long the disk usage of a stateroot is reported before it is measured again, the
+// /var of the booted stateroot grows while its deployment stays the same
+var staterootUsageRefresh = time.Hour
+
+// staterootUsage is the measured disk usage of a stateroot and the deployment it was measured for
+type staterootUsage struct {
+	checksum   string
+	bytes      int64
+	measuredAt time.Time
+}
+
+var clusterVersionGVK = schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "ClusterVersion"}
+
+// staterootDir returns the path of a stateroot directory as seen from the operator container
+func staterootDir(stateroot string) string {
+	return filepath.Join(utils.HostPath, utils.OstreeDeployPath, stateroot)
+}
+
+func staterootLCADir(stateroot string) string {
+	return filepath.Join(staterootDir(stateroot), utils.LCAVarDir)
+}
+
+// updateStateRoots discovers the ostree stateroots on the node and reports them in the status
+func (r *ImageBasedUpgradeReconciler) updateStateRoots(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	deployments, err := r.OstreeClient.QueryDeployments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to query ostree deployments: %w", err)
+	}
+	if len(deployments) == 0 {
+		return fmt.Errorf("no ostree deployments found")
+	}
+
+	var stateRoots []ranv1alpha1.StateRoot
+	for _, name := range staterootNames(deployments) {
+		stateRoot := r.buildStateRoot(ctx, name, deployments)
+		stateRoots = append(stateRoots, stateRoot)
+	}
+	ibu.Status.StateRoots = stateRoots
+	return nil
+}
+
+// staterootNames returns the distinct stateroots in deployment order
+func staterootNames(deployments []ostreeclient.Deployment) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, d := range deployments {
+		if !seen[d.OSName] {
+			seen[d.OSName] = true
+			names = append(names, d.OSName)
+		}
+	}
+	return names
+}
+
+func (r *ImageBasedUpgradeReconciler) buildStateRoot(ctx context.Context, name string, deployments []ostreeclient.Deployment) ranv1alpha1.StateRoot {
+	stateRoot := ranv1alpha1.StateRoot{
+		Name: name,
+		// The first deployment is the one the bootloader picks by default
+		Default: deployments[0].OSName == name,
+	}
+
+	var current *ostreeclient.Deployment
+	for i := range deployments {
+		d := &deployments[i]
+		if d.OSName != name {
+			continue
+		}
+		stateRoot.Pinned = stateRoot.Pinned || d.Pinned
+		if d.Booted {
+			stateRoot.Booted = true
+			current = d
+		} else if current == nil {
+			current = d
+		}
+	}
+	stateRoot.DeploymentChecksum = current.Checksum
+	stateRoot.CreatedAt = metav1.NewTime(time.Unix(current.Timestamp, 0))
+
+	metadata, err := r.getStaterootMetadata(ctx, name, stateRoot.Booted)
+	if err != nil {
+		r.Log.Error(err, "Failed to get stateroot release", "stateroot", name)
+	}
+	stateRoot.Version = metadata.Version
+	stateRoot.ReleaseImage = metadata.ReleaseImage
+
+	if stateRoot.DiskUsageBytes, err = r.staterootDiskUsage(ctx, name, current.Checksum); err != nil {
+		r.Log.Error(err, "Failed to get stateroot disk usage", "stateroot", name)
+	}
+	if stateRoot.SavedStates, err = listSavedStates(name); err != nil {
+		r.Log.Error(err, "Failed to list stateroot saved states", "stateroot", name)
+	}
+	return stateRoot
+}
+
+// getStaterootMetadata reads the release recorded in the stateroot. The booted stateroot records the
+// release the cluster runs, kept up to date while it is booted as the cluster can be updated in place.
+func (r *ImageBasedUpgradeReconciler) getStaterootMetadata(ctx context.Context, stateroot string, booted bool) (staterootMetadata, error) {
+	metadata := staterootMetadata{}
+	path := filepath.Join(staterootLCADir(stateroot), utils.StaterootMetadataFile)
+	content, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(content, &metadata); err != nil && !booted {
+			return metadata, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) || !booted {
+		return metadata, err
+	}
+	if !booted {
+		return metadata, nil
+	}
+
+	running, err := r.getClusterRelease(ctx)
+	if err != nil {
+		return metadata, err
+	}
+	if running == metadata {
+		return metadata, nil
+	}
+	return running, writeStaterootMetadata(stateroot, running)
+}
+
+func writeStaterootMetadata(stateroot string, metadata staterootMetadata) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stateroot metadata: %w", err)
+	}
+	dir := staterootLCADir(stateroot)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return os.WriteFile(filepath.Join(dir, utils.StaterootMetadataFile), content, 0o600)
+}
+
+// getClusterRelease returns the release the cluster is running, the latest one of the ClusterVersion history that
+// completed. The desired release is only the target of an update that may still be in progress.
+func (r *ImageBasedUpgradeReconciler) getClusterRelease(ctx context.Context) (staterootMetadata, error) {
+	clusterVersion := &unstructured.Unstructured{}
+	clusterVersion.SetGroupVersionKind(clusterVersionGVK)
+	if err := r.Get(ctx, types.NamespacedName{Name: "version"}, clusterVersion); err != nil {
+		return staterootMetadata{}, fmt.Errorf("failed to get ClusterVersion: %w", err)
+	}
+	// The history is ordered from the most recent update
+	history, _, _ := unstructured.NestedSlice(clusterVersion.Object, "status", "history")
+	for _, entry := range history {
+		update, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if state, _, _ := unstructured.NestedString(update, "state"); state != "Completed" {
+			continue
+		}
+		version, _, _ := unstructured.NestedString(update, "version")
+		image, _, _ := unstructured.NestedString(update, "image")
+		return staterootMetadata{Version: version, ReleaseImage: image}, nil
+	}
+	return staterootMetadata{}, fmt.Errorf("no completed update in the ClusterVersion history")
+}
+
+// staterootDiskUsage returns the bytes used by a stateroot, measured again only once its deployment changed or the
+// last measure is older than staterootUsageRefresh, as du walks the whole stateroot
+func (r *ImageBasedUpgradeReconciler) staterootDiskUsage(ctx context.Context, stateroot, checksum string) (int64, error) {
+	if cached, ok := r.staterootUsages[stateroot]; ok && cached.checksum == checksum && time.Since(cached.measuredAt) < staterootUsageRefresh {
+		return cached.bytes, nil
+	}
+	size, err := r.getDiskUsage(ctx, filepath.Join(utils.OstreeDeployPath, stateroot))
+	if err != nil {
+		return 0, err
+	}
+	if r.staterootUsages == nil {
+		r.staterootUsages = map[string]staterootUsage{}
+	}
+	r.staterootUsages[stateroot] = staterootUsage{checksum: checksum, bytes: size, measuredAt: time.Now()}
+	return size, nil
+}
+
+// getDiskUsage returns the bytes used by a host path. Files hardlinked into the ostree repo are included.
+func (r *ImageBasedUpgradeReconciler) getDiskUsage(ctx context.Context, path string) (int64, error) {
+	output, err := r.Executor.Execute(ctx, "du", "--summarize", "--bytes", path)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected du output %q", output)
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected du output %q: %w", output, err)
+	}
+	return size, nil
+}
+
+func listSavedStates(stateroot string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(staterootLCADir(stateroot), utils.SavedStatesDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+// testClusterVersion is the ClusterVersion of a cluster running the given release, the update to it completed
+func testClusterVersion(version string) *unstructured.Unstructured {
+	clusterVersion := &unstructured.Unstructured{}
+	clusterVersion.SetGroupVersionKind(clusterVersionGVK)
+	clusterVersion.SetName("version")
+	_ = unstructured.SetNestedSlice(clusterVersion.Object, []interface{}{
+		map[string]interface{}{"state": "Completed", "version": version, "image": "quay.io/release:" + version},
+	}, "status", "history")
+	return clusterVersion
+}
+
+func TestUpdateStateRoots(t *testing.T) {
+	hostPath := t.TempDir()
+	defer func(old string) { utils.HostPath = old }(utils.HostPath)
+	utils.HostPath = hostPath
+
+	// The new stateroot already records its release and has a saved state
+	newLCADir := filepath.Join(hostPath, utils.OstreeDeployPath, "rhcos_4.14.1", utils.LCAVarDir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(newLCADir, utils.SavedStatesDir, "pods"), 0o700))
+	content, _ := json.Marshal(staterootMetadata{Version: "4.14.1", ReleaseImage: "quay.io/release:4.14.1"})
+	assert.NoError(t, os.WriteFile(filepath.Join(newLCADir, utils.StaterootMetadataFile), content, 0o600))
+
+	// An update to 4.13.6 is in progress, the cluster still runs 4.13.5
+	clusterVersion := testClusterVersion("4.13.5")
+	assert.NoError(t, unstructured.SetNestedField(clusterVersion.Object, "4.13.6", "status", "desired", "version"))
+	history, _, _ := unstructured.NestedSlice(clusterVersion.Object, "status", "history")
+	history = append([]interface{}{map[string]interface{}{"state": "Partial", "version": "4.13.6", "image": "quay.io/release:4.13.6"}}, history...)
+	assert.NoError(t, unstructured.SetNestedSlice(clusterVersion.Object, history, "status", "history"))
+	fakeClient, _ := getFakeClientFromObjects(clusterVersion)
+
+	executor := &ops.MockExecutor{Results: map[string]ops.Result{
+		"du --summarize --bytes /ostree/deploy/rhcos":        {Stdout: "1024\t/ostree/deploy/rhcos"},
+		"du --summarize --bytes /ostree/deploy/rhcos_4.14.1": {Stdout: "2048\t/ostree/deploy/rhcos_4.14.1"},
+	}}
+	r := &ImageBasedUpgradeReconciler{
+		Client:   fakeClient,
+		Recorder: record.NewFakeRecorder(100),
+		Log:      logr.Discard(),
+		Executor: executor,
+		OstreeClient: ostreeclient.NewFakeClient(
+			ostreeclient.Deployment{OSName: "rhcos_4.14.1", Checksum: "bbb", Timestamp: 200, Staged: true},
+			ostreeclient.Deployment{OSName: "rhcos", Checksum: "aaa", Timestamp: 100, Booted: true, Pinned: true},
+			ostreeclient.Deployment{OSName: "rhcos", Checksum: "000", Timestamp: 50},
+		),
+	}
+
+	ibu := &ranv1alpha1.ImageBasedUpgrade{}
+	assert.NoError(t, r.updateStateRoots(context.TODO(), ibu))
+	assert.Len(t, ibu.Status.StateRoots, 2)
+
+	next := ibu.Status.StateRoots[0]
+	assert.Equal(t, "rhcos_4.14.1", next.Name)
+	assert.True(t, next.Default)
+	assert.False(t, next.Booted)
+	assert.Equal(t, "4.14.1", next.Version)
+	assert.Equal(t, "quay.io/release:4.14.1", next.ReleaseImage)
+	assert.Equal(t, int64(2048), next.DiskUsageBytes)
+	assert.Equal(t, []string{"pods"}, next.SavedStates)
+
+	booted := ibu.Status.StateRoots[1]
+	assert.Equal(t, "rhcos", booted.Name)
+	assert.False(t, booted.Default)
+	assert.True(t, booted.Booted)
+	assert.True(t, booted.Pinned)
+	assert.Equal(t, "aaa", booted.DeploymentChecksum)
+	assert.Equal(t, int64(100), booted.CreatedAt.Unix())
+	assert.Equal(t, "4.13.5", booted.Version)
+	assert.Equal(t, int64(1024), booted.DiskUsageBytes)
+	assert.Empty(t, booted.SavedStates)
+
+	// The release of the booted stateroot is recorded for when it is no longer booted
+	bootedMetadata := filepath.Join(hostPath, utils.OstreeDeployPath, "rhcos", utils.LCAVarDir, utils.StaterootMetadataFile)
+	content, err := os.ReadFile(bootedMetadata)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"version":"4.13.5","releaseImage":"quay.io/release:4.13.5"}`, string(content))
+
+	// and kept up to date once the update in progress completed
+	assert.NoError(t, fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(clusterVersion), clusterVersion))
+	history[0].(map[string]interface{})["state"] = "Completed"
+	assert.NoError(t, unstructured.SetNestedSlice(clusterVersion.Object, history, "status", "history"))
+	assert.NoError(t, fakeClient.Status().Update(context.TODO(), clusterVersion))
+	assert.NoError(t, r.updateStateRoots(context.TODO(), ibu))
+	assert.Equal(t, "4.13.6", ibu.Status.StateRoots[1].Version)
+	assert.Equal(t, "quay.io/release:4.13.6", ibu.Status.StateRoots[1].ReleaseImage)
+	content, err = os.ReadFile(bootedMetadata)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"version":"4.13.6","releaseImage":"quay.io/release:4.13.6"}`, string(content))
+}
+
+func TestStaterootDiskUsageCache(t *testing.T) {
+	defer func(old time.Duration) { staterootUsageRefresh = old }(staterootUsageRefresh)
+	executor := &ops.MockExecutor{Results: map[string]ops.Result{
+		"du --summarize --bytes /ostree/deploy/rhcos": {Stdout: "1024\t/ostree/deploy/rhcos"},
+	}}
+	r := &ImageBasedUpgradeReconciler{Log: logr.Discard(), Executor: executor}
+	du := func() int {
+		count := 0
+		for _, line := range executor.Lines() {
+			if strings.HasPrefix(line, "du ") {
+				count++
+			}
+		}
+		return count
+	}
+
+	for i := 0; i < 3; i++ {
+		size, err := r.staterootDiskUsage(context.TODO(), "rhcos", "aaa")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1024), size)
+	}
+	assert.Equal(t, 1, du(), "the usage of an unchanged deployment is measured once")
+
+	_, _ = r.staterootDiskUsage(context.TODO(), "rhcos", "bbb")
+	assert.Equal(t, 2, du(), "a new deployment is measured")
+
+	staterootUsageRefresh = 0
+	_, _ = r.staterootDiskUsage(context.TODO(), "rhcos", "bbb")
+	assert.Equal(t, 3, du(), "an old measure is refreshed")
+}
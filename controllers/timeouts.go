@@ -0,0 +1,198 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+// runName returns the name of the stage run that is in progress in a state, or empty if none is
+func runName(state statemachine.State) string {
+	switch state {
+	case statemachine.States.PrepInProgress:
+		return string(ranv1alpha1.Stages.Prep)
+	case statemachine.States.UpgradeInProgress:
+		return string(ranv1alpha1.Stages.Upgrade)
+	case statemachine.States.RollbackInProgress:
+		return string(ranv1alpha1.Stages.Rollback)
+	case statemachine.States.Aborting:
+		return abortRun
+	case statemachine.States.Finalizing:
+		return finalizeRun
+	}
+	return ""
+}
+
+// timeouts returns the operator default timeouts overridden by the ones set in the spec
+func (r *ImageBasedUpgradeReconciler) timeouts(ibu *ranv1alpha1.ImageBasedUpgrade) ranv1alpha1.StageTimeouts {
+	timeouts := *r.DefaultTimeouts.DeepCopy()
+	spec := ibu.Spec.Timeouts
+	if spec.Prep != nil {
+		timeouts.Prep = spec.Prep
+	}
+	if spec.Upgrade != nil {
+		timeouts.Upgrade = spec.Upgrade
+	}
+	if spec.Rollback != nil {
+		timeouts.Rollback = spec.Rollback
+	}
+	if spec.Abort != nil {
+		timeouts.Abort = spec.Abort
+	}
+	if spec.Finalize != nil {
+		timeouts.Finalize = spec.Finalize
+	}
+	if spec.OnTimeout != "" {
+		timeouts.OnTimeout = spec.OnTimeout
+	}
+	return timeouts
+}
+
+// runTimeout returns the name and timeout of the stage run in progress. The timeout is zero
+// when no run is in progress or the run has no timeout.
+func (r *ImageBasedUpgradeReconciler) runTimeout(ibu *ranv1alpha1.ImageBasedUpgrade) (string, time.Duration) {
+	name := runName(statemachine.GetState(ibu.Status.Conditions))
+	run := ibu.Status.Progress
+	if name == "" || run == nil || run.Name != name || run.Outcome != ranv1alpha1.StageRunOutcomes.InProgress {
+		return name, 0
+	}
+
+	timeouts := r.timeouts(ibu)
+	var timeout *metav1.Duration
+	switch name {
+	case string(ranv1alpha1.Stages.Prep):
+		timeout = timeouts.Prep
+	case string(ranv1alpha1.Stages.Upgrade):
+		timeout = timeouts.Upgrade
+	case string(ranv1alpha1.Stages.Rollback):
+		timeout = timeouts.Rollback
+	case abortRun:
+		timeout = timeouts.Abort
+	case finalizeRun:
+		timeout = timeouts.Finalize
+	}
+	if timeout == nil {
+		return name, 0
+	}
+	return name, timeout.Duration
+}
+
+// handleStageWithTimeout runs the stage handler with a context that is cancelled when the run
+// in progress exceeds its timeout, and times the run out when it does
+func (r *ImageBasedUpgradeReconciler) handleStageWithTimeout(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, stage ranv1alpha1.ImageBasedUpgradeStage) (ctrl.Result, error) {
+	name, timeout := r.runTimeout(ibu)
+	if timeout <= 0 {
+		return r.handleStage(ctx, ibu, stage)
+	}
+
+	deadline := ibu.Status.Progress.StartedAt.Add(timeout)
+	if !time.Now().Before(deadline) {
+		return r.handleTimeout(ctx, ibu, name, timeout)
+	}
+	stageCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	nextReconcile, err := r.handleStage(stageCtx, ibu, stage)
+	if stageCtx.Err() == context.DeadlineExceeded {
+		return r.handleTimeout(ctx, ibu, name, timeout)
+	}
+
+	// Come back in time to notice the timeout even if the handler waits for an event
+	if _, stillRunning := r.runTimeout(ibu); stillRunning > 0 {
+		remaining := time.Until(deadline)
+		if (nextReconcile.RequeueAfter == 0 && !nextReconcile.Requeue) || nextReconcile.RequeueAfter > remaining {
+			nextReconcile = requeueWithCustomInterval(remaining)
+		}
+	}
+	return nextReconcile, err
+}
+
+// handleTimeout fails the run that exceeded its timeout and takes the configured follow-up action
+func (r *ImageBasedUpgradeReconciler) handleTimeout(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade, name string, timeout time.Duration) (ctrl.Result, error) {
+	message := fmt.Sprintf("%s timed out after %s", name, timeout)
+	r.Log.Info("Stage timed out", "run", name, "timeout", timeout)
+	r.recordEvent(ibu, corev1.EventTypeWarning, utils.ConditionReasons.TimedOut, message)
+	r.stopPrecache()
+	r.stopSeedPull()
+
+	// The handler may already have failed the run when its work got cancelled
+	if run := ibu.Status.Progress; run != nil && run.Name == name {
+		now := metav1.Now()
+		run.Outcome = ranv1alpha1.StageRunOutcomes.TimedOut
+		run.Message = message
+		if run.CompletedAt == nil {
+			run.CompletedAt = &now
+		}
+		for i := range run.Steps {
+			if run.Steps[i].State == ranv1alpha1.StepStates.Running {
+				run.Steps[i].State = ranv1alpha1.StepStates.Failed
+				run.Steps[i].CompletedAt = &now
+				run.Steps[i].Message = message
+			}
+		}
+		updateDurations(run)
+		ibu.Status.CompletedAt = *run.CompletedAt
+	}
+
+	switch name {
+	case abortRun:
+		// Abort and finalize have no condition of their own, the idle reason carries their state
+		utils.SetStatusCondition(&ibu.Status.Conditions,
+			utils.ConditionTypes.Idle, utils.ConditionReasons.AbortFailed, metav1.ConditionFalse, message, ibu.Generation)
+		return doNotRequeue(), nil
+	case finalizeRun:
+		utils.SetStatusCondition(&ibu.Status.Conditions,
+			utils.ConditionTypes.Idle, utils.ConditionReasons.FinalizeFailed, metav1.ConditionFalse, message, ibu.Generation)
+		return doNotRequeue(), nil
+	}
+
+	stage := ranv1alpha1.ImageBasedUpgradeStage(name)
+	utils.SetStatusCondition(&ibu.Status.Conditions,
+		utils.GetCompletedConditionType(stage), utils.ConditionReasons.TimedOut, metav1.ConditionFalse, message, ibu.Generation)
+	utils.SetStatusCondition(&ibu.Status.Conditions,
+		utils.GetInProgressConditionType(stage), utils.ConditionReasons.TimedOut, metav1.ConditionFalse, message, ibu.Generation)
+	if stage == ranv1alpha1.Stages.Upgrade && ibu.Spec.AutoRollback.Enabled {
+		return doNotRequeue(), r.autoRollback(ctx, ibu, ranv1alpha1.AutoRollbackTriggers.UpgradeTimedOut, message)
+	}
+	return doNotRequeue(), r.takeTimeoutAction(ctx, ibu)
+}
+
+// takeTimeoutAction requests the stage configured to follow a timeout, if the state machine allows it
+func (r *ImageBasedUpgradeReconciler) takeTimeoutAction(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	action := r.timeouts(ibu).OnTimeout
+	var stage ranv1alpha1.ImageBasedUpgradeStage
+	switch action {
+	case ranv1alpha1.TimeoutActions.Abort:
+		stage = ranv1alpha1.Stages.Idle
+	case ranv1alpha1.TimeoutActions.Rollback:
+		stage = ranv1alpha1.Stages.Rollback
+	default:
+		return nil
+	}
+
+	_, err := r.requestStage(ctx, ibu, stage)
+	return err
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+)
+
+// runningIBU returns an IBU whose stage started running the given time ago
+func runningIBU(stage ranv1alpha1.ImageBasedUpgradeStage, since time.Duration, conditions ...metav1.Condition) *ranv1alpha1.ImageBasedUpgrade {
+	return &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: utils.IBUName, Namespace: lcaNs},
+		Spec:       ranv1alpha1.ImageBasedUpgradeSpec{Stage: stage, SeedImageRef: testSeedImageRef},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{
+			Conditions: conditions,
+			Progress: &ranv1alpha1.StageRun{
+				Name:      string(stage),
+				Outcome:   ranv1alpha1.StageRunOutcomes.InProgress,
+				StartedAt: metav1.NewTime(time.Now().Add(-since)),
+				Steps:     []ranv1alpha1.Step{{Name: "slow", State: ranv1alpha1.StepStates.Running}},
+			},
+		},
+	}
+}
+
+func TestStageTimeouts(t *testing.T) {
+	roomyFilesystems(t)
+	notIdle := metav1.Condition{Type: string(utils.ConditionTypes.Idle), Reason: string(utils.ConditionReasons.InProgress), Status: metav1.ConditionFalse}
+	prepRunning := metav1.Condition{Type: string(utils.ConditionTypes.PrepInProgress), Reason: string(utils.ConditionReasons.InProgress), Status: metav1.ConditionTrue}
+	prepCompleted := metav1.Condition{Type: string(utils.ConditionTypes.PrepCompleted), Reason: string(utils.ConditionReasons.Completed), Status: metav1.ConditionTrue}
+	upgradeRunning := metav1.Condition{Type: string(utils.ConditionTypes.UpgradeInProgress), Reason: string(utils.ConditionReasons.InProgress), Status: metav1.ConditionTrue}
+
+	testcases := []struct {
+		name         string
+		ibu          *ranv1alpha1.ImageBasedUpgrade
+		defaults     ranv1alpha1.StageTimeouts
+		validateFunc func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade)
+	}{
+		{
+			name:     "prep within its timeout",
+			ibu:      runningIBU(ranv1alpha1.Stages.Prep, time.Minute, notIdle, prepRunning),
+			defaults: ranv1alpha1.StageTimeouts{Prep: &metav1.Duration{Duration: time.Hour}},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.PrepCompleted, statemachine.GetState(ibu.Status.Conditions))
+			},
+		},
+		{
+			name:     "upgrade timed out without follow-up action",
+			ibu:      runningIBU(ranv1alpha1.Stages.Upgrade, 2*time.Hour, notIdle, prepCompleted, upgradeRunning),
+			defaults: ranv1alpha1.StageTimeouts{Upgrade: &metav1.Duration{Duration: time.Hour}},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.UpgradeFailed, statemachine.GetState(ibu.Status.Conditions))
+				inProgress := meta.FindStatusCondition(ibu.Status.Conditions, string(utils.ConditionTypes.UpgradeInProgress))
+				assert.Equal(t, metav1.ConditionFalse, inProgress.Status)
+				assert.Equal(t, string(utils.ConditionReasons.TimedOut), inProgress.Reason)
+				assert.Equal(t, "Upgrade timed out after 1h0m0s", inProgress.Message)
+				assert.Equal(t, ranv1alpha1.StageRunOutcomes.TimedOut, ibu.Status.Progress.Outcome)
+				assert.Equal(t, ranv1alpha1.StepStates.Failed, ibu.Status.Progress.Steps[0].State)
+				assert.Equal(t, ranv1alpha1.Stages.Upgrade, ibu.Spec.Stage)
+			},
+		},
+		{
+			name: "spec timeout overrides the default and rolls back",
+			ibu: func() *ranv1alpha1.ImageBasedUpgrade {
+				ibu := runningIBU(ranv1alpha1.Stages.Upgrade, 20*time.Minute, notIdle, prepCompleted, upgradeRunning)
+				ibu.Spec.Timeouts = ranv1alpha1.StageTimeouts{
+					Upgrade:   &metav1.Duration{Duration: 10 * time.Minute},
+					OnTimeout: ranv1alpha1.TimeoutActions.Rollback,
+				}
+				return ibu
+			}(),
+			defaults: ranv1alpha1.StageTimeouts{Upgrade: &metav1.Duration{Duration: time.Hour}},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, ranv1alpha1.Stages.Rollback, ibu.Spec.Stage)
+				assert.Equal(t, statemachine.States.RollbackCompleted, statemachine.GetState(ibu.Status.Conditions))
+				assert.Equal(t, ranv1alpha1.StageRunOutcomes.TimedOut, ibu.Status.History[0].Outcome)
+			},
+		},
+		{
+			name:     "prep timed out and aborted",
+			ibu:      runningIBU(ranv1alpha1.Stages.Prep, 3*time.Hour, notIdle, prepRunning),
+			defaults: ranv1alpha1.StageTimeouts{Prep: &metav1.Duration{Duration: 2 * time.Hour}, OnTimeout: ranv1alpha1.TimeoutActions.Abort},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, ranv1alpha1.Stages.Idle, ibu.Spec.Stage)
+				assert.Equal(t, statemachine.States.Idle, statemachine.GetState(ibu.Status.Conditions))
+				assert.Equal(t, "Prep", ibu.Status.History[0].Name)
+				assert.Equal(t, ranv1alpha1.StageRunOutcomes.TimedOut, ibu.Status.History[0].Outcome)
+				assert.Equal(t, abortRun, ibu.Status.Progress.Name)
+			},
+		},
+		{
+			name: "a zero spec timeout disables the default",
+			ibu: func() *ranv1alpha1.ImageBasedUpgrade {
+				ibu := runningIBU(ranv1alpha1.Stages.Prep, 3*time.Hour, notIdle, prepRunning)
+				ibu.Spec.Timeouts.Prep = &metav1.Duration{}
+				return ibu
+			}(),
+			defaults: ranv1alpha1.StageTimeouts{Prep: &metav1.Duration{Duration: time.Hour}},
+			validateFunc: func(t *testing.T, ibu *ranv1alpha1.ImageBasedUpgrade) {
+				assert.Equal(t, statemachine.States.PrepCompleted, statemachine.GetState(ibu.Status.Conditions))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClient, _ := getFakeClientFromObjects(tc.ibu)
+			r := &ImageBasedUpgradeReconciler{
+				Client:          fakeClient,
+				APIReader:       fakeClient,
+				Recorder:        record.NewFakeRecorder(100),
+				Log:             logr.Discard(),
+				Scheme:          fakeClient.Scheme(),
+				Executor:        &ops.MockExecutor{},
+				OstreeClient:    ostreeclient.NewFakeClient(ostreeclient.Deployment{OSName: "rhcos", Booted: true}),
+				SeedImageClient: &fakeSeedImageClient{},
+				DefaultTimeouts: tc.defaults,
+			}
+			key := types.NamespacedName{Name: utils.IBUName, Namespace: lcaNs}
+			_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+			assert.NoError(t, err)
+
+			ibu := &ranv1alpha1.ImageBasedUpgrade{}
+			assert.NoError(t, fakeClient.Get(context.TODO(), key, ibu))
+			tc.validateFunc(t, ibu)
+		})
+	}
+}
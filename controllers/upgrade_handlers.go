@@ -20,26 +20,18 @@ import (
 	"context"
 
 	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
-	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 func (r *ImageBasedUpgradeReconciler) handleUpgrade(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) (ctrl.Result, error) {
-
 	// TODO actual steps
-	// If completed, update conditions and return doNotRequeue
-	utils.SetStatusCondition(&ibu.Status.Conditions,
-		utils.GetCompletedConditionType(ranv1alpha1.Stages.Upgrade),
-		utils.ConditionReasons.Completed,
-		metav1.ConditionTrue,
-		"Upgrade completed",
-		ibu.Generation)
-	utils.SetStatusCondition(&ibu.Status.Conditions,
-		utils.GetInProgressConditionType(ranv1alpha1.Stages.Upgrade),
-		utils.ConditionReasons.Completed,
-		metav1.ConditionFalse,
-		"Upgrade completed",
-		ibu.Generation)
-	return doNotRequeue(), nil
+	return r.runStage(ctx, ibu, ranv1alpha1.Stages.Upgrade, []stageStep{
+		// The backups are only taken from a healthy cluster
+		{name: prePivotHealthCheckStep, run: r.prePivotHealthCheck},
+		{name: backupApplicationsStep, run: r.backupApplications},
+		// TODO pivot to the new stateroot, the steps below run once the node booted it
+		{name: applyExtraManifestsStep, run: r.applyExtraManifestsAfterPivot},
+		{name: restoreApplicationsStep, run: r.restoreApplications},
+		{name: healthCheckStep, run: r.upgradeHealthCheck},
+	})
 }
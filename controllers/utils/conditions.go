@@ -18,6 +18,7 @@ var ConditionTypes = struct {
 	UpgradeCompleted   ConditionType
 	RollbackInProgress ConditionType
 	RollbackCompleted  ConditionType
+	Ignored            ConditionType
 }{
 	Idle:               "Idle",
 	PrepInProgress:     "PrepInProgress",
@@ -26,6 +27,7 @@ var ConditionTypes = struct {
 	UpgradeCompleted:   "UpgradeCompleted",
 	RollbackInProgress: "RollbackInProgress",
 	RollbackCompleted:  "RollbackCompleted",
+	Ignored:            "Ignored",
 }
 
 var FinalConditionTypes = []ConditionType{ConditionTypes.UpgradeCompleted, ConditionTypes.RollbackCompleted}
@@ -35,31 +37,41 @@ type ConditionReason string
 
 // ConditionReasons define the different reasons that conditions will be set for
 var ConditionReasons = struct {
-	Idle              ConditionReason
-	Completed         ConditionReason
-	Failed            ConditionReason
-	TimedOut          ConditionReason
-	InProgress        ConditionReason
-	Aborting          ConditionReason
-	AbortCompleted    ConditionReason
-	AbortFailed       ConditionReason
-	Finalizing        ConditionReason
-	FinalizeCompleted ConditionReason
-	FinalizeFailed    ConditionReason
-	InvalidTransition ConditionReason
+	Idle                  ConditionReason
+	Completed             ConditionReason
+	Failed                ConditionReason
+	TimedOut              ConditionReason
+	InProgress            ConditionReason
+	Aborting              ConditionReason
+	AbortCompleted        ConditionReason
+	AbortFailed           ConditionReason
+	Finalizing            ConditionReason
+	FinalizeCompleted     ConditionReason
+	FinalizeFailed        ConditionReason
+	InvalidTransition     ConditionReason
+	NotSingleton          ConditionReason
+	SeedMismatch          ConditionReason
+	InsufficientDiskSpace ConditionReason
+	SignatureInvalid      ConditionReason
+	HealthCheckFailed     ConditionReason
 }{
-	Idle:              "Idle",
-	Completed:         "Completed",
-	Failed:            "Failed",
-	TimedOut:          "TimedOut",
-	InProgress:        "InProgress",
-	Aborting:          "Aborting",
-	AbortCompleted:    "AbortCompleted",
-	AbortFailed:       "AbortFailed",
-	Finalizing:        "Finalizing",
-	FinalizeCompleted: "FinalizeCompleted",
-	FinalizeFailed:    "FinalizeFailed",
-	InvalidTransition: "InvalidTransition",
+	Idle:                  "Idle",
+	Completed:             "Completed",
+	Failed:                "Failed",
+	TimedOut:              "TimedOut",
+	InProgress:            "InProgress",
+	Aborting:              "Aborting",
+	AbortCompleted:        "AbortCompleted",
+	AbortFailed:           "AbortFailed",
+	Finalizing:            "Finalizing",
+	FinalizeCompleted:     "FinalizeCompleted",
+	FinalizeFailed:        "FinalizeFailed",
+	InvalidTransition:     "InvalidTransition",
+	NotSingleton:          "NotSingleton",
+	SeedMismatch:          "SeedMismatch",
+	InsufficientDiskSpace: "InsufficientDiskSpace",
+	SignatureInvalid:      "SignatureInvalid",
+	HealthCheckFailed:     "HealthCheckFailed",
 }
 
 // SetStatusCondition is a convenience wrapper for meta.SetStatusCondition that takes in the types defined here and converts them to strings
@@ -102,35 +114,6 @@ func ResetStatusConditions(existingConditions *[]metav1.Condition, generation in
 	)
 }
 
-func GetCurrentInProgressStage(ibu *ranv1alpha1.ImageBasedUpgrade) ranv1alpha1.ImageBasedUpgradeStage {
-	idleCondition := meta.FindStatusCondition(ibu.Status.Conditions, string(ConditionTypes.Idle))
-	if idleCondition == nil || idleCondition.Status == metav1.ConditionTrue {
-		return ""
-	}
-
-	switch idleCondition.Reason {
-	case string(ConditionReasons.Aborting):
-	case string(ConditionReasons.AbortFailed):
-	case string(ConditionReasons.Finalizing):
-	case string(ConditionReasons.FinalizeFailed):
-		return ranv1alpha1.Stages.Idle
-	}
-
-	conditionToStageMap := map[ConditionType]ranv1alpha1.ImageBasedUpgradeStage{
-		ConditionTypes.PrepInProgress:     ranv1alpha1.Stages.Prep,
-		ConditionTypes.UpgradeInProgress:  ranv1alpha1.Stages.Upgrade,
-		ConditionTypes.RollbackInProgress: ranv1alpha1.Stages.Rollback,
-	}
-
-	for conditionType, stage := range conditionToStageMap {
-		condition := meta.FindStatusCondition(ibu.Status.Conditions, string(conditionType))
-		if condition.Status == metav1.ConditionTrue {
-			return stage
-		}
-	}
-	return ""
-}
-
 // GetInProgressConditionType returns the pending condition type based on the current stage
 func GetInProgressConditionType(stage ranv1alpha1.ImageBasedUpgradeStage) (conditionType ConditionType) {
 	switch stage {
@@ -158,20 +141,3 @@ func GetCompletedConditionType(stage ranv1alpha1.ImageBasedUpgradeStage) (condit
 	}
 	return
 }
-
-// GetPreviousCompletedCondition returns the succeeded condition for the previous stage
-func GetPreviousCompletedCondition(ibu *ranv1alpha1.ImageBasedUpgrade) *metav1.Condition {
-	var conditionType ConditionType
-	switch ibu.Spec.Stage {
-	case ranv1alpha1.Stages.Prep:
-		conditionType = ConditionTypes.Idle
-	case ranv1alpha1.Stages.Upgrade:
-		conditionType = ConditionTypes.PrepCompleted
-	case ranv1alpha1.Stages.Rollback:
-		conditionType = ConditionTypes.UpgradeCompleted
-	}
-	if conditionType != "" {
-		return meta.FindStatusCondition(ibu.Status.Conditions, string(conditionType))
-	}
-	return nil
-}
@@ -1,3 +1,37 @@
 package utils
 
 const IBUName = "upgrade"
+
+// LCANamespace is the namespace of the operator and of the ImageBasedUpgrade it manages,
+// unless overridden by the NAMESPACE environment variable
+const LCANamespace = "openshift-lifecycle-agent"
+
+// HostPath is where the host root filesystem is mounted in the operator container
+var HostPath = "/host"
+
+const (
+	// OstreeDeployPath is the directory holding the ostree stateroots on the host
+	OstreeDeployPath = "/ostree/deploy"
+
+	// LCAVarDir is the operator's directory inside the /var of a stateroot
+	LCAVarDir = "var/lib/lca"
+
+	// StaterootMetadataFile records the OCP release installed in a stateroot, relative to LCAVarDir
+	StaterootMetadataFile = "stateroot.json"
+
+	// SavedStatesDir holds the pod and backup states saved for a stateroot, relative to LCAVarDir
+	SavedStatesDir = "saved-states"
+
+	// PullSecretFile is the auth file the seed image and the AdditionalImages are pulled with,
+	// the cluster pull secret merged with the one of the spec
+	PullSecretFile = "/var/lib/lca/pull-secret.json"
+
+	// CheckpointsDir holds the progress of the stage runs on the host
+	CheckpointsDir = "/var/lib/lca/checkpoints"
+
+	// SignaturePolicyDir holds the policies the seed image signature is verified with while Prep verifies it
+	SignaturePolicyDir = "/var/lib/lca/signature-policy"
+
+	// HostCommandAuditLog records every command the operator runs on the host
+	HostCommandAuditLog = "/var/log/lca/host-commands.log"
+)
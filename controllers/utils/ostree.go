@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KernelCmdlinePath is where the booted kernel command line is read from. Containers share the host kernel,
+// so this reflects the host boot.
+var KernelCmdlinePath = "/proc/cmdline"
+
+// GetBootedStateroot returns the ostree stateroot the node is currently booted into
+func GetBootedStateroot() (string, error) {
+	cmdline, err := os.ReadFile(KernelCmdlinePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read kernel command line: %w", err)
+	}
+	return ParseStaterootFromCmdline(string(cmdline))
+}
+
+// ParseStaterootFromCmdline extracts the stateroot from the ostree= kernel argument,
+// which has the form ostree=/ostree/boot.<N>/<stateroot>/<checksum>/<serial>
+func ParseStaterootFromCmdline(cmdline string) (string, error) {
+	for _, arg := range strings.Fields(cmdline) {
+		value, found := strings.CutPrefix(arg, "ostree=")
+		if !found {
+			continue
+		}
+		parts := strings.Split(strings.Trim(value, "/"), "/")
+		if len(parts) < 4 || parts[0] != "ostree" {
+			return "", fmt.Errorf("unexpected ostree kernel argument %q", arg)
+		}
+		return parts[2], nil
+	}
+	return "", fmt.Errorf("no ostree kernel argument found")
+}
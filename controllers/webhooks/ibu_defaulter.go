@@ -0,0 +1,127 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+//+kubebuilder:webhook:path=/mutate-ran-openshift-io-v1alpha1-imagebasedupgrade,mutating=true,failurePolicy=fail,sideEffects=None,groups=ran.openshift.io,resources=imagebasedupgrades,verbs=create;update,versions=v1alpha1,name=mimagebasedupgrade.kb.io,admissionReviewVersions=v1
+
+// ImageBasedUpgradeDefaulter fills in the optional ImageBasedUpgradeSpec fields
+type ImageBasedUpgradeDefaulter struct {
+	Log logr.Logger
+	// GetBootedStateroot returns the stateroot the node is booted into, used as the default rollback target
+	GetBootedStateroot func() (string, error)
+}
+
+var _ admission.CustomDefaulter = &ImageBasedUpgradeDefaulter{}
+
+// SetupWebhookWithManager registers the defaulting webhook with the manager's webhook server
+func (d *ImageBasedUpgradeDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if d.GetBootedStateroot == nil {
+		d.GetBootedStateroot = utils.GetBootedStateroot
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&ranv1alpha1.ImageBasedUpgrade{}).
+		WithDefaulter(d).
+		Complete()
+}
+
+// Default implements admission.CustomDefaulter
+func (d *ImageBasedUpgradeDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	ibu, ok := obj.(*ranv1alpha1.ImageBasedUpgrade)
+	if !ok {
+		return fmt.Errorf("expected an ImageBasedUpgrade but got a %T", obj)
+	}
+
+	if ibu.Spec.Stage == "" {
+		ibu.Spec.Stage = ranv1alpha1.Stages.Idle
+	}
+
+	defaultConfigMapNamespace(&ibu.Spec.AdditionalImages, ibu.Namespace)
+	defaultConfigMapNamespace(&ibu.Spec.OADPContent, ibu.Namespace)
+	for i := range ibu.Spec.ExtraManifests {
+		defaultConfigMapNamespace(&ibu.Spec.ExtraManifests[i], ibu.Namespace)
+	}
+
+	if ibu.Spec.RollbackTarget == "" {
+		return d.defaultRollbackTarget(ctx, ibu)
+	}
+	return nil
+}
+
+func defaultConfigMapNamespace(ref *ranv1alpha1.ConfigMapRef, namespace string) {
+	if ref.Name != "" && ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+}
+
+// defaultRollbackTarget sets the rollback target to the booted stateroot while idle. Once the IBU has left idle
+// the node may already run the new stateroot and the target is immutable, so the stored one is kept instead.
+func (d *ImageBasedUpgradeDefaulter) defaultRollbackTarget(ctx context.Context, ibu *ranv1alpha1.ImageBasedUpgrade) error {
+	oldIBU, err := oldObjectFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if oldIBU != nil && statemachine.GetState(oldIBU.Status.Conditions) != statemachine.States.Idle {
+		ibu.Spec.RollbackTarget = oldIBU.Spec.RollbackTarget
+		return nil
+	}
+
+	if d.GetBootedStateroot == nil {
+		return nil
+	}
+	stateroot, err := d.GetBootedStateroot()
+	if err != nil {
+		// Not fatal, the rollback target can still be set explicitly
+		d.Log.Error(err, "Failed to get the booted stateroot, not defaulting the rollback target")
+		return nil
+	}
+	d.Log.Info("Defaulting rollback target", "name", ibu.Name, "rollbackTarget", stateroot)
+	ibu.Spec.RollbackTarget = stateroot
+	return nil
+}
+
+// oldObjectFromContext returns the stored IBU for update requests, or nil for create
+func oldObjectFromContext(ctx context.Context) (*ranv1alpha1.ImageBasedUpgrade, error) {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		// Not called through the webhook server, e.g. in tests
+		return nil, nil
+	}
+	if req.Operation != admissionv1.Update || len(req.OldObject.Raw) == 0 {
+		return nil, nil
+	}
+	oldIBU := &ranv1alpha1.ImageBasedUpgrade{}
+	if err := json.Unmarshal(req.OldObject.Raw, oldIBU); err != nil {
+		return nil, fmt.Errorf("failed to decode the stored ImageBasedUpgrade: %w", err)
+	}
+	return oldIBU, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+func updateContext(t *testing.T, oldIBU *ranv1alpha1.ImageBasedUpgrade) context.Context {
+	raw, err := json.Marshal(oldIBU)
+	assert.NoError(t, err)
+	return admission.NewContextWithRequest(context.TODO(), admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			OldObject: runtime.RawExtension{Raw: raw},
+		},
+	})
+}
+
+func TestDefault(t *testing.T) {
+	d := &ImageBasedUpgradeDefaulter{
+		Log:                logr.Discard(),
+		GetBootedStateroot: func() (string, error) { return "rhcos", nil },
+	}
+
+	ibu := newIBU(utils.IBUName, "")
+	ibu.Spec.AdditionalImages = ranv1alpha1.ConfigMapRef{Name: "images"}
+	ibu.Spec.OADPContent = ranv1alpha1.ConfigMapRef{Name: "oadp", Namespace: "other"}
+	ibu.Spec.ExtraManifests = []ranv1alpha1.ConfigMapRef{{Name: "extra1"}, {Name: "extra2", Namespace: "other"}}
+	assert.NoError(t, d.Default(context.TODO(), ibu))
+
+	assert.Equal(t, ranv1alpha1.Stages.Idle, ibu.Spec.Stage)
+	assert.Equal(t, lcaNs, ibu.Spec.AdditionalImages.Namespace)
+	assert.Equal(t, "other", ibu.Spec.OADPContent.Namespace)
+	assert.Equal(t, lcaNs, ibu.Spec.ExtraManifests[0].Namespace)
+	assert.Equal(t, "other", ibu.Spec.ExtraManifests[1].Namespace)
+	assert.Equal(t, "rhcos", ibu.Spec.RollbackTarget)
+
+	// Unset references stay empty
+	ibu = newIBU(utils.IBUName, ranv1alpha1.Stages.Prep)
+	assert.NoError(t, d.Default(context.TODO(), ibu))
+	assert.Equal(t, ranv1alpha1.Stages.Prep, ibu.Spec.Stage)
+	assert.Empty(t, ibu.Spec.AdditionalImages.Namespace)
+}
+
+func TestDefaultRollbackTarget(t *testing.T) {
+	d := &ImageBasedUpgradeDefaulter{
+		Log:                logr.Discard(),
+		GetBootedStateroot: func() (string, error) { return "rhcos_4.14.1", nil },
+	}
+
+	// A previously set target survives an apply that omits it
+	oldIBU := newIBU(utils.IBUName, ranv1alpha1.Stages.Upgrade, notIdle, prepCompleted)
+	oldIBU.Spec.RollbackTarget = "rhcos"
+	ibu := newIBU(utils.IBUName, ranv1alpha1.Stages.Rollback)
+	assert.NoError(t, d.Default(updateContext(t, oldIBU), ibu))
+	assert.Equal(t, "rhcos", ibu.Spec.RollbackTarget)
+
+	// The booted stateroot is no longer the rollback target once the upgrade started
+	oldIBU.Spec.RollbackTarget = ""
+	ibu = newIBU(utils.IBUName, ranv1alpha1.Stages.Rollback)
+	assert.NoError(t, d.Default(updateContext(t, oldIBU), ibu))
+	assert.Empty(t, ibu.Spec.RollbackTarget)
+
+	// While idle the target follows the booted stateroot, a target left from a previous upgrade is not kept
+	oldIBU = newIBU(utils.IBUName, ranv1alpha1.Stages.Idle, idle)
+	oldIBU.Spec.RollbackTarget = "rhcos"
+	ibu = newIBU(utils.IBUName, ranv1alpha1.Stages.Prep)
+	assert.NoError(t, d.Default(updateContext(t, oldIBU), ibu))
+	assert.Equal(t, "rhcos_4.14.1", ibu.Spec.RollbackTarget)
+
+	// An explicit target is kept
+	ibu = newIBU(utils.IBUName, ranv1alpha1.Stages.Idle)
+	ibu.Spec.RollbackTarget = "custom"
+	assert.NoError(t, d.Default(context.TODO(), ibu))
+	assert.Equal(t, "custom", ibu.Spec.RollbackTarget)
+
+	// Failing to read the booted stateroot does not block the request
+	d.GetBootedStateroot = func() (string, error) { return "", fmt.Errorf("no cmdline") }
+	ibu = newIBU(utils.IBUName, ranv1alpha1.Stages.Idle)
+	assert.NoError(t, d.Default(context.TODO(), ibu))
+	assert.Empty(t, ibu.Spec.RollbackTarget)
+}
+
+func TestParseStaterootFromCmdline(t *testing.T) {
+	stateroot, err := utils.ParseStaterootFromCmdline(
+		"BOOT_IMAGE=(hd0,gpt3)/ostree/rhcos-abc/vmlinuz ostree=/ostree/boot.1/rhcos/0123abcd/0 root=UUID=1234 rw")
+	assert.NoError(t, err)
+	assert.Equal(t, "rhcos", stateroot)
+
+	_, err = utils.ParseStaterootFromCmdline("root=/dev/sda1 rw")
+	assert.Error(t, err)
+
+	_, err = utils.ParseStaterootFromCmdline("ostree=/boot/foo")
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/statemachine"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+//+kubebuilder:webhook:path=/validate-ran-openshift-io-v1alpha1-imagebasedupgrade,mutating=false,failurePolicy=fail,sideEffects=None,groups=ran.openshift.io,resources=imagebasedupgrades,verbs=create;update,versions=v1alpha1,name=vimagebasedupgrade.kb.io,admissionReviewVersions=v1
+
+// ImageBasedUpgradeValidator rejects illegal stage transitions and spec changes before they are persisted
+type ImageBasedUpgradeValidator struct {
+	Log logr.Logger
+}
+
+var _ admission.CustomValidator = &ImageBasedUpgradeValidator{}
+
+// SetupWebhookWithManager registers the validating webhook with the manager's webhook server
+func (v *ImageBasedUpgradeValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&ranv1alpha1.ImageBasedUpgrade{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *ImageBasedUpgradeValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	ibu, ok := obj.(*ranv1alpha1.ImageBasedUpgrade)
+	if !ok {
+		return nil, fmt.Errorf("expected an ImageBasedUpgrade but got a %T", obj)
+	}
+	v.Log.Info("Validating create", "name", ibu.Name)
+
+	allErrs := validateName(ibu)
+	// A new IBU starts idle, whatever status it comes with
+	allErrs = append(allErrs, validateStageTransition(&ranv1alpha1.ImageBasedUpgrade{}, ibu)...)
+	allErrs = append(allErrs, validateTimeouts(ibu)...)
+	return nil, toInvalid(ibu, allErrs)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *ImageBasedUpgradeValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldIBU, ok := oldObj.(*ranv1alpha1.ImageBasedUpgrade)
+	if !ok {
+		return nil, fmt.Errorf("expected an ImageBasedUpgrade but got a %T", oldObj)
+	}
+	newIBU, ok := newObj.(*ranv1alpha1.ImageBasedUpgrade)
+	if !ok {
+		return nil, fmt.Errorf("expected an ImageBasedUpgrade but got a %T", newObj)
+	}
+	v.Log.Info("Validating update", "name", newIBU.Name, "stage", newIBU.Spec.Stage)
+
+	// Deletion sets deletionTimestamp through an update, which must never be blocked
+	if newIBU.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	state := statemachine.GetState(oldIBU.Status.Conditions)
+	allErrs := validateName(newIBU)
+	if newIBU.Spec.Stage != oldIBU.Spec.Stage {
+		allErrs = append(allErrs, validateStageTransition(oldIBU, newIBU)...)
+	}
+	allErrs = append(allErrs, validateImmutableSpec(state, oldIBU, newIBU)...)
+	allErrs = append(allErrs, validateTimeouts(newIBU)...)
+	return nil, toInvalid(newIBU, allErrs)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v *ImageBasedUpgradeValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateName(ibu *ranv1alpha1.ImageBasedUpgrade) field.ErrorList {
+	if ibu.Name != utils.IBUName {
+		return field.ErrorList{field.Invalid(field.NewPath("metadata", "name"), ibu.Name,
+			fmt.Sprintf("only a single ImageBasedUpgrade named %q is supported", utils.IBUName))}
+	}
+	return nil
+}
+
+// validateStageTransition checks the stage requested by the update against the status of the stored IBU
+func validateStageTransition(oldIBU, newIBU *ranv1alpha1.ImageBasedUpgrade) field.ErrorList {
+	stagePath := field.NewPath("spec", "stage")
+	stage := newIBU.Spec.Stage
+	if stage == "" {
+		// Defaulted to Idle
+		stage = ranv1alpha1.Stages.Idle
+	}
+
+	state := statemachine.GetState(oldIBU.Status.Conditions)
+	transition, err := statemachine.LookupFor(oldIBU, stage)
+	if err != nil {
+		return field.ErrorList{field.NotSupported(stagePath, newIBU.Spec.Stage, stageNames())}
+	}
+	if transition.Action == statemachine.Actions.Reject {
+		return field.ErrorList{field.Invalid(stagePath, newIBU.Spec.Stage,
+			fmt.Sprintf("transition from %s to %s is not allowed: %s", state, stage, transition.Message()))}
+	}
+	return nil
+}
+
+// validateImmutableSpec rejects changes to the upgrade content once the IBU has left idle
+func validateImmutableSpec(state statemachine.State, oldIBU, newIBU *ranv1alpha1.ImageBasedUpgrade) field.ErrorList {
+	if state == statemachine.States.Idle {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	msg := fmt.Sprintf("cannot be changed while in state %s, abort or finalize first", state)
+	if !reflect.DeepEqual(oldIBU.Spec.SeedImageRef, newIBU.Spec.SeedImageRef) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("seedImageRef"), msg))
+	}
+	if !reflect.DeepEqual(oldIBU.Spec.AdditionalImages, newIBU.Spec.AdditionalImages) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("additionalImages"), msg))
+	}
+	if !reflect.DeepEqual(oldIBU.Spec.OADPContent, newIBU.Spec.OADPContent) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("oadpContent"), msg))
+	}
+	if !reflect.DeepEqual(oldIBU.Spec.ExtraManifests, newIBU.Spec.ExtraManifests) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("extraManifests"), msg))
+	}
+	if oldIBU.Spec.RollbackTarget != newIBU.Spec.RollbackTarget {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("rollbackTarget"), msg))
+	}
+	return allErrs
+}
+
+// validateTimeouts rejects negative durations, a zero timeout disables the operator default
+func validateTimeouts(ibu *ranv1alpha1.ImageBasedUpgrade) field.ErrorList {
+	var allErrs field.ErrorList
+	timeoutsPath := field.NewPath("spec", "timeouts")
+	timeouts := ibu.Spec.Timeouts
+	for _, timeout := range []struct {
+		name  string
+		value *metav1.Duration
+	}{
+		{"prep", timeouts.Prep},
+		{"upgrade", timeouts.Upgrade},
+		{"rollback", timeouts.Rollback},
+		{"abort", timeouts.Abort},
+		{"finalize", timeouts.Finalize},
+	} {
+		if timeout.value != nil && timeout.value.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(timeoutsPath.Child(timeout.name), timeout.value.Duration.String(), "must not be negative"))
+		}
+	}
+	if gracePeriod := ibu.Spec.AutoRollback.HealthCheckGracePeriod; gracePeriod != nil && gracePeriod.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "autoRollback", "healthCheckGracePeriod"),
+			gracePeriod.Duration.String(), "must not be negative"))
+	}
+	return allErrs
+}
+
+func stageNames() []string {
+	names := make([]string, 0, len(statemachine.AllStages))
+	for _, stage := range statemachine.AllStages {
+		names = append(names, string(stage))
+	}
+	return names
+}
+
+func toInvalid(ibu *ranv1alpha1.ImageBasedUpgrade, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(ranv1alpha1.GroupVersion.WithKind("ImageBasedUpgrade").GroupKind(), ibu.Name, allErrs)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+)
+
+const lcaNs = "openshift-lifecycle-agent"
+
+func newIBU(name string, stage ranv1alpha1.ImageBasedUpgradeStage, conditions ...metav1.Condition) *ranv1alpha1.ImageBasedUpgrade {
+	return &ranv1alpha1.ImageBasedUpgrade{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: lcaNs},
+		Spec: ranv1alpha1.ImageBasedUpgradeSpec{
+			Stage:        stage,
+			SeedImageRef: ranv1alpha1.SeedImageRef{Version: "4.14.0", Image: "quay.io/seed:4.14.0"},
+		},
+		Status: ranv1alpha1.ImageBasedUpgradeStatus{Conditions: conditions},
+	}
+}
+
+func condition(conditionType utils.ConditionType, reason utils.ConditionReason, status metav1.ConditionStatus) metav1.Condition {
+	return metav1.Condition{Type: string(conditionType), Reason: string(reason), Status: status}
+}
+
+var (
+	idle           = condition(utils.ConditionTypes.Idle, utils.ConditionReasons.Idle, metav1.ConditionTrue)
+	notIdle        = condition(utils.ConditionTypes.Idle, utils.ConditionReasons.InProgress, metav1.ConditionFalse)
+	prepRunning    = condition(utils.ConditionTypes.PrepInProgress, utils.ConditionReasons.InProgress, metav1.ConditionTrue)
+	prepCompleted  = condition(utils.ConditionTypes.PrepCompleted, utils.ConditionReasons.Completed, metav1.ConditionTrue)
+	upgradeRunning = condition(utils.ConditionTypes.UpgradeInProgress, utils.ConditionReasons.InProgress, metav1.ConditionTrue)
+)
+
+// upgradingIBU returns an IBU whose Upgrade run started an hour ago
+func upgradingIBU() *ranv1alpha1.ImageBasedUpgrade {
+	ibu := newIBU(utils.IBUName, ranv1alpha1.Stages.Upgrade, notIdle, prepCompleted, upgradeRunning)
+	ibu.Status.Progress = &ranv1alpha1.StageRun{
+		Name:      string(ranv1alpha1.Stages.Upgrade),
+		StartedAt: metav1.NewTime(time.Now().Add(-time.Hour)),
+	}
+	return ibu
+}
+
+// pivotedIBU returns an upgrading IBU that pivoted at the given offset from the start of its run
+func pivotedIBU(offset time.Duration) *ranv1alpha1.ImageBasedUpgrade {
+	ibu := upgradingIBU()
+	pivotedAt := metav1.NewTime(ibu.Status.Progress.StartedAt.Add(offset))
+	ibu.Status.PivotedAt = &pivotedAt
+	return ibu
+}
+
+func TestValidateCreate(t *testing.T) {
+	v := &ImageBasedUpgradeValidator{Log: logr.Discard()}
+
+	_, err := v.ValidateCreate(context.TODO(), newIBU(utils.IBUName, ranv1alpha1.Stages.Idle))
+	assert.NoError(t, err)
+
+	_, err = v.ValidateCreate(context.TODO(), newIBU(utils.IBUName, ""))
+	assert.NoError(t, err)
+
+	_, err = v.ValidateCreate(context.TODO(), newIBU("other", ranv1alpha1.Stages.Idle))
+	assert.True(t, apierrors.IsInvalid(err))
+	assert.Contains(t, err.Error(), "metadata.name")
+
+	_, err = v.ValidateCreate(context.TODO(), newIBU(utils.IBUName, ranv1alpha1.Stages.Upgrade))
+	assert.True(t, apierrors.IsInvalid(err))
+	assert.Contains(t, err.Error(), "transition from Idle to Upgrade is not allowed")
+
+	_, err = v.ValidateCreate(context.TODO(), newIBU(utils.IBUName, "Bogus"))
+	assert.True(t, apierrors.IsInvalid(err))
+	assert.Contains(t, err.Error(), "spec.stage")
+}
+
+func TestValidateUpdate(t *testing.T) {
+	testcases := []struct {
+		name      string
+		oldIBU    *ranv1alpha1.ImageBasedUpgrade
+		mutate    func(ibu *ranv1alpha1.ImageBasedUpgrade)
+		errSubstr string
+	}{
+		{
+			name:   "idle to prep",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Idle, idle),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Prep },
+		},
+		{
+			name:      "idle to upgrade",
+			oldIBU:    newIBU(utils.IBUName, ranv1alpha1.Stages.Idle, idle),
+			mutate:    func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Upgrade },
+			errSubstr: "transition from Idle to Upgrade is not allowed: Previous stage not succeeded yet",
+		},
+		{
+			name:      "prep to rollback",
+			oldIBU:    newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepRunning),
+			mutate:    func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Rollback },
+			errSubstr: "transition from PrepInProgress to Rollback is not allowed",
+		},
+		{
+			name:   "prep completed to upgrade",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepCompleted),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Upgrade },
+		},
+		{
+			name:   "abort prep",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepRunning),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Idle },
+		},
+		{
+			name:   "abort upgrade before the pivot",
+			oldIBU: upgradingIBU(),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Idle },
+		},
+		{
+			name:      "abort upgrade after the pivot",
+			oldIBU:    pivotedIBU(10 * time.Minute),
+			mutate:    func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Idle },
+			errSubstr: "transition from UpgradeInProgress to Idle is not allowed: The node runs the new stateroot, rollback is required",
+		},
+		{
+			name:   "abort upgrade pivoted in a previous run",
+			oldIBU: pivotedIBU(-time.Minute),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Idle },
+		},
+		{
+			name:   "rollback upgrade after the pivot",
+			oldIBU: pivotedIBU(10 * time.Minute),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.Stage = ranv1alpha1.Stages.Rollback },
+		},
+		{
+			name:   "seed image changed while idle",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Idle, idle),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.SeedImageRef.Image = "quay.io/seed:4.14.1" },
+		},
+		{
+			name:      "seed image changed during prep",
+			oldIBU:    newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepRunning),
+			mutate:    func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.SeedImageRef.Image = "quay.io/seed:4.14.1" },
+			errSubstr: "spec.seedImageRef: Forbidden: cannot be changed while in state PrepInProgress",
+		},
+		{
+			name:   "extra manifests changed during prep",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepRunning),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) {
+				ibu.Spec.ExtraManifests = []ranv1alpha1.ConfigMapRef{{Name: "extra", Namespace: lcaNs}}
+			},
+			errSubstr: "spec.extraManifests",
+		},
+		{
+			name:      "additional images and oadp content changed after prep",
+			oldIBU:    newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepCompleted),
+			mutate:    func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.OADPContent.Name = "oadp" },
+			errSubstr: "spec.oadpContent",
+		},
+		{
+			name:      "rollback target changed after prep",
+			oldIBU:    newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepCompleted),
+			mutate:    func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.RollbackTarget = "rhcos_4.14.1" },
+			errSubstr: "spec.rollbackTarget: Forbidden: cannot be changed while in state PrepCompleted",
+		},
+		{
+			name:   "rollback target changed while idle",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Idle, idle),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Spec.RollbackTarget = "rhcos_4.14.1" },
+		},
+		{
+			name:   "timeout extended during prep",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Prep, notIdle, prepRunning),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) {
+				ibu.Spec.Timeouts.Prep = &metav1.Duration{Duration: 3 * time.Hour}
+			},
+		},
+		{
+			name:   "negative timeout",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Idle, idle),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) {
+				ibu.Spec.Timeouts.Upgrade = &metav1.Duration{Duration: -time.Minute}
+			},
+			errSubstr: "spec.timeouts.upgrade: Invalid value: \"-1m0s\": must not be negative",
+		},
+		{
+			name:   "unchanged stage is not revalidated",
+			oldIBU: newIBU(utils.IBUName, ranv1alpha1.Stages.Upgrade, idle),
+			mutate: func(ibu *ranv1alpha1.ImageBasedUpgrade) { ibu.Labels = map[string]string{"foo": "bar"} },
+		},
+	}
+
+	v := &ImageBasedUpgradeValidator{Log: logr.Discard()}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			newIBU := tc.oldIBU.DeepCopy()
+			tc.mutate(newIBU)
+			_, err := v.ValidateUpdate(context.TODO(), tc.oldIBU, newIBU)
+			if tc.errSubstr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, apierrors.IsInvalid(err))
+			assert.Contains(t, err.Error(), tc.errSubstr)
+		})
+	}
+}
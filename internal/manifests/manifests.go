@@ -0,0 +1,72 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package manifests reads the Kubernetes objects held as YAML or JSON documents in ConfigMaps
+package manifests
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Manifest is an object read from a ConfigMap
+type Manifest struct {
+	// Key is the ConfigMap key holding the object
+	Key    string
+	Object *unstructured.Unstructured
+}
+
+// Parse reads the objects in the YAML documents of every key of the ConfigMap data, in key order and then
+// in document order. Empty documents are skipped, the others must have a kind and a name.
+func Parse(data map[string]string) ([]Manifest, error) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var manifests []Manifest
+	for _, key := range keys {
+		decoder := yaml.NewYAMLOrJSONDecoder(strings.NewReader(data[key]), 4096)
+		for {
+			obj := &unstructured.Unstructured{}
+			err := decoder.Decode(&obj.Object)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+			}
+			if len(obj.Object) == 0 {
+				continue
+			}
+			if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+				return nil, fmt.Errorf("object %s in %s has no kind or apiVersion", obj.GetName(), key)
+			}
+			if obj.GetName() == "" {
+				return nil, fmt.Errorf("%s in %s has no name", obj.GetKind(), key)
+			}
+			manifests = append(manifests, Manifest{Key: key, Object: obj})
+		}
+	}
+	return manifests, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	manifests, err := Parse(map[string]string{
+		"b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: second\n  namespace: ran\n",
+		"a.yaml": "---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: ran\n---\n# only a comment\n---\n" +
+			"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: first\n  namespace: ran\n",
+		"c.json": `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "third", "namespace": "ran"}}`,
+	})
+	assert.NoError(t, err)
+	var objects []string
+	for _, manifest := range manifests {
+		objects = append(objects, manifest.Key+": "+manifest.Object.GetKind()+" "+manifest.Object.GetName())
+	}
+	assert.Equal(t, []string{
+		"a.yaml: Namespace ran",
+		"a.yaml: ConfigMap first",
+		"b.yaml: ConfigMap second",
+		"c.json: Secret third",
+	}, objects)
+}
+
+func TestParseErrors(t *testing.T) {
+	testcases := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{name: "no kind", data: "metadata:\n  name: cm\n", err: "object cm in manifests has no kind or apiVersion"},
+		{name: "no name", data: "apiVersion: v1\nkind: ConfigMap\n", err: "ConfigMap in manifests has no name"},
+		{name: "invalid YAML", data: "kind: [ConfigMap\n", err: "failed to parse manifests"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(map[string]string{"manifests": tc.data})
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
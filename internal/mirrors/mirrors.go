@@ -0,0 +1,135 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mirrors resolves image references through the registry mirrors of the cluster, the way
+// the container runtime does with the registries.conf rendered from them
+package mirrors
+
+import (
+	"strings"
+)
+
+// Kind is the kind of references a Rule applies to
+type Kind string
+
+const (
+	// Digest rules come from ImageDigestMirrorSets and ImageContentSourcePolicies
+	Digest Kind = "Digest"
+	// Tag rules come from ImageTagMirrorSets
+	Tag Kind = "Tag"
+)
+
+// Rule mirrors the repositories under Source
+type Rule struct {
+	Kind Kind
+	// Source is a registry, a repository or a namespace, or a *.domain wildcard
+	Source  string
+	Mirrors []string
+	// NeverContactSource stops the fallback to the source when no mirror has the image
+	NeverContactSource bool
+}
+
+// Set is the mirror rules of a cluster
+type Set struct {
+	rules []Rule
+}
+
+// NewSet returns a Set of rules, the rules of the same source and kind are merged in order
+func NewSet(rules ...Rule) *Set {
+	return &Set{rules: rules}
+}
+
+// Resolve returns the references to try in order to pull an image: its mirrors, then the image itself
+// unless the source must not be contacted. Images without mirrors resolve to themselves.
+func (s *Set) Resolve(image string) []string {
+	repo, suffix, kind := splitReference(image)
+
+	// The most specific source wins, like in registries.conf
+	var source string
+	for _, rule := range s.rules {
+		if rule.Kind == kind && matches(rule.Source, repo) && moreSpecific(rule.Source, source) {
+			source = rule.Source
+		}
+	}
+	if source == "" {
+		return []string{image}
+	}
+
+	var candidates []string
+	seen := map[string]bool{}
+	contactSource := true
+	for _, rule := range s.rules {
+		if rule.Kind != kind || rule.Source != source {
+			continue
+		}
+		contactSource = contactSource && !rule.NeverContactSource
+		for _, mirror := range rule.Mirrors {
+			candidate := rewrite(source, mirror, repo) + suffix
+			if !seen[candidate] {
+				seen[candidate] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	if contactSource && !seen[image] {
+		candidates = append(candidates, image)
+	}
+	return candidates
+}
+
+// splitReference splits an image reference into its repository and its @digest or :tag suffix
+func splitReference(image string) (string, string, Kind) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i:], Digest
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i:], Tag
+	}
+	return image, "", Tag
+}
+
+func matches(source, repo string) bool {
+	if strings.HasPrefix(source, "*.") {
+		host, _, _ := strings.Cut(repo, "/")
+		return strings.HasSuffix(host, source[1:])
+	}
+	return repo == source || strings.HasPrefix(repo, source+"/")
+}
+
+// moreSpecific tells whether source is more specific than other: a repository beats its namespace,
+// any non-wildcard source beats a wildcard
+func moreSpecific(source, other string) bool {
+	if other == "" {
+		return true
+	}
+	wildcard, otherWildcard := strings.HasPrefix(source, "*."), strings.HasPrefix(other, "*.")
+	if wildcard != otherWildcard {
+		return otherWildcard
+	}
+	return len(source) > len(other)
+}
+
+// rewrite replaces the part of repo matched by source with mirror
+func rewrite(source, mirror, repo string) string {
+	if strings.HasPrefix(source, "*.") {
+		_, rest, found := strings.Cut(repo, "/")
+		if !found {
+			return mirror
+		}
+		return mirror + "/" + rest
+	}
+	return mirror + strings.TrimPrefix(repo, source)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirrors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	set := NewSet(
+		Rule{Kind: Digest, Source: "quay.io/openshift-release-dev", Mirrors: []string{"mirror.local:5000/ocp", "backup.local/ocp"}},
+		Rule{Kind: Digest, Source: "quay.io/openshift-release-dev/ocp-release", Mirrors: []string{"mirror.local:5000/release"}, NeverContactSource: true},
+		Rule{Kind: Digest, Source: "quay.io/openshift-release-dev", Mirrors: []string{"backup.local/ocp", "third.local/ocp"}},
+		Rule{Kind: Tag, Source: "quay.io/openshift-kni", Mirrors: []string{"mirror.local:5000/kni"}},
+		Rule{Kind: Digest, Source: "*.redhat.io", Mirrors: []string{"mirror.local:5000/redhat"}},
+		Rule{Kind: Digest, Source: "registry.redhat.io/rhel9", Mirrors: []string{"mirror.local:5000/rhel9"}},
+	)
+	testcases := []struct {
+		image      string
+		candidates []string
+	}{
+		{
+			image: "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:1234",
+			candidates: []string{
+				"mirror.local:5000/ocp/ocp-v4.0-art-dev@sha256:1234",
+				"backup.local/ocp/ocp-v4.0-art-dev@sha256:1234",
+				"third.local/ocp/ocp-v4.0-art-dev@sha256:1234",
+				"quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:1234",
+			},
+		},
+		{
+			image:      "quay.io/openshift-release-dev/ocp-release@sha256:1234",
+			candidates: []string{"mirror.local:5000/release@sha256:1234"},
+		},
+		{
+			image:      "quay.io/openshift-release-dev/ocp-release:4.14.1-x86_64",
+			candidates: []string{"quay.io/openshift-release-dev/ocp-release:4.14.1-x86_64"},
+		},
+		{
+			image:      "quay.io/openshift-kni/seed:4.14.1",
+			candidates: []string{"mirror.local:5000/kni/seed:4.14.1", "quay.io/openshift-kni/seed:4.14.1"},
+		},
+		{
+			image:      "quay.io/openshift-kni/seed",
+			candidates: []string{"mirror.local:5000/kni/seed", "quay.io/openshift-kni/seed"},
+		},
+		{
+			image:      "quay.io/openshift-knight/seed:1.0",
+			candidates: []string{"quay.io/openshift-knight/seed:1.0"},
+		},
+		{
+			image:      "registry.redhat.io/openshift4/ose-sriov@sha256:abcd",
+			candidates: []string{"mirror.local:5000/redhat/openshift4/ose-sriov@sha256:abcd", "registry.redhat.io/openshift4/ose-sriov@sha256:abcd"},
+		},
+		{
+			image:      "registry.redhat.io/rhel9/support-tools@sha256:abcd",
+			candidates: []string{"mirror.local:5000/rhel9/support-tools@sha256:abcd", "registry.redhat.io/rhel9/support-tools@sha256:abcd"},
+		},
+		{
+			image:      "localhost:5000/du@sha256:abcd",
+			candidates: []string{"localhost:5000/du@sha256:abcd"},
+		},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.candidates, set.Resolve(tc.image), tc.image)
+	}
+	assert.Equal(t, []string{"quay.io/ran/du:1.0"}, NewSet().Resolve("quay.io/ran/du:1.0"))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oadp reads the OADP Backup and Restore CRs of the OADPContent ConfigMap and orders them in apply waves
+package oadp
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/manifests"
+)
+
+// ApplyWaveAnnotation orders the CRs: lower waves are applied, and must complete, before higher ones
+const ApplyWaveAnnotation = "lca.openshift.io/apply-wave"
+
+// RunLabel is set on the applied CRs to the upgrade run they were applied for, the CRs of another run are stale
+const RunLabel = "lca.openshift.io/upgrade-run"
+
+// LastWave is the wave of the CRs without the annotation, applied after all the others
+const LastWave = -1
+
+// DefaultNamespace is where the CRs without a namespace are applied, the namespace of the OADP operator
+const DefaultNamespace = "openshift-adp"
+
+// GVKs of the Velero CRs
+var (
+	BackupGVK  = schema.GroupVersionKind{Group: "velero.io", Version: "v1", Kind: "Backup"}
+	RestoreGVK = schema.GroupVersionKind{Group: "velero.io", Version: "v1", Kind: "Restore"}
+)
+
+// Wave is a set of CRs applied together
+type Wave struct {
+	Number  int
+	Objects []*unstructured.Unstructured
+}
+
+// Content is the CRs of the OADPContent ConfigMap, in wave order
+type Content struct {
+	Backups  []Wave
+	Restores []Wave
+}
+
+// Parse reads the CRs in the YAML documents of every key of the ConfigMap data, in key order
+func Parse(data map[string]string) (*Content, error) {
+	objs, err := manifests.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups, restores []*unstructured.Unstructured
+	for _, manifest := range objs {
+		obj := manifest.Object
+		if _, err := wave(obj); err != nil {
+			return nil, fmt.Errorf("%s %s in %s: %w", obj.GetKind(), obj.GetName(), manifest.Key, err)
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(DefaultNamespace)
+		}
+		switch obj.GroupVersionKind() {
+		case BackupGVK:
+			backups = append(backups, obj)
+		case RestoreGVK:
+			restores = append(restores, obj)
+		default:
+			return nil, fmt.Errorf("%s in %s is a %s, only %s and %s are supported",
+				obj.GetName(), manifest.Key, obj.GroupVersionKind(), BackupGVK, RestoreGVK)
+		}
+	}
+	return &Content{Backups: waves(backups), Restores: waves(restores)}, nil
+}
+
+func wave(obj *unstructured.Unstructured) (int, error) {
+	value, ok := obj.GetAnnotations()[ApplyWaveAnnotation]
+	if !ok {
+		return LastWave, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q, it must be a non negative number", ApplyWaveAnnotation, value)
+	}
+	return number, nil
+}
+
+// waves groups the CRs by wave, lowest first and LastWave at the end, keeping the CRs of a wave in order
+func waves(objs []*unstructured.Unstructured) []Wave {
+	byNumber := map[int]*Wave{}
+	var numbers []int
+	for _, obj := range objs {
+		number, _ := wave(obj)
+		w, ok := byNumber[number]
+		if !ok {
+			w = &Wave{Number: number}
+			byNumber[number] = w
+			numbers = append(numbers, number)
+		}
+		w.Objects = append(w.Objects, obj)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		if numbers[i] == LastWave || numbers[j] == LastWave {
+			return numbers[j] == LastWave && numbers[i] != LastWave
+		}
+		return numbers[i] < numbers[j]
+	})
+	result := make([]Wave, 0, len(numbers))
+	for _, number := range numbers {
+		result = append(result, *byNumber[number])
+	}
+	return result
+}
+
+// Phase is the phase of a Backup or a Restore
+type Phase string
+
+// Terminal phases of Backups and Restores, the other ones are in progress
+const (
+	Completed        Phase = "Completed"
+	PartiallyFailed  Phase = "PartiallyFailed"
+	Failed           Phase = "Failed"
+	FailedValidation Phase = "FailedValidation"
+)
+
+// Progress is the state of a Backup or a Restore reported by Velero
+type Progress struct {
+	Phase    Phase
+	Warnings int64
+	Errors   int64
+	// Message explains a failure, from the validation errors or the failure reason
+	Message string
+}
+
+// Done tells whether the CR completed successfully
+func (p Progress) Done() bool {
+	return p.Phase == Completed
+}
+
+// Failed tells whether the CR ended without completing, partially failed CRs are failures too
+func (p Progress) Failed() bool {
+	return p.Phase == PartiallyFailed || p.Phase == Failed || p.Phase == FailedValidation
+}
+
+// Error describes why the CR failed
+func (p Progress) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "phase %s", p.Phase)
+	if p.Message != "" {
+		fmt.Fprintf(&buf, ": %s", p.Message)
+	}
+	if p.Errors > 0 {
+		fmt.Fprintf(&buf, " (%d errors)", p.Errors)
+	}
+	return buf.String()
+}
+
+// GetProgress reads the progress from the status of a Backup or a Restore
+func GetProgress(obj *unstructured.Unstructured) Progress {
+	var progress Progress
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	progress.Phase = Phase(phase)
+	progress.Warnings, _, _ = unstructured.NestedInt64(obj.Object, "status", "warnings")
+	progress.Errors, _, _ = unstructured.NestedInt64(obj.Object, "status", "errors")
+	validationErrors, _, _ := unstructured.NestedStringSlice(obj.Object, "status", "validationErrors")
+	failureReason, _, _ := unstructured.NestedString(obj.Object, "status", "failureReason")
+	if failureReason != "" {
+		validationErrors = append(validationErrors, failureReason)
+	}
+	progress.Message = strings.Join(validationErrors, "; ")
+	return progress
+}
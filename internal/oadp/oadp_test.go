@@ -0,0 +1,145 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oadp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testBackups = `apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  name: apps
+  annotations:
+    lca.openshift.io/apply-wave: "10"
+spec:
+  includedNamespaces: [ran]
+---
+apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  name: cluster
+  namespace: backups
+  annotations:
+    lca.openshift.io/apply-wave: "1"
+---
+apiVersion: velero.io/v1
+kind: Backup
+metadata:
+  name: leftovers
+`
+
+const testRestores = `apiVersion: velero.io/v1
+kind: Restore
+metadata:
+  name: apps
+  annotations:
+    lca.openshift.io/apply-wave: "2"
+---
+apiVersion: velero.io/v1
+kind: Restore
+metadata:
+  name: more-apps
+  annotations:
+    lca.openshift.io/apply-wave: "2"
+`
+
+func names(waves []Wave) map[int][]string {
+	result := map[int][]string{}
+	for _, wave := range waves {
+		for _, obj := range wave.Objects {
+			result[wave.Number] = append(result[wave.Number], obj.GetNamespace()+"/"+obj.GetName())
+		}
+	}
+	return result
+}
+
+func TestParse(t *testing.T) {
+	content, err := Parse(map[string]string{"backups.yaml": testBackups, "restores.yaml": testRestores})
+	assert.NoError(t, err)
+
+	var order []int
+	for _, wave := range content.Backups {
+		order = append(order, wave.Number)
+	}
+	assert.Equal(t, []int{1, 10, LastWave}, order)
+	assert.Equal(t, map[int][]string{
+		1:        {"backups/cluster"},
+		10:       {"openshift-adp/apps"},
+		LastWave: {"openshift-adp/leftovers"},
+	}, names(content.Backups))
+	assert.Equal(t, map[int][]string{2: {"openshift-adp/apps", "openshift-adp/more-apps"}}, names(content.Restores))
+}
+
+func TestParseErrors(t *testing.T) {
+	testcases := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{
+			name: "other kind",
+			data: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n",
+			err:  "cm in content is a /v1, Kind=ConfigMap, only velero.io/v1, Kind=Backup and velero.io/v1, Kind=Restore are supported",
+		},
+		{
+			name: "invalid wave",
+			data: "apiVersion: velero.io/v1\nkind: Backup\nmetadata:\n  name: apps\n  annotations:\n    lca.openshift.io/apply-wave: first\n",
+			err:  `Backup apps in content: invalid lca.openshift.io/apply-wave annotation "first"`,
+		},
+		{
+			name: "no name",
+			data: "apiVersion: velero.io/v1\nkind: Backup\nspec: {}\n",
+			err:  "Backup in content has no name",
+		},
+		{
+			name: "invalid YAML",
+			data: "apiVersion: velero.io/v1\nkind: [Backup\n",
+			err:  "failed to parse content",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(map[string]string{"content": tc.data})
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestGetProgress(t *testing.T) {
+	backup := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"phase":            "PartiallyFailed",
+			"warnings":         int64(1),
+			"errors":           int64(2),
+			"validationErrors": []interface{}{"invalid storage location"},
+			"failureReason":    "timed out",
+		},
+	}}
+	progress := GetProgress(backup)
+	assert.True(t, progress.Failed())
+	assert.False(t, progress.Done())
+	assert.Equal(t, int64(1), progress.Warnings)
+	assert.Equal(t, "phase PartiallyFailed: invalid storage location; timed out (2 errors)", progress.Error())
+
+	progress = GetProgress(&unstructured.Unstructured{Object: map[string]interface{}{}})
+	assert.False(t, progress.Failed())
+	assert.False(t, progress.Done())
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// maxAuditStderr caps the stderr kept per command, stdout is not kept as it may hold secrets
+	maxAuditStderr = 1024
+
+	// maxAuditLogSize is the size the audit log is rotated at, one previous file is kept
+	maxAuditLogSize = 10 << 20
+)
+
+// AuditEntry is the record of one host command
+type AuditEntry struct {
+	Time           time.Time `json:"time"`
+	Command        string    `json:"command"`
+	Args           []string  `json:"args"`
+	HostNamespaces bool      `json:"hostNamespaces,omitempty"`
+	ExitCode       int       `json:"exitCode"`
+	DurationMillis int64     `json:"durationMillis"`
+	StdoutBytes    int       `json:"stdoutBytes"`
+	Stderr         string    `json:"stderr,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// AuditLog appends a JSON line per host command to a file. A nil AuditLog records nothing.
+type AuditLog struct {
+	mu   sync.Mutex
+	log  logr.Logger
+	path string
+}
+
+// NewAuditLog returns an AuditLog writing to path, the directory is created as needed
+func NewAuditLog(log logr.Logger, path string) *AuditLog {
+	return &AuditLog{log: log, path: path}
+}
+
+// Record appends the entry of a command. Failing to write it is logged, commands are not failed for it.
+func (a *AuditLog) Record(command Command, result *Result, err error) {
+	if a == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:           time.Now().UTC(),
+		Command:        command.Name,
+		Args:           command.Args,
+		HostNamespaces: command.HostNamespaces,
+		ExitCode:       result.ExitCode,
+		DurationMillis: result.Duration.Milliseconds(),
+		StdoutBytes:    len(result.Stdout),
+		Stderr:         result.Stderr,
+	}
+	if len(entry.Stderr) > maxAuditStderr {
+		entry.Stderr = entry.Stderr[:maxAuditStderr]
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := a.write(entry); err != nil {
+		a.log.Error(err, "Failed to write the host command audit log", "path", a.path)
+	}
+}
+
+func (a *AuditLog) write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o700); err != nil {
+		return err
+	}
+	if info, err := os.Stat(a.path); err == nil && info.Size() >= maxAuditLogSize {
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
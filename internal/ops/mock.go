@@ -0,0 +1,66 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MockExecutor is an Executor for tests, it records the commands and answers them from Results
+type MockExecutor struct {
+	mu sync.Mutex
+	// Results are keyed by command line, the other commands succeed with no output
+	Results map[string]Result
+	// Commands are the commands run so far
+	Commands []Command
+}
+
+// Lines returns the command lines run so far
+func (m *MockExecutor) Lines() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lines []string
+	for _, command := range m.Commands {
+		lines = append(lines, command.String())
+	}
+	return lines
+}
+
+func (m *MockExecutor) Execute(ctx context.Context, command string, args ...string) (string, error) {
+	result, err := m.Run(ctx, Command{Name: command, Args: args})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+func (m *MockExecutor) Run(ctx context.Context, command Command) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Commands = append(m.Commands, command)
+	if err := ctx.Err(); err != nil {
+		return &Result{ExitCode: -1}, fmt.Errorf("failed to run %s: %w", command, err)
+	}
+	result := m.Results[command.String()]
+	if result.ExitCode != 0 {
+		return &result, fmt.Errorf("failed to run %s: exit status %d: %s", command, result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return &result, nil
+}
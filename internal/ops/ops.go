@@ -0,0 +1,170 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ops runs commands on the host the operator is deployed on
+package ops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Execute runs a command on the host and returns its trimmed standard output
+type Execute interface {
+	Execute(ctx context.Context, command string, args ...string) (string, error)
+}
+
+// Executor runs commands on the host and reports their full result
+type Executor interface {
+	Execute
+	// Run runs a command, the result is returned even when the command fails
+	Run(ctx context.Context, command Command) (*Result, error)
+}
+
+// Command is a command to run on the host
+type Command struct {
+	Name string
+	Args []string
+	// Timeout kills the command once expired, DefaultTimeout when not set
+	Timeout time.Duration
+	// HostNamespaces runs the command in the namespaces of the host init process with nsenter,
+	// for the commands talking to systemd such as systemctl and reboot
+	HostNamespaces bool
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Result is the outcome of a command
+type Result struct {
+	Stdout string
+	Stderr string
+	// ExitCode is -1 when the command did not start or was killed
+	ExitCode int
+	Duration time.Duration
+}
+
+// DefaultTimeout bounds the commands run without a timeout of their own
+const DefaultTimeout = time.Hour
+
+// waitDelay is how long a killed command gets to close its output before it is abandoned
+const waitDelay = 10 * time.Second
+
+// nsenterArgs enter the namespaces of the host init process, the operator runs with the host PID namespace
+var nsenterArgs = []string{"--target", "1", "--cgroup", "--mount", "--ipc", "--pid", "--uts", "--net", "--"}
+
+type hostExecutor struct {
+	log      logr.Logger
+	hostPath string
+	audit    *AuditLog
+}
+
+// hostBinDirs are searched for commands given without a path
+var hostBinDirs = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// NewHostExecutor returns an Executor that runs commands chrooted into the host filesystem mounted at hostPath,
+// and records every command in audit when set
+func NewHostExecutor(log logr.Logger, hostPath string, audit *AuditLog) Executor {
+	return &hostExecutor{log: log, hostPath: hostPath, audit: audit}
+}
+
+// lookPath resolves a command against the host PATH, since the operator image has no binaries of its own
+func (e *hostExecutor) lookPath(command string) (string, error) {
+	if strings.Contains(command, "/") {
+		return command, nil
+	}
+	for _, dir := range hostBinDirs {
+		path := filepath.Join(dir, command)
+		if info, err := os.Stat(filepath.Join(e.hostPath, path)); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("command %s not found on the host", command)
+}
+
+func (e *hostExecutor) Execute(ctx context.Context, command string, args ...string) (string, error) {
+	result, err := e.Run(ctx, Command{Name: command, Args: args})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Stdout), nil
+}
+
+func (e *hostExecutor) Run(ctx context.Context, command Command) (*Result, error) {
+	e.log.Info("Executing host command", "command", command.Name, "args", command.Args)
+	start := time.Now()
+	result, err := e.run(ctx, command)
+	result.Duration = time.Since(start)
+	e.audit.Record(command, result, err)
+	return result, err
+}
+
+func (e *hostExecutor) run(ctx context.Context, command Command) (*Result, error) {
+	result := &Result{ExitCode: -1}
+	name, args := command.Name, command.Args
+	if command.HostNamespaces {
+		name, args = "nsenter", append(append([]string(nil), nsenterArgs...), append([]string{command.Name}, args...)...)
+	}
+	path, err := e.lookPath(name)
+	if err != nil {
+		return result, err
+	}
+
+	timeout := command.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(runCtx, path, args...)
+	// The path was resolved inside the host root, skip the lookup in the container
+	cmd.Path = path
+	cmd.Err = nil
+	cmd.SysProcAttr = &syscall.SysProcAttr{Chroot: e.hostPath}
+	cmd.Dir = "/"
+	cmd.WaitDelay = waitDelay
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+		return result, nil
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, fmt.Errorf("failed to run %s: %w: %s", command, err, strings.TrimSpace(result.Stderr))
+}
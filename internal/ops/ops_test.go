@@ -0,0 +1,135 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ops
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostExecutor(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chroot needs root")
+	}
+	auditPath := filepath.Join(t.TempDir(), "lca", "host-commands.log")
+	// The test host is the root of the test machine
+	executor := NewHostExecutor(logr.Discard(), "/", NewAuditLog(logr.Discard(), auditPath))
+	ctx := context.Background()
+
+	output, err := executor.Execute(ctx, "sh", "-c", "echo hello; echo warning >&2")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", output)
+
+	result, err := executor.Run(ctx, Command{Name: "sh", Args: []string{"-c", "echo broken >&2; exit 3"}})
+	assert.ErrorContains(t, err, "failed to run sh -c echo broken >&2; exit 3: exit status 3: broken")
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "broken\n", result.Stderr)
+
+	result, err = executor.Run(ctx, Command{Name: "sleep", Args: []string{"10"}, Timeout: 50 * time.Millisecond})
+	assert.ErrorContains(t, err, "timed out after 50ms")
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Less(t, result.Duration, 5*time.Second)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = executor.Run(cancelled, Command{Name: "sleep", Args: []string{"10"}})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = executor.Execute(ctx, "no-such-command")
+	assert.ErrorContains(t, err, "command no-such-command not found on the host")
+
+	f, err := os.Open(auditPath)
+	assert.NoError(t, err)
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Len(t, entries, 5, "every command is audited, including the ones that did not start")
+	assert.Equal(t, AuditEntry{
+		Time: entries[0].Time, Command: "sh", Args: []string{"-c", "echo hello; echo warning >&2"},
+		DurationMillis: entries[0].DurationMillis, StdoutBytes: len("hello\n"), Stderr: "warning\n",
+	}, entries[0])
+	assert.Equal(t, 3, entries[1].ExitCode)
+	assert.Contains(t, entries[2].Error, "timed out")
+	assert.Equal(t, "no-such-command", entries[4].Command)
+	assert.Equal(t, -1, entries[4].ExitCode)
+}
+
+func TestHostExecutorNamespaces(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("nsenter needs root")
+	}
+	executor := NewHostExecutor(logr.Discard(), "/", nil)
+	result, err := executor.Run(context.Background(), Command{Name: "true", HostNamespaces: true})
+	if err != nil && strings.Contains(err.Error(), "nsenter") {
+		t.Skipf("cannot enter the namespaces of PID 1 here: %v", err)
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "host-commands.log")
+	assert.NoError(t, os.WriteFile(auditPath, make([]byte, maxAuditLogSize), 0o600))
+	audit := NewAuditLog(logr.Discard(), auditPath)
+	audit.Record(Command{Name: "podman", Args: []string{"pull", "quay.io/ran/du:1.0"}}, &Result{Stderr: strings.Repeat("e", 2*maxAuditStderr)}, nil)
+
+	rotated, err := os.Stat(auditPath + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(maxAuditLogSize), rotated.Size())
+	data, err := os.ReadFile(auditPath)
+	assert.NoError(t, err)
+	entry := AuditEntry{}
+	assert.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "podman", entry.Command)
+	assert.Len(t, entry.Stderr, maxAuditStderr)
+
+	// A nil audit log records nothing
+	var none *AuditLog
+	none.Record(Command{Name: "true"}, &Result{}, nil)
+}
+
+func TestMockExecutor(t *testing.T) {
+	executor := &MockExecutor{Results: map[string]Result{
+		"podman image exists quay.io/ran/du:1.0": {ExitCode: 1},
+		"rpm-ostree status --json":               {Stdout: "{}\n"},
+	}}
+	ctx := context.Background()
+	output, err := executor.Execute(ctx, "rpm-ostree", "status", "--json")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", output)
+	_, err = executor.Execute(ctx, "podman", "image", "exists", "quay.io/ran/du:1.0")
+	assert.ErrorContains(t, err, "exit status 1")
+	result, err := executor.Run(ctx, Command{Name: "systemctl", Args: []string{"reboot"}, HostNamespaces: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, []string{"rpm-ostree status --json", "podman image exists quay.io/ran/du:1.0", "systemctl reboot"}, executor.Lines())
+	assert.True(t, executor.Commands[2].HostNamespaces)
+}
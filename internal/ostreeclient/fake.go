@@ -0,0 +1,169 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ostreeclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeClient is an in-memory IClient for tests, it enforces the same rules as ostree
+type FakeClient struct {
+	mu          sync.Mutex
+	deployments []Deployment
+	stateRoots  map[string]bool
+	commits     map[string]bool
+	// Err, if set, is returned by every operation
+	Err error
+}
+
+// NewFakeClient returns a FakeClient with the given deployments, their stateroots and commits exist
+func NewFakeClient(deployments ...Deployment) *FakeClient {
+	c := &FakeClient{stateRoots: map[string]bool{}, commits: map[string]bool{}}
+	for _, d := range deployments {
+		if d.ID == "" {
+			d.ID = fmt.Sprintf("%s-%s.%d", d.OSName, d.Checksum, d.Serial)
+		}
+		c.deployments = append(c.deployments, d)
+		c.stateRoots[d.OSName] = true
+		c.commits[d.Checksum] = true
+	}
+	return c
+}
+
+// QueryDeployments implements IClient
+func (c *FakeClient) QueryDeployments(ctx context.Context) ([]Deployment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return append([]Deployment(nil), c.deployments...), nil
+}
+
+// StateRoots returns the stateroots created on the fake host
+func (c *FakeClient) StateRoots() map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stateRoots := map[string]bool{}
+	for name := range c.stateRoots {
+		stateRoots[name] = true
+	}
+	return stateRoots
+}
+
+// CreateStateRoot implements IClient
+func (c *FakeClient) CreateStateRoot(ctx context.Context, stateroot string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	c.stateRoots[stateroot] = true
+	return nil
+}
+
+// PullLocal implements IClient
+func (c *FakeClient) PullLocal(ctx context.Context, repo, commit string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	c.commits[commit] = true
+	return nil
+}
+
+// Deploy implements IClient
+func (c *FakeClient) Deploy(ctx context.Context, stateroot, commit string, kernelArgs []string) (*Deployment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	if !c.stateRoots[stateroot] {
+		return nil, fmt.Errorf("stateroot %s does not exist", stateroot)
+	}
+	if !c.commits[commit] {
+		return nil, fmt.Errorf("commit %s not found in the repository", commit)
+	}
+	serial := 0
+	for _, d := range c.deployments {
+		if d.OSName == stateroot && d.Checksum == commit && d.Serial >= serial {
+			serial = d.Serial + 1
+		}
+	}
+	deployment := Deployment{ID: fmt.Sprintf("%s-%s.%d", stateroot, commit, serial), OSName: stateroot, Serial: serial, Checksum: commit}
+	// Not as default: right after the default deployment
+	position := len(c.deployments)
+	if position > 1 {
+		position = 1
+	}
+	c.deployments = append(c.deployments[:position], append([]Deployment{deployment}, c.deployments[position:]...)...)
+	return &deployment, nil
+}
+
+// SetDefaultDeployment implements IClient
+func (c *FakeClient) SetDefaultDeployment(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	index, err := indexOf(c.deployments, id)
+	if err != nil {
+		return err
+	}
+	deployment := c.deployments[index]
+	c.deployments = append(c.deployments[:index], c.deployments[index+1:]...)
+	c.deployments = append([]Deployment{deployment}, c.deployments...)
+	return nil
+}
+
+// PinDeployment implements IClient
+func (c *FakeClient) PinDeployment(ctx context.Context, id string, pinned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	index, err := indexOf(c.deployments, id)
+	if err != nil {
+		return err
+	}
+	c.deployments[index].Pinned = pinned
+	return nil
+}
+
+// Undeploy implements IClient
+func (c *FakeClient) Undeploy(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	index, err := indexOf(c.deployments, id)
+	if err != nil {
+		return err
+	}
+	if c.deployments[index].Booted {
+		return fmt.Errorf("cannot undeploy the booted deployment %s", id)
+	}
+	c.deployments = append(c.deployments[:index], c.deployments[index+1:]...)
+	return nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ostreeclient wraps the ostree and rpm-ostree host tools
+package ostreeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+)
+
+// Deployment is an ostree deployment as reported by rpm-ostree status
+type Deployment struct {
+	ID        string `json:"id"`
+	OSName    string `json:"osname"`
+	Serial    int    `json:"serial"`
+	Checksum  string `json:"checksum"`
+	Version   string `json:"version"`
+	Timestamp int64  `json:"timestamp"`
+	Booted    bool   `json:"booted"`
+	Pinned    bool   `json:"pinned"`
+	Staged    bool   `json:"staged"`
+}
+
+type status struct {
+	Deployments []Deployment `json:"deployments"`
+}
+
+// IClient is the interface to the ostree stateroots and deployments of the host.
+// Deployments are identified by the id reported by rpm-ostree status.
+type IClient interface {
+	// QueryDeployments returns the deployments in boot order, the first one is the default
+	QueryDeployments(ctx context.Context) ([]Deployment, error)
+	// CreateStateRoot creates a stateroot, it is a no-op when the stateroot exists
+	CreateStateRoot(ctx context.Context, stateroot string) error
+	// PullLocal imports a commit from another ostree repository on the host, such as the one of the seed image
+	PullLocal(ctx context.Context, repo, commit string) error
+	// Deploy deploys a pulled commit into a stateroot with the given kernel arguments and returns the new
+	// deployment. The deployment is added after the default one, it boots once made the default.
+	Deploy(ctx context.Context, stateroot, commit string, kernelArgs []string) (*Deployment, error)
+	// SetDefaultDeployment makes a deployment the one booted on the next reboot
+	SetDefaultDeployment(ctx context.Context, id string) error
+	// PinDeployment pins or unpins a deployment, pinned deployments are never garbage collected
+	PinDeployment(ctx context.Context, id string, pinned bool) error
+	// Undeploy removes a deployment, the booted one cannot be removed
+	Undeploy(ctx context.Context, id string) error
+}
+
+// Client implements IClient with rpm-ostree
+type Client struct {
+	executor ops.Execute
+}
+
+// NewClient returns an IClient running rpm-ostree through the given executor
+func NewClient(executor ops.Execute) IClient {
+	return &Client{executor: executor}
+}
+
+// QueryDeployments implements IClient
+func (c *Client) QueryDeployments(ctx context.Context) ([]Deployment, error) {
+	output, err := c.executor.Execute(ctx, "rpm-ostree", "status", "--json")
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatus([]byte(output))
+}
+
+// ParseStatus parses the output of rpm-ostree status --json
+func ParseStatus(output []byte) ([]Deployment, error) {
+	s := status{}
+	if err := json.Unmarshal(output, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse rpm-ostree status: %w", err)
+	}
+	return s.Deployments, nil
+}
+
+// CreateStateRoot implements IClient
+func (c *Client) CreateStateRoot(ctx context.Context, stateroot string) error {
+	if _, err := c.executor.Execute(ctx, "ostree", "admin", "os-init", stateroot); err != nil {
+		return fmt.Errorf("failed to create stateroot %s: %w", stateroot, err)
+	}
+	return nil
+}
+
+// PullLocal implements IClient
+func (c *Client) PullLocal(ctx context.Context, repo, commit string) error {
+	if _, err := c.executor.Execute(ctx, "ostree", "pull-local", repo, commit); err != nil {
+		return fmt.Errorf("failed to pull commit %s from %s: %w", commit, repo, err)
+	}
+	return nil
+}
+
+// Deploy implements IClient. The configuration of the booted deployment is not merged, the seed brings its own /etc.
+func (c *Client) Deploy(ctx context.Context, stateroot, commit string, kernelArgs []string) (*Deployment, error) {
+	args := []string{"admin", "deploy", "--os", stateroot, "--no-prune", "--no-merge", "--retain", "--not-as-default"}
+	for _, karg := range kernelArgs {
+		args = append(args, "--karg", karg)
+	}
+	if _, err := c.executor.Execute(ctx, "ostree", append(args, commit)...); err != nil {
+		return nil, fmt.Errorf("failed to deploy commit %s in stateroot %s: %w", commit, stateroot, err)
+	}
+
+	deployments, err := c.QueryDeployments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The newest deployment of the commit has the highest serial
+	var deployed *Deployment
+	for i := range deployments {
+		d := &deployments[i]
+		if d.OSName == stateroot && d.Checksum == commit && (deployed == nil || d.Serial > deployed.Serial) {
+			deployed = d
+		}
+	}
+	if deployed == nil {
+		return nil, fmt.Errorf("deployment of commit %s in stateroot %s not found after deploying it", commit, stateroot)
+	}
+	return deployed, nil
+}
+
+// SetDefaultDeployment implements IClient
+func (c *Client) SetDefaultDeployment(ctx context.Context, id string) error {
+	index, err := c.deploymentIndex(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := c.executor.Execute(ctx, "ostree", "admin", "set-default", strconv.Itoa(index)); err != nil {
+		return fmt.Errorf("failed to set deployment %s as default: %w", id, err)
+	}
+	return nil
+}
+
+// PinDeployment implements IClient
+func (c *Client) PinDeployment(ctx context.Context, id string, pinned bool) error {
+	index, err := c.deploymentIndex(ctx, id)
+	if err != nil {
+		return err
+	}
+	args := []string{"admin", "pin"}
+	if !pinned {
+		args = append(args, "--unpin")
+	}
+	if _, err := c.executor.Execute(ctx, "ostree", append(args, strconv.Itoa(index))...); err != nil {
+		return fmt.Errorf("failed to pin deployment %s: %w", id, err)
+	}
+	return nil
+}
+
+// Undeploy implements IClient
+func (c *Client) Undeploy(ctx context.Context, id string) error {
+	index, err := c.deploymentIndex(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := c.executor.Execute(ctx, "ostree", "admin", "undeploy", strconv.Itoa(index)); err != nil {
+		return fmt.Errorf("failed to undeploy deployment %s: %w", id, err)
+	}
+	return nil
+}
+
+// deploymentIndex returns the index ostree admin addresses a deployment by, the order of rpm-ostree status
+func (c *Client) deploymentIndex(ctx context.Context, id string) (int, error) {
+	deployments, err := c.QueryDeployments(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return indexOf(deployments, id)
+}
+
+func indexOf(deployments []Deployment, id string) (int, error) {
+	for i, d := range deployments {
+		if d.ID == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("deployment %s not found", id)
+}
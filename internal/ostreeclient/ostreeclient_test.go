@@ -0,0 +1,109 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ostreeclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testStatus = `{"deployments": [
+  {"id": "rhcos-aaa.0", "osname": "rhcos", "serial": 0, "checksum": "aaa", "booted": true},
+  {"id": "rhcos_4.14.1-bbb.0", "osname": "rhcos_4.14.1", "serial": 0, "checksum": "bbb"},
+  {"id": "rhcos_4.14.1-bbb.1", "osname": "rhcos_4.14.1", "serial": 1, "checksum": "bbb"}
+]}`
+
+// fakeExecutor records the commands and answers rpm-ostree status with testStatus
+type fakeExecutor struct {
+	commands []string
+}
+
+func (e *fakeExecutor) Execute(_ context.Context, command string, args ...string) (string, error) {
+	line := strings.Join(append([]string{command}, args...), " ")
+	if line == "rpm-ostree status --json" {
+		return testStatus, nil
+	}
+	e.commands = append(e.commands, line)
+	return "", nil
+}
+
+func TestClient(t *testing.T) {
+	executor := &fakeExecutor{}
+	client := NewClient(executor)
+	ctx := context.Background()
+
+	assert.NoError(t, client.CreateStateRoot(ctx, "rhcos_4.14.1"))
+	assert.NoError(t, client.PullLocal(ctx, "/var/tmp/seed/ostree/repo", "bbb"))
+	deployment, err := client.Deploy(ctx, "rhcos_4.14.1", "bbb", []string{"ip=dhcp", "rw"})
+	assert.NoError(t, err)
+	assert.Equal(t, "rhcos_4.14.1-bbb.1", deployment.ID, "the newest deployment of the commit")
+	assert.NoError(t, client.SetDefaultDeployment(ctx, deployment.ID))
+	assert.NoError(t, client.PinDeployment(ctx, "rhcos-aaa.0", true))
+	assert.NoError(t, client.PinDeployment(ctx, "rhcos-aaa.0", false))
+	assert.NoError(t, client.Undeploy(ctx, "rhcos_4.14.1-bbb.0"))
+	assert.ErrorContains(t, client.Undeploy(ctx, "rhcos-ccc.0"), "deployment rhcos-ccc.0 not found")
+
+	assert.Equal(t, []string{
+		"ostree admin os-init rhcos_4.14.1",
+		"ostree pull-local /var/tmp/seed/ostree/repo bbb",
+		"ostree admin deploy --os rhcos_4.14.1 --no-prune --no-merge --retain --not-as-default --karg ip=dhcp --karg rw bbb",
+		"ostree admin set-default 2",
+		"ostree admin pin 0",
+		"ostree admin pin --unpin 0",
+		"ostree admin undeploy 1",
+	}, executor.commands)
+}
+
+func TestFakeClient(t *testing.T) {
+	client := NewFakeClient(Deployment{OSName: "rhcos", Checksum: "aaa", Booted: true})
+	ctx := context.Background()
+
+	_, err := client.Deploy(ctx, "rhcos_4.14.1", "bbb", nil)
+	assert.ErrorContains(t, err, "stateroot rhcos_4.14.1 does not exist")
+	assert.NoError(t, client.CreateStateRoot(ctx, "rhcos_4.14.1"))
+	_, err = client.Deploy(ctx, "rhcos_4.14.1", "bbb", nil)
+	assert.ErrorContains(t, err, "commit bbb not found")
+	assert.NoError(t, client.PullLocal(ctx, "/var/tmp/seed/ostree/repo", "bbb"))
+
+	deployment, err := client.Deploy(ctx, "rhcos_4.14.1", "bbb", nil)
+	assert.NoError(t, err)
+	deployments, _ := client.QueryDeployments(ctx)
+	assert.Equal(t, []string{"rhcos-aaa.0", "rhcos_4.14.1-bbb.0"}, ids(deployments), "not deployed as default")
+
+	assert.NoError(t, client.SetDefaultDeployment(ctx, deployment.ID))
+	assert.NoError(t, client.PinDeployment(ctx, "rhcos-aaa.0", true))
+	deployments, _ = client.QueryDeployments(ctx)
+	assert.Equal(t, []string{"rhcos_4.14.1-bbb.0", "rhcos-aaa.0"}, ids(deployments))
+	assert.True(t, deployments[1].Pinned)
+
+	assert.ErrorContains(t, client.Undeploy(ctx, "rhcos-aaa.0"), "cannot undeploy the booted deployment")
+	assert.NoError(t, client.Undeploy(ctx, deployment.ID))
+	deployments, _ = client.QueryDeployments(ctx)
+	assert.Equal(t, []string{"rhcos-aaa.0"}, ids(deployments))
+	assert.Equal(t, map[string]bool{"rhcos": true, "rhcos_4.14.1": true}, client.StateRoots())
+}
+
+func ids(deployments []Deployment) []string {
+	var ids []string
+	for _, d := range deployments {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package precache pulls container images onto the node ahead of the upgrade
+package precache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+)
+
+// Puller pulls images into the container storage of the node
+type Puller interface {
+	// Exists tells whether the image is already in the container storage
+	Exists(ctx context.Context, image string) (bool, error)
+	// Pull pulls the image
+	Pull(ctx context.Context, image string) error
+	// Size returns an estimate of the disk space the image takes once pulled, without pulling it
+	Size(ctx context.Context, image string) (int64, error)
+	// Tag adds the target name to the pulled source image
+	Tag(ctx context.Context, source, target string) error
+}
+
+// compressionRatio estimates the size of pulled images from their compressed layer sizes
+const compressionRatio = 2
+
+type podmanPuller struct {
+	executor ops.Executor
+	authFile string
+}
+
+// NewPodmanPuller returns a Puller running podman on the host, authenticating with authFile
+func NewPodmanPuller(executor ops.Executor, authFile string) Puller {
+	return &podmanPuller{executor: executor, authFile: authFile}
+}
+
+func (p *podmanPuller) Exists(ctx context.Context, image string) (bool, error) {
+	// podman image exists fails with exit status 1 when the image is missing, other failures are errors
+	result, err := p.executor.Run(ctx, ops.Command{Name: "podman", Args: []string{"image", "exists", image}})
+	if err == nil {
+		return true, nil
+	}
+	if result != nil && result.ExitCode == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check whether image %s exists: %w", image, err)
+}
+
+func (p *podmanPuller) Pull(ctx context.Context, image string) error {
+	args := []string{"pull", "--quiet"}
+	if p.authFile != "" {
+		args = append(args, "--authfile", p.authFile)
+	}
+	_, err := p.executor.Execute(ctx, "podman", append(args, image)...)
+	return err
+}
+
+func (p *podmanPuller) Size(ctx context.Context, image string) (int64, error) {
+	args := []string{"inspect"}
+	if p.authFile != "" {
+		args = append(args, "--authfile", p.authFile)
+	}
+	output, err := p.executor.Execute(ctx, "skopeo", append(args, "docker://"+image)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return ParseInspectSize([]byte(output))
+}
+
+func (p *podmanPuller) Tag(ctx context.Context, source, target string) error {
+	_, err := p.executor.Execute(ctx, "podman", "tag", source, target)
+	return err
+}
+
+// ParseInspectSize returns the estimated pulled size of an image from the output of skopeo inspect
+func ParseInspectSize(output []byte) (int64, error) {
+	inspect := struct {
+		LayersData []struct {
+			Size int64 `json:"Size"`
+		} `json:"LayersData"`
+	}{}
+	if err := json.Unmarshal(output, &inspect); err != nil {
+		return 0, fmt.Errorf("failed to parse skopeo inspect output: %w", err)
+	}
+	var size int64
+	for _, layer := range inspect.LayersData {
+		size += layer.Size
+	}
+	return size * compressionRatio, nil
+}
+
+// Config tunes a precache run
+type Config struct {
+	// Parallelism is the number of images pulled at the same time
+	Parallelism int
+	// Retries is the number of times a failed pull is retried
+	Retries int
+	// RetryDelay is the wait before the first retry, doubled on every retry
+	RetryDelay time.Duration
+	// Resolve, if set, returns the references to try in order to pull an image, such as its mirrors
+	Resolve func(image string) []string
+}
+
+// Outcome is the result of the precaching of one image
+type Outcome string
+
+const (
+	Pulled  Outcome = "Pulled"
+	Skipped Outcome = "Skipped"
+	Failed  Outcome = "Failed"
+)
+
+// Result is the result of the precaching of one image
+type Result struct {
+	Image   string
+	Outcome Outcome
+	// Attempts is the number of pulls tried, every attempt tries all the references of the image
+	Attempts int
+	// PulledFrom is the reference the image was pulled from, a mirror or the image itself
+	PulledFrom string
+	Err        error
+}
+
+// ParseImageList returns the images listed in the data of a ConfigMap: one image per line in any key,
+// blank lines and lines starting with # are ignored. Images are deduplicated and ordered by key.
+func ParseImageList(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var images []string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		scanner := bufio.NewScanner(strings.NewReader(data[key]))
+		for scanner.Scan() {
+			image := strings.TrimSpace(scanner.Text())
+			if image == "" || strings.HasPrefix(image, "#") || seen[image] {
+				continue
+			}
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// Run precaches the images and returns their results in the order of images. onResult, if set,
+// is called as every image completes, possibly from several goroutines at once.
+func Run(ctx context.Context, puller Puller, images []string, config Config, onResult func(Result)) []Result {
+	parallelism := config.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]Result, len(images))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = precacheImage(ctx, puller, images[i], config)
+				if onResult != nil {
+					onResult(results[i])
+				}
+			}
+		}()
+	}
+	for i := range images {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func precacheImage(ctx context.Context, puller Puller, image string, config Config) Result {
+	result := Result{Image: image}
+	if exists, err := puller.Exists(ctx, image); err == nil && exists {
+		result.Outcome = Skipped
+		return result
+	}
+
+	candidates := []string{image}
+	if config.Resolve != nil {
+		candidates = config.Resolve(image)
+	}
+	delay := config.RetryDelay
+	for {
+		result.Attempts++
+		if result.PulledFrom, result.Err = pullFirst(ctx, puller, image, candidates); result.Err == nil {
+			result.Outcome = Pulled
+			return result
+		}
+		if result.Attempts > config.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			result.Err = fmt.Errorf("%w, last error: %s", ctx.Err(), result.Err)
+			result.Outcome = Failed
+			return result
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	result.Outcome = Failed
+	return result
+}
+
+// pullFirst pulls the image from the first candidate reference that has it and returns that reference
+func pullFirst(ctx context.Context, puller Puller, image string, candidates []string) (string, error) {
+	var errs []string
+	for _, candidate := range candidates {
+		err := puller.Pull(ctx, candidate)
+		if err == nil && candidate != image && !strings.Contains(image, "@") {
+			// The workloads look tagged images up by their own name, digests match any name
+			err = puller.Tag(ctx, candidate, image)
+		}
+		if err == nil {
+			return candidate, nil
+		}
+		if len(candidates) == 1 {
+			return "", err
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", candidate, err))
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package precache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+)
+
+// fakePuller fails the pulls of an image as many times as set in failures
+type fakePuller struct {
+	mu          sync.Mutex
+	present     map[string]bool
+	failures    map[string]int
+	pulls       map[string]int
+	running     int
+	maxParallel int
+	tags        []string
+}
+
+func (p *fakePuller) Exists(ctx context.Context, image string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.present[image], nil
+}
+
+func (p *fakePuller) Pull(ctx context.Context, image string) error {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.maxParallel {
+		p.maxParallel = p.running
+	}
+	p.pulls[image]++
+	fail := p.pulls[image] <= p.failures[image]
+	p.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	if fail {
+		return fmt.Errorf("pull %s failed", image)
+	}
+	return nil
+}
+
+func (p *fakePuller) Size(ctx context.Context, image string) (int64, error) {
+	return 0, nil
+}
+
+func (p *fakePuller) Tag(ctx context.Context, source, target string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tags = append(p.tags, source+" "+target)
+	return nil
+}
+
+func TestPodmanPullerExists(t *testing.T) {
+	executor := &ops.MockExecutor{Results: map[string]ops.Result{
+		"podman image exists quay.io/missing:1": {ExitCode: 1},
+		"podman image exists quay.io/broken:1":  {ExitCode: 125, Stderr: "database is locked"},
+	}}
+	puller := NewPodmanPuller(executor, "")
+
+	exists, err := puller.Exists(context.TODO(), "quay.io/present:1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = puller.Exists(context.TODO(), "quay.io/missing:1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Only a missing image is reported as such, other failures of podman are errors
+	_, err = puller.Exists(context.TODO(), "quay.io/broken:1")
+	assert.ErrorContains(t, err, "failed to check whether image quay.io/broken:1 exists")
+	assert.ErrorContains(t, err, "exit status 125: database is locked")
+}
+
+func TestParseInspectSize(t *testing.T) {
+	size, err := ParseInspectSize([]byte(`{"Name": "quay.io/ran/du", "LayersData": [{"Size": 1000}, {"Size": 24}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2048), size)
+	_, err = ParseInspectSize([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestParseImageList(t *testing.T) {
+	images := ParseImageList(map[string]string{
+		"workloads": "quay.io/ran/du:1.0\n\n# the CU\n  quay.io/ran/cu:1.0  \nquay.io/ran/du:1.0\n",
+		"operators": "registry.redhat.io/sriov@sha256:1234",
+	})
+	assert.Equal(t, []string{"registry.redhat.io/sriov@sha256:1234", "quay.io/ran/du:1.0", "quay.io/ran/cu:1.0"}, images)
+	assert.Empty(t, ParseImageList(nil))
+}
+
+func TestRun(t *testing.T) {
+	var images []string
+	for i := 0; i < 8; i++ {
+		images = append(images, fmt.Sprintf("quay.io/ran/image:%d", i))
+	}
+	puller := &fakePuller{
+		present:  map[string]bool{images[0]: true},
+		failures: map[string]int{images[1]: 1, images[2]: 5},
+		pulls:    map[string]int{},
+	}
+	var mu sync.Mutex
+	var reported int
+	results := Run(context.Background(), puller, images, Config{Parallelism: 3, Retries: 2, RetryDelay: time.Millisecond}, func(Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported++
+	})
+
+	assert.Equal(t, len(images), reported)
+	assert.LessOrEqual(t, puller.maxParallel, 3)
+	assert.Greater(t, puller.maxParallel, 1)
+
+	assert.Equal(t, Result{Image: images[0], Outcome: Skipped}, results[0])
+	assert.Equal(t, Result{Image: images[1], Outcome: Pulled, Attempts: 2, PulledFrom: images[1]}, results[1])
+	assert.Equal(t, Failed, results[2].Outcome)
+	assert.Equal(t, 3, results[2].Attempts, "one pull and two retries")
+	assert.EqualError(t, results[2].Err, "pull quay.io/ran/image:2 failed")
+	for _, result := range results[3:] {
+		assert.Equal(t, Pulled, result.Outcome)
+		assert.Equal(t, 1, result.Attempts)
+	}
+}
+
+func TestRunMirrors(t *testing.T) {
+	puller := &fakePuller{
+		failures: map[string]int{"mirror.local/ran/du:1.0": 5, "mirror.local/ran/cu@sha256:1234": 5, "backup.local/ran/cu@sha256:1234": 5, "quay.io/ran/cu@sha256:1234": 5},
+		pulls:    map[string]int{},
+	}
+	resolve := func(image string) []string {
+		return []string{strings.Replace(image, "quay.io", "mirror.local", 1), "backup.local/" + strings.TrimPrefix(image, "quay.io/"), image}
+	}
+	results := Run(context.Background(), puller, []string{"quay.io/ran/du:1.0", "quay.io/ran/ru@sha256:abcd", "quay.io/ran/cu@sha256:1234"},
+		Config{Resolve: resolve}, nil)
+
+	assert.Equal(t, Result{Image: "quay.io/ran/du:1.0", Outcome: Pulled, Attempts: 1, PulledFrom: "backup.local/ran/du:1.0"}, results[0])
+	assert.Equal(t, "mirror.local/ran/ru@sha256:abcd", results[1].PulledFrom)
+	assert.Equal(t, []string{"backup.local/ran/du:1.0 quay.io/ran/du:1.0"}, puller.tags, "only tags are added")
+	assert.Equal(t, Failed, results[2].Outcome)
+	assert.EqualError(t, results[2].Err, "mirror.local/ran/cu@sha256:1234: pull mirror.local/ran/cu@sha256:1234 failed; "+
+		"backup.local/ran/cu@sha256:1234: pull backup.local/ran/cu@sha256:1234 failed; "+
+		"quay.io/ran/cu@sha256:1234: pull quay.io/ran/cu@sha256:1234 failed")
+}
+
+func TestRunCancelled(t *testing.T) {
+	puller := &fakePuller{failures: map[string]int{"quay.io/ran/du:1.0": 5}, pulls: map[string]int{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := Run(ctx, puller, []string{"quay.io/ran/du:1.0"}, Config{Retries: 5, RetryDelay: time.Hour}, nil)
+	assert.Equal(t, Failed, results[0].Outcome)
+	assert.ErrorIs(t, results[0].Err, context.Canceled)
+}
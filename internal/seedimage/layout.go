@@ -0,0 +1,342 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seedimage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Media types of the OCI image spec, and of the docker ones sharing the same layout
+const (
+	mediaTypeImageIndex         = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	refNameAnnotation           = "org.opencontainers.image.ref.name"
+	whiteoutPrefix              = ".wh."
+)
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+type index struct {
+	MediaType string       `json:"mediaType,omitempty"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+type manifest struct {
+	MediaType string       `json:"mediaType,omitempty"`
+	Manifests []descriptor `json:"manifests,omitempty"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// LayoutClient reads seed images from OCI image layout directories, such as the ones written by
+// skopeo copy docker://<image> oci:<dir>:<tag>. Nothing is downloaded, which suits disconnected
+// nodes and tests.
+type LayoutClient struct {
+	root string
+}
+
+// NewLayoutClient returns a LayoutClient resolving the layout directories under root
+func NewLayoutClient(root string) *LayoutClient {
+	return &LayoutClient{root: root}
+}
+
+// parseLayoutReference splits oci:<dir>[:<tag>|@<digest>] into its directory, tag and digest
+func parseLayoutReference(reference string) (dir, tag, digest string, err error) {
+	dir = strings.TrimPrefix(reference, OCILayoutTransport)
+	if i := strings.LastIndex(dir, "@"); i >= 0 {
+		dir, digest = dir[:i], dir[i+1:]
+	} else if i := strings.LastIndex(dir, ":"); i >= 0 {
+		dir, tag = dir[:i], dir[i+1:]
+	}
+	if dir == "" {
+		return "", "", "", fmt.Errorf("invalid OCI layout reference %q: missing directory", reference)
+	}
+	return dir, tag, digest, nil
+}
+
+// Pull implements Client. The image already is on the node, it is only checked and measured.
+func (c *LayoutClient) Pull(_ context.Context, reference string) (*Image, error) {
+	dir, digest, m, err := c.resolve(reference)
+	if err != nil {
+		return nil, err
+	}
+	_, size, err := readLayersFile(dir, m.Layers, MetadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("seed image %s: %w", reference, err)
+	}
+	return &Image{Reference: reference, Digest: digest, Size: size}, nil
+}
+
+// Size implements Client, the layout already is on the node
+func (c *LayoutClient) Size(ctx context.Context, reference string) (int64, error) {
+	image, err := c.Pull(ctx, reference)
+	if err != nil {
+		return 0, err
+	}
+	return image.Size, nil
+}
+
+// Metadata implements Client
+func (c *LayoutClient) Metadata(_ context.Context, reference string) (*Metadata, error) {
+	dir, _, m, err := c.resolve(reference)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := readLayersFile(dir, m.Layers, MetadataPath)
+	if err != nil {
+		return nil, fmt.Errorf("seed image %s: %w", reference, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("seed image %s: %s not found in the image", reference, MetadataPath)
+	}
+	metadata, err := ParseMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("seed image %s: %w", reference, err)
+	}
+	return metadata, nil
+}
+
+// resolve returns the layout directory of the reference, and the digest and manifest of the image
+func (c *LayoutClient) resolve(reference string) (string, string, *manifest, error) {
+	dir, tag, digest, err := parseLayoutReference(reference)
+	if err != nil {
+		return "", "", nil, err
+	}
+	dir = filepath.Join(c.root, dir)
+
+	if digest == "" {
+		if digest, err = resolveTag(dir, tag); err != nil {
+			return "", "", nil, fmt.Errorf("seed image %s: %w", reference, err)
+		}
+	}
+	m, err := readManifest(dir, digest)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("seed image %s: %w", reference, err)
+	}
+	return dir, digest, m, nil
+}
+
+// Exists implements Client, the layout is present when its manifest can be read
+func (c *LayoutClient) Exists(_ context.Context, reference string) (bool, error) {
+	dir, tag, digest, err := parseLayoutReference(reference)
+	if err != nil {
+		return false, err
+	}
+	dir = filepath.Join(c.root, dir)
+	if digest == "" {
+		if digest, err = resolveTag(dir, tag); err != nil {
+			return false, nil
+		}
+	}
+	_, err = readManifest(dir, digest)
+	return err == nil, nil
+}
+
+// Verify implements Client. Layout directories hold no signatures, so they never pass verification.
+func (c *LayoutClient) Verify(_ context.Context, reference string, _ Policy) error {
+	return fmt.Errorf("the signature of seed image %s cannot be verified, OCI layouts hold no signatures", reference)
+}
+
+// resolveTag returns the digest of the manifest tagged tag in the layout index.
+// An empty tag selects the only manifest of the index.
+func resolveTag(dir, tag string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+	idx := index{}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return "", fmt.Errorf("failed to parse OCI layout index: %w", err)
+	}
+	if tag == "" {
+		if len(idx.Manifests) != 1 {
+			return "", fmt.Errorf("OCI layout has %d images, a tag is required", len(idx.Manifests))
+		}
+		return idx.Manifests[0].Digest, nil
+	}
+	for _, d := range idx.Manifests {
+		if d.Annotations[refNameAnnotation] == tag {
+			return d.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("tag %s not found in OCI layout", tag)
+}
+
+// readManifest reads the image manifest with the given digest, resolving image indexes to the manifest of this platform
+func readManifest(dir, digest string) (*manifest, error) {
+	data, err := readBlob(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", digest, err)
+	}
+	if m.MediaType != mediaTypeImageIndex && m.MediaType != mediaTypeDockerManifestList && len(m.Manifests) == 0 {
+		return m, nil
+	}
+	for _, d := range m.Manifests {
+		if d.Platform != nil && d.Platform.OS == "linux" && d.Platform.Architecture == runtime.GOARCH {
+			return readManifest(dir, d.Digest)
+		}
+	}
+	return nil, fmt.Errorf("image index %s has no manifest for linux/%s", digest, runtime.GOARCH)
+}
+
+func blobPath(dir, digest string) (string, error) {
+	algorithm, encoded, found := strings.Cut(digest, ":")
+	if !found || algorithm != "sha256" || len(encoded) != sha256.Size*2 {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	return filepath.Join(dir, "blobs", algorithm, encoded), nil
+}
+
+func checkDigest(h hash.Hash, digest string) error {
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, actual)
+	}
+	return nil
+}
+
+// readBlob reads a blob and checks it matches its digest
+func readBlob(dir, digest string) ([]byte, error) {
+	p, err := blobPath(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	h := sha256.New()
+	h.Write(data)
+	if err := checkDigest(h, digest); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// layerFile is what readLayerFile found in a layer
+type layerFile struct {
+	// content is the content of the file, nil if the layer deletes it
+	content []byte
+	// found is true when the layer adds or deletes the file
+	found bool
+	// size is the size of the regular files of the layer
+	size int64
+}
+
+// readLayersFile returns the content of the file at target in the filesystem the layers stack up to, nil if
+// there is none, and the size of the files of all the layers
+func readLayersFile(dir string, layers []descriptor, target string) ([]byte, int64, error) {
+	target = strings.TrimPrefix(path.Clean("/"+target), "/")
+	var content []byte
+	var size int64
+	for _, layer := range layers {
+		file, err := readLayerFile(dir, layer, target)
+		if err != nil {
+			return nil, 0, err
+		}
+		size += file.size
+		if file.found {
+			// Upper layers win, a nil content means the file was deleted by a whiteout
+			content = file.content
+		}
+	}
+	return content, size, nil
+}
+
+func readLayerFile(dir string, layer descriptor, target string) (layerFile, error) {
+	file := layerFile{}
+	p, err := blobPath(dir, layer.Digest)
+	if err != nil {
+		return file, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return file, fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	blob := io.TeeReader(f, h)
+	reader := blob
+	if strings.HasSuffix(layer.MediaType, "gzip") {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return file, fmt.Errorf("failed to decompress layer %s: %w", layer.Digest, err)
+		}
+		defer gz.Close()
+		reader = gz
+	} else if strings.HasSuffix(layer.MediaType, "zstd") {
+		return file, fmt.Errorf("layer %s: zstd compression is not supported", layer.Digest)
+	}
+
+	whiteout := path.Join(path.Dir(target), whiteoutPrefix+path.Base(target))
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return file, fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			file.size += header.Size
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		switch {
+		case name == target && header.Typeflag == tar.TypeReg:
+			if file.content, err = io.ReadAll(tr); err != nil {
+				return file, fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+			}
+			file.found = true
+		case name == whiteout:
+			file.content, file.found = nil, true
+		}
+	}
+	// Drain the blob so that the whole of it is checked against its digest
+	if _, err := io.Copy(io.Discard, blob); err != nil {
+		return file, fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+	}
+	if err := checkDigest(h, layer.Digest); err != nil {
+		return file, err
+	}
+	return file, nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seedimage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/precache"
+)
+
+// PodmanClient pulls images into the container storage of the host with podman
+type PodmanClient struct {
+	executor ops.Executor
+	hostPath string
+	authFile string
+}
+
+// NewPodmanClient returns a PodmanClient authenticating with authFile, a path on the host.
+// Image mounts are read through hostPath, where the host filesystem is mounted.
+func NewPodmanClient(executor ops.Executor, hostPath, authFile string) *PodmanClient {
+	return &PodmanClient{executor: executor, hostPath: hostPath, authFile: authFile}
+}
+
+// Pull implements Client. Digest references are pulled as is, so the image cannot change under them.
+func (c *PodmanClient) Pull(ctx context.Context, reference string) (*Image, error) {
+	exists, err := c.Exists(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		args := []string{"pull", "--quiet"}
+		if c.authFile != "" {
+			args = append(args, "--authfile", c.authFile)
+		}
+		if _, err := c.executor.Execute(ctx, "podman", append(args, reference)...); err != nil {
+			return nil, fmt.Errorf("failed to pull seed image %s: %w", reference, err)
+		}
+	}
+
+	inspect, err := c.executor.Execute(ctx, "podman", "image", "inspect", "--format", "{{.Digest}} {{.Size}}", reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect seed image %s: %w", reference, err)
+	}
+	digest, sizeField, _ := strings.Cut(inspect, " ")
+	size, err := strconv.ParseInt(sizeField, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the size of seed image %s: %w", reference, err)
+	}
+	return &Image{Reference: reference, Digest: digest, Size: size}, nil
+}
+
+// Metadata implements Client
+func (c *PodmanClient) Metadata(ctx context.Context, reference string) (*Metadata, error) {
+	data, err := c.readFile(ctx, reference, MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := ParseMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("seed image %s: %w", reference, err)
+	}
+	return metadata, nil
+}
+
+// Size implements Client, skopeo inspects the image in its registry
+func (c *PodmanClient) Size(ctx context.Context, reference string) (int64, error) {
+	args := []string{"inspect"}
+	if c.authFile != "" {
+		args = append(args, "--authfile", c.authFile)
+	}
+	output, err := c.executor.Execute(ctx, "skopeo", append(args, "docker://"+reference)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect seed image %s: %w", reference, err)
+	}
+	return precache.ParseInspectSize([]byte(output))
+}
+
+// Exists implements Client
+func (c *PodmanClient) Exists(ctx context.Context, reference string) (bool, error) {
+	// podman image exists fails with exit status 1 when the image is missing, other failures are errors
+	result, err := c.executor.Run(ctx, ops.Command{Name: "podman", Args: []string{"image", "exists", reference}})
+	if err == nil {
+		return true, nil
+	}
+	if result != nil && result.ExitCode == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check whether image %s exists: %w", reference, err)
+}
+
+// Verify implements Client. skopeo copies the image onto itself in the container storage, which checks the
+// signatures in the registry against the policy and reuses the layers already pulled. The reference goes
+// through the mirrors of the registries configuration of the host, under the identity it was signed with.
+func (c *PodmanClient) Verify(ctx context.Context, reference string, policy Policy) error {
+	args := []string{"copy", "--quiet", "--policy", policy.Path}
+	if policy.RegistriesDir != "" {
+		args = append(args, "--registries.d", policy.RegistriesDir)
+	}
+	if c.authFile != "" {
+		args = append(args, "--authfile", c.authFile)
+	}
+	args = append(args, "docker://"+reference, "containers-storage:"+reference)
+	if _, err := c.executor.Execute(ctx, "skopeo", args...); err != nil {
+		return fmt.Errorf("failed to verify the signature of seed image %s: %w", reference, err)
+	}
+	return nil
+}
+
+// readFile reads a file of the image by mounting it, seed images do not need to have a shell to run
+func (c *PodmanClient) readFile(ctx context.Context, reference, path string) ([]byte, error) {
+	mountPoint, err := c.executor.Execute(ctx, "podman", "image", "mount", reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mount seed image %s: %w", reference, err)
+	}
+	defer func() {
+		// Use a fresh context, the image must be unmounted even if ctx expired
+		_, _ = c.executor.Execute(context.Background(), "podman", "image", "unmount", reference)
+	}()
+
+	data, err := os.ReadFile(filepath.Join(c.hostPath, mountPoint, path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from seed image %s: %w", path, reference, err)
+	}
+	return data, nil
+}
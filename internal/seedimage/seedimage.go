@@ -0,0 +1,177 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package seedimage pulls seed images and reads the metadata they carry
+package seedimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+)
+
+// MetadataPath is the path of the seed metadata document inside a seed image
+const MetadataPath = "/var/lib/lca/seed/metadata.json"
+
+// OCILayoutTransport prefixes the references of images stored in an OCI layout directory on the host
+const OCILayoutTransport = "oci:"
+
+// Metadata describes the cluster a seed image was built from
+type Metadata struct {
+	// Version is the OCP version of the seed cluster
+	Version string `json:"version"`
+	// ReleaseImage is the OCP release image of the seed cluster
+	ReleaseImage string `json:"releaseImage"`
+	// Architecture is the CPU architecture of the seed cluster, as in GOARCH
+	Architecture string `json:"architecture"`
+	// BuildTime is when the seed image was built
+	BuildTime time.Time `json:"buildTime"`
+	// SeedCluster identifies the seed cluster
+	SeedCluster ClusterInfo `json:"seedCluster"`
+	// Properties are the properties of the seed cluster that the upgraded clusters must share,
+	// they are not recorded by older seed images
+	Properties *ClusterProperties `json:"properties,omitempty"`
+}
+
+// ClusterInfo identifies the cluster a seed image was built from
+type ClusterInfo struct {
+	ClusterID   string `json:"clusterID,omitempty"`
+	ClusterName string `json:"clusterName,omitempty"`
+	BaseDomain  string `json:"baseDomain,omitempty"`
+	NodeName    string `json:"nodeName,omitempty"`
+}
+
+// ClusterProperties are the cluster properties compared between a seed and the cluster it upgrades
+type ClusterProperties struct {
+	// NetworkType is the cluster network plugin, e.g. OVNKubernetes
+	NetworkType string `json:"networkType,omitempty"`
+	// IPFamilies are the IP families of the cluster network, IPv4 and/or IPv6
+	IPFamilies []string `json:"ipFamilies,omitempty"`
+	// FIPS is true when the node runs in FIPS mode
+	FIPS bool `json:"fips,omitempty"`
+	// KernelArgs are the kernel arguments of the node, without the deployment specific ones
+	KernelArgs []string `json:"kernelArgs,omitempty"`
+	// Operators are the OLM operators installed in the cluster
+	Operators []Operator `json:"operators,omitempty"`
+	// SeparateFilesystems lists the directories that are mounted from their own partition, e.g. /var/lib/containers
+	SeparateFilesystems []string `json:"separateFilesystems,omitempty"`
+	// PerformanceProfiles are the names of the PerformanceProfiles of the cluster
+	PerformanceProfiles []string `json:"performanceProfiles,omitempty"`
+}
+
+// Operator is an OLM operator and its installed version
+type Operator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ParseMetadata parses and validates a seed metadata document
+func ParseMetadata(data []byte) (*Metadata, error) {
+	metadata := &Metadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse seed metadata: %w", err)
+	}
+	if metadata.Version == "" {
+		return nil, fmt.Errorf("seed metadata has no version")
+	}
+	return metadata, nil
+}
+
+// Image is a seed image available on the node
+type Image struct {
+	// Reference is the image reference as given by the user
+	Reference string
+	// Digest is the digest of the image manifest
+	Digest string
+	// Size is the uncompressed size of the image, about the space its content takes once deployed
+	Size int64
+}
+
+// Client makes seed images available on the node and inspects them
+type Client interface {
+	// Pull pulls the image, unless it is already present, without reading anything from it
+	Pull(ctx context.Context, reference string) (*Image, error)
+	// Metadata reads the seed metadata document of an image pulled before
+	Metadata(ctx context.Context, reference string) (*Metadata, error)
+	// Size returns an estimate of the disk space the image takes once pulled, from its registry without pulling it
+	Size(ctx context.Context, reference string) (int64, error)
+	// Exists tells whether the image is present on the node, without pulling it
+	Exists(ctx context.Context, reference string) (bool, error)
+	// Verify checks the signature of the image in its registry against the policy
+	Verify(ctx context.Context, reference string, policy Policy) error
+}
+
+// Policy is a signature verification policy written on the host
+type Policy struct {
+	// Path is the containers-policy.json(5) file
+	Path string
+	// RegistriesDir, if set, replaces the containers-registries.d(5) directory of the host,
+	// where the signatures are looked up
+	RegistriesDir string
+}
+
+type client struct {
+	podman *PodmanClient
+	layout *LayoutClient
+}
+
+// NewClient returns a Client pulling registry images with podman on the host and reading
+// oci: references from the OCI layout directories of the host filesystem mounted at hostPath
+func NewClient(executor ops.Executor, hostPath, authFile string) Client {
+	return &client{
+		podman: NewPodmanClient(executor, hostPath, authFile),
+		layout: NewLayoutClient(hostPath),
+	}
+}
+
+func (c *client) Pull(ctx context.Context, reference string) (*Image, error) {
+	if strings.HasPrefix(reference, OCILayoutTransport) {
+		return c.layout.Pull(ctx, reference)
+	}
+	return c.podman.Pull(ctx, reference)
+}
+
+func (c *client) Metadata(ctx context.Context, reference string) (*Metadata, error) {
+	if strings.HasPrefix(reference, OCILayoutTransport) {
+		return c.layout.Metadata(ctx, reference)
+	}
+	return c.podman.Metadata(ctx, reference)
+}
+
+func (c *client) Size(ctx context.Context, reference string) (int64, error) {
+	if strings.HasPrefix(reference, OCILayoutTransport) {
+		return c.layout.Size(ctx, reference)
+	}
+	return c.podman.Size(ctx, reference)
+}
+
+func (c *client) Verify(ctx context.Context, reference string, policy Policy) error {
+	if strings.HasPrefix(reference, OCILayoutTransport) {
+		return c.layout.Verify(ctx, reference, policy)
+	}
+	return c.podman.Verify(ctx, reference, policy)
+}
+
+func (c *client) Exists(ctx context.Context, reference string) (bool, error) {
+	if strings.HasPrefix(reference, OCILayoutTransport) {
+		return c.layout.Exists(ctx, reference)
+	}
+	return c.podman.Exists(ctx, reference)
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package seedimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+)
+
+const testMetadata = `{
+  "version": "4.14.1",
+  "releaseImage": "quay.io/openshift-release-dev/ocp-release:4.14.1-x86_64",
+  "architecture": "amd64",
+  "buildTime": "2023-11-02T10:00:00Z",
+  "seedCluster": {"clusterID": "1234", "clusterName": "seed", "baseDomain": "example.com", "nodeName": "seed-node"}
+}`
+
+// writeBlob stores data in the layout and returns its descriptor
+func writeBlob(t *testing.T, dir, mediaType string, data []byte) descriptor {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", hex.EncodeToString(sum[:])), data, 0o644))
+	return descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// layer returns a gzipped tar of the given files, a nil content adds a whiteout
+func layer(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if content == nil {
+			name = filepath.Join(filepath.Dir(name), whiteoutPrefix+filepath.Base(name))
+		}
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// writeLayout writes an OCI layout holding one image made of the given layers and returns the manifest digest
+func writeLayout(t *testing.T, dir, tag string, layers ...[]byte) string {
+	m := manifest{MediaType: "application/vnd.oci.image.manifest.v1+json"}
+	for _, l := range layers {
+		m.Layers = append(m.Layers, writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", l))
+	}
+	data, err := json.Marshal(m)
+	assert.NoError(t, err)
+	d := writeBlob(t, dir, m.MediaType, data)
+	d.Annotations = map[string]string{refNameAnnotation: tag}
+	data, err = json.Marshal(index{Manifests: []descriptor{d}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), data, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644))
+	return d.Digest
+}
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := ParseMetadata([]byte(testMetadata))
+	assert.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		Version:      "4.14.1",
+		ReleaseImage: "quay.io/openshift-release-dev/ocp-release:4.14.1-x86_64",
+		Architecture: "amd64",
+		BuildTime:    time.Date(2023, 11, 2, 10, 0, 0, 0, time.UTC),
+		SeedCluster:  ClusterInfo{ClusterID: "1234", ClusterName: "seed", BaseDomain: "example.com", NodeName: "seed-node"},
+	}, metadata)
+
+	_, err = ParseMetadata([]byte(`{"releaseImage": "quay.io/release"}`))
+	assert.ErrorContains(t, err, "no version")
+	_, err = ParseMetadata([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestParseLayoutReference(t *testing.T) {
+	testcases := []struct {
+		reference              string
+		dir, tag, digest, fail string
+	}{
+		{reference: "oci:/var/seed", dir: "/var/seed"},
+		{reference: "oci:/var/seed:4.14", dir: "/var/seed", tag: "4.14"},
+		{reference: "oci:/var/seed@sha256:abcd", dir: "/var/seed", digest: "sha256:abcd"},
+		{reference: "oci::latest", fail: "missing directory"},
+	}
+	for _, tc := range testcases {
+		dir, tag, digest, err := parseLayoutReference(tc.reference)
+		if tc.fail != "" {
+			assert.ErrorContains(t, err, tc.fail, tc.reference)
+			continue
+		}
+		assert.NoError(t, err, tc.reference)
+		assert.Equal(t, []string{tc.dir, tc.tag, tc.digest}, []string{dir, tag, digest}, tc.reference)
+	}
+}
+
+func TestLayoutClientPull(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "var", "seed")
+	digest := writeLayout(t, dir, "4.14.1",
+		layer(t, map[string][]byte{"etc/hostname": []byte("seed"), "var/lib/lca/seed/metadata.json": []byte(`{"version": "4.13.0"}`)}),
+		layer(t, map[string][]byte{"./var/lib/lca/seed/metadata.json": []byte(testMetadata)}),
+	)
+	client := NewLayoutClient(root)
+
+	for _, reference := range []string{"oci:/var/seed", "oci:/var/seed:4.14.1", "oci:/var/seed@" + digest} {
+		image, err := client.Pull(context.Background(), reference)
+		assert.NoError(t, err, reference)
+		assert.Equal(t, digest, image.Digest, reference)
+		assert.Equal(t, reference, image.Reference)
+		assert.Equal(t, int64(len("seed")+len(`{"version": "4.13.0"}`)+len(testMetadata)), image.Size)
+
+		metadata, err := client.Metadata(context.Background(), reference)
+		assert.NoError(t, err, reference)
+		assert.Equal(t, "4.14.1", metadata.Version, "the upper layer wins")
+	}
+
+	exists, err := client.Exists(context.Background(), "oci:/var/seed:4.14.1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = client.Exists(context.Background(), "oci:/var/seed:4.15.0")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = client.Pull(context.Background(), "oci:/var/seed:4.15.0")
+	assert.ErrorContains(t, err, "tag 4.15.0 not found")
+	_, err = client.Pull(context.Background(), "oci:/var/seed@sha256:"+strings.Repeat("0", 64))
+	assert.ErrorContains(t, err, "failed to read blob")
+}
+
+func TestLayoutClientPullErrors(t *testing.T) {
+	root := t.TempDir()
+	writeLayout(t, filepath.Join(root, "deleted"), "latest",
+		layer(t, map[string][]byte{"var/lib/lca/seed/metadata.json": []byte(testMetadata)}),
+		layer(t, map[string][]byte{"var/lib/lca/seed/metadata.json": nil}),
+	)
+	writeLayout(t, filepath.Join(root, "missing"), "latest", layer(t, map[string][]byte{"etc/hostname": []byte("seed")}))
+	digest := writeLayout(t, filepath.Join(root, "corrupted"), "latest",
+		layer(t, map[string][]byte{"var/lib/lca/seed/metadata.json": []byte(testMetadata)}))
+	// Tamper with the layer referenced by the manifest
+	data, err := readBlob(filepath.Join(root, "corrupted"), digest)
+	assert.NoError(t, err)
+	m := manifest{}
+	assert.NoError(t, json.Unmarshal(data, &m))
+	p, _ := blobPath(filepath.Join(root, "corrupted"), m.Layers[0].Digest)
+	assert.NoError(t, os.WriteFile(p, layer(t, map[string][]byte{"var/lib/lca/seed/metadata.json": []byte(`{"version": "4.15.0"}`)}), 0o644))
+
+	client := NewLayoutClient(root)
+	_, err = client.Metadata(context.Background(), "oci:/deleted")
+	assert.ErrorContains(t, err, "not found in the image")
+	_, err = client.Metadata(context.Background(), "oci:/missing")
+	assert.ErrorContains(t, err, "not found in the image")
+	_, err = client.Pull(context.Background(), "oci:/corrupted")
+	assert.ErrorContains(t, err, "has digest")
+	_, err = client.Metadata(context.Background(), "oci:/corrupted")
+	assert.ErrorContains(t, err, "has digest")
+	_, err = client.Pull(context.Background(), "oci:/nothing")
+	assert.ErrorContains(t, err, "failed to read OCI layout index")
+}
+
+// fakeExecutor records the commands and mounts images under a directory
+type fakeExecutor struct {
+	commands []string
+	present  bool
+	// storageErr fails podman image exists as when the container storage cannot be read
+	storageErr bool
+	mount      string
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, command string, args ...string) (string, error) {
+	result, err := e.Run(ctx, ops.Command{Name: command, Args: args})
+	return result.Stdout, err
+}
+
+func (e *fakeExecutor) Run(_ context.Context, command ops.Command) (*ops.Result, error) {
+	line := command.String()
+	e.commands = append(e.commands, line)
+	switch {
+	case strings.HasPrefix(line, "podman image exists"):
+		if e.storageErr {
+			return &ops.Result{ExitCode: 125}, fmt.Errorf("exit status 125")
+		}
+		if !e.present {
+			return &ops.Result{ExitCode: 1}, fmt.Errorf("exit status 1")
+		}
+	case strings.HasPrefix(line, "podman image inspect"):
+		return &ops.Result{Stdout: "sha256:1234 8192"}, nil
+	case strings.HasPrefix(line, "podman image mount"):
+		return &ops.Result{Stdout: e.mount}, nil
+	}
+	return &ops.Result{}, nil
+}
+
+func TestPodmanClient(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "mnt", "var", "lib", "lca", "seed"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "mnt", MetadataPath), []byte(testMetadata), 0o644))
+
+	executor := &fakeExecutor{mount: "/mnt"}
+	client := NewClient(executor, root, "/var/lib/kubelet/config.json")
+	image, err := client.Pull(context.Background(), "quay.io/seed@sha256:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:1234", image.Digest)
+	assert.Equal(t, int64(8192), image.Size)
+	assert.Equal(t, []string{
+		"podman image exists quay.io/seed@sha256:1234",
+		"podman pull --quiet --authfile /var/lib/kubelet/config.json quay.io/seed@sha256:1234",
+		"podman image inspect --format {{.Digest}} {{.Size}} quay.io/seed@sha256:1234",
+	}, executor.commands, "pulling reads nothing from the image")
+
+	executor.commands = nil
+	metadata, err := client.Metadata(context.Background(), "quay.io/seed@sha256:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "4.14.1", metadata.Version)
+	assert.Equal(t, []string{
+		"podman image mount quay.io/seed@sha256:1234",
+		"podman image unmount quay.io/seed@sha256:1234",
+	}, executor.commands)
+
+	executor = &fakeExecutor{mount: "/mnt", present: true}
+	_, err = NewClient(executor, root, "").Pull(context.Background(), "quay.io/seed:4.14.1")
+	assert.NoError(t, err)
+	assert.NotContains(t, strings.Join(executor.commands, "\n"), "podman pull", "present images are not pulled again")
+
+	executor = &fakeExecutor{mount: "/elsewhere"}
+	_, err = NewClient(executor, root, "").Metadata(context.Background(), "quay.io/seed:4.14.1")
+	assert.ErrorContains(t, err, "failed to read")
+	assert.Contains(t, executor.commands, "podman image unmount quay.io/seed:4.14.1", "the image is unmounted on failure")
+
+	// Only a missing image is reported as such, other failures of podman are errors
+	exists, err := NewClient(&fakeExecutor{present: true}, root, "").Exists(context.Background(), "quay.io/seed:4.14.1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = NewClient(&fakeExecutor{}, root, "").Exists(context.Background(), "quay.io/seed:4.14.1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	executor = &fakeExecutor{storageErr: true}
+	_, err = NewClient(executor, root, "").Exists(context.Background(), "quay.io/seed:4.14.1")
+	assert.EqualError(t, err, "failed to check whether image quay.io/seed:4.14.1 exists: exit status 125")
+	_, err = NewClient(executor, root, "").Pull(context.Background(), "quay.io/seed:4.14.1")
+	assert.Error(t, err)
+	assert.NotContains(t, strings.Join(executor.commands, "\n"), "podman pull", "nothing is pulled when the storage cannot be read")
+}
+
+func TestClientVerify(t *testing.T) {
+	executor := &fakeExecutor{}
+	client := NewClient(executor, t.TempDir(), "/var/lib/lca/pull-secret.json")
+	policy := Policy{Path: "/var/lib/lca/signature-policy/policy.json", RegistriesDir: "/var/lib/lca/signature-policy/registries.d"}
+	assert.NoError(t, client.Verify(context.Background(), "quay.io/seed:4.14.1", policy))
+	assert.Equal(t, []string{
+		"skopeo copy --quiet --policy /var/lib/lca/signature-policy/policy.json --registries.d /var/lib/lca/signature-policy/registries.d " +
+			"--authfile /var/lib/lca/pull-secret.json docker://quay.io/seed:4.14.1 containers-storage:quay.io/seed:4.14.1",
+	}, executor.commands)
+
+	err := client.Verify(context.Background(), "oci:/var/seed:4.14.1", policy)
+	assert.ErrorContains(t, err, "OCI layouts hold no signatures")
+}
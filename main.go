@@ -19,22 +19,34 @@ package main
 import (
 	"flag"
 	"os"
+	"path/filepath"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	ranv1alpha1 "github.com/openshift-kni/lifecycle-agent/api/v1alpha1"
+	ranv1beta1 "github.com/openshift-kni/lifecycle-agent/api/v1beta1"
 	"github.com/openshift-kni/lifecycle-agent/controllers"
+	"github.com/openshift-kni/lifecycle-agent/controllers/utils"
+	"github.com/openshift-kni/lifecycle-agent/controllers/webhooks"
+	"github.com/openshift-kni/lifecycle-agent/internal/ops"
+	"github.com/openshift-kni/lifecycle-agent/internal/ostreeclient"
+	"github.com/openshift-kni/lifecycle-agent/internal/precache"
+	"github.com/openshift-kni/lifecycle-agent/internal/seedimage"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -47,6 +59,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(ranv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(ranv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -54,11 +67,20 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var prepTimeout, upgradeTimeout, rollbackTimeout, abortTimeout, finalizeTimeout time.Duration
+	var onTimeout string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&prepTimeout, "prep-timeout", 2*time.Hour, "Default timeout of the Prep stage, 0 to disable.")
+	flag.DurationVar(&upgradeTimeout, "upgrade-timeout", 2*time.Hour, "Default timeout of the Upgrade stage, 0 to disable.")
+	flag.DurationVar(&rollbackTimeout, "rollback-timeout", time.Hour, "Default timeout of the Rollback stage, 0 to disable.")
+	flag.DurationVar(&abortTimeout, "abort-timeout", 30*time.Minute, "Default timeout of an abort, 0 to disable.")
+	flag.DurationVar(&finalizeTimeout, "finalize-timeout", 30*time.Minute, "Default timeout of a finalize, 0 to disable.")
+	flag.StringVar(&onTimeout, "on-timeout", string(ranv1alpha1.TimeoutActions.None),
+		"Default action when a stage times out: None, Abort or Rollback.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -84,14 +106,58 @@ func main() {
 		os.Exit(1)
 	}
 
+	namespace := os.Getenv("NAMESPACE")
+	if namespace == "" {
+		namespace = utils.LCANamespace
+	}
+	audit := ops.NewAuditLog(ctrl.Log.WithName("audit"), filepath.Join(utils.HostPath, utils.HostCommandAuditLog))
+	executor := ops.NewHostExecutor(ctrl.Log.WithName("ops"), utils.HostPath, audit)
 	if err = (&controllers.ImageBasedUpgradeReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ClusterGroupUpgrade"),
-		Scheme: mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		APIReader:       mgr.GetAPIReader(),
+		Log:             ctrl.Log.WithName("controllers").WithName("ClusterGroupUpgrade"),
+		Scheme:          mgr.GetScheme(),
+		Namespace:       namespace,
+		Executor:        executor,
+		OstreeClient:    ostreeclient.NewClient(executor),
+		SeedImageClient: seedimage.NewClient(executor, utils.HostPath, utils.PullSecretFile),
+		ImagePuller:     precache.NewPodmanPuller(executor, utils.PullSecretFile),
+		ClientAs: func(user string) (client.Client, error) {
+			config := rest.CopyConfig(mgr.GetConfig())
+			config.Impersonate = rest.ImpersonationConfig{UserName: user}
+			return client.New(config, client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+		},
+		DefaultTimeouts: ranv1alpha1.StageTimeouts{
+			Prep:      flagTimeout(prepTimeout),
+			Upgrade:   flagTimeout(upgradeTimeout),
+			Rollback:  flagTimeout(rollbackTimeout),
+			Abort:     flagTimeout(abortTimeout),
+			Finalize:  flagTimeout(finalizeTimeout),
+			OnTimeout: ranv1alpha1.TimeoutAction(onTimeout),
+		},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterGroupUpgrade")
 		os.Exit(1)
 	}
+
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&webhooks.ImageBasedUpgradeDefaulter{
+			Log: ctrl.Log.WithName("webhooks").WithName("ImageBasedUpgrade"),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ImageBasedUpgrade")
+			os.Exit(1)
+		}
+		if err = (&webhooks.ImageBasedUpgradeValidator{
+			Log: ctrl.Log.WithName("webhooks").WithName("ImageBasedUpgrade"),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ImageBasedUpgrade")
+			os.Exit(1)
+		}
+		if err = (&ranv1beta1.ImageBasedUpgrade{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create conversion webhook", "webhook", "ImageBasedUpgrade")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -109,3 +175,11 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// flagTimeout converts a timeout flag, where 0 disables the timeout
+func flagTimeout(timeout time.Duration) *metav1.Duration {
+	if timeout <= 0 {
+		return nil
+	}
+	return &metav1.Duration{Duration: timeout}
+}